@@ -0,0 +1,345 @@
+package acl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	pool              *helper.KeyedPool
+	stopCh            chan struct{}
+	// committed index of the token and policy watchers, lastChangeIndex being the lowest of them
+	tokenIndex     uint64
+	policyIndex    uint64
+	lock           sync.Mutex
+	tokens         map[string]*nomad.ACLTokenListStub
+	policies       map[string]*nomad.ACLPolicyListStub
+	skipAnonymous  bool
+	skipManagement bool
+}
+
+// ACLUpdate ...
+type ACLUpdate struct {
+	Type   string
+	Token  *nomad.ACLTokenListStub `json:",omitempty"`
+	Policy *nomad.ACLPolicy        `json:",omitempty"`
+}
+
+// NewFirehose ...
+func NewFirehose(region string, workers int, skipAnonymous, skipManagement bool) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		pool:              helper.NewKeyedPool(workers),
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		tokens:            make(map[string]*nomad.ACLTokenListStub),
+		policies:          make(map[string]*nomad.ACLPolicyListStub),
+		skipAnonymous:     skipAnonymous,
+		skipManagement:    skipManagement,
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "acl"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+
+	f.tokenIndex = f.lastChangeIndex
+	f.policyIndex = f.lastChangeIndex
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for ACL token and policy changes
+	go f.watchTokens()
+	go f.watchPolicies()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.restoreIndex()
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.restoreIndex()
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *ACLUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// restoreIndex is the index to persist
+func (f *Firehose) restoreIndex() uint64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.lastChangeIndex
+}
+
+// updateLastChangeIndex commit the index of the token or policy watcher, the restore point being
+// the lowest of the two so the lagging watcher doesn't lose its events on restart
+func (f *Firehose) updateLastChangeIndex(index *uint64, newIndex uint64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	*index = newIndex
+
+	f.lastChangeIndex = f.tokenIndex
+	if f.policyIndex < f.lastChangeIndex {
+		f.lastChangeIndex = f.policyIndex
+	}
+}
+
+// skipToken returns true for tokens filtered out as noise
+func (f *Firehose) skipToken(token *nomad.ACLTokenListStub) bool {
+	if f.skipAnonymous && token.AccessorID == "anonymous" {
+		return true
+	}
+
+	if f.skipManagement && token.Type == "management" {
+		return true
+	}
+
+	return false
+}
+
+// Continously watch for changes to the ACL token list and publish it as updates
+//
+// Only the token list stub is ever published, it never contains the token SecretID
+func (f *Firehose) watchTokens() {
+	q := &nomad.QueryOptions{
+		WaitIndex:  f.tokenIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	lastIndex := f.tokenIndex
+	newMax := f.tokenIndex
+
+	for {
+		tokens, meta, err := f.nomadClient.ACLTokens().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch ACL tokens: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("ACL tokens index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("ACL tokens index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		current := make(map[string]*nomad.ACLTokenListStub)
+
+		// Iterate tokens and find events that have changed since last run
+		for _, token := range tokens {
+			current[token.AccessorID] = token
+
+			if token.ModifyIndex <= lastIndex {
+				continue
+			}
+
+			if token.ModifyIndex > newMax {
+				newMax = token.ModifyIndex
+			}
+
+			if f.skipToken(token) {
+				continue
+			}
+
+			if token.CreateIndex > lastIndex {
+				f.Publish(&ACLUpdate{Type: "token-created", Token: token})
+				continue
+			}
+
+			f.Publish(&ACLUpdate{Type: "token-modified", Token: token})
+		}
+
+		// Tokens we knew about that are no longer listed have been deleted
+		for accessorID, token := range f.tokens {
+			if _, ok := current[accessorID]; ok || f.skipToken(token) {
+				continue
+			}
+
+			f.Publish(&ACLUpdate{Type: "token-deleted", Token: token})
+		}
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		lastIndex = newMax
+		f.tokens = current
+		f.updateLastChangeIndex(&f.tokenIndex, newMax)
+	}
+}
+
+// Continously watch for changes to the ACL policy list and publish it as updates
+func (f *Firehose) watchPolicies() {
+	q := &nomad.QueryOptions{
+		WaitIndex:  f.policyIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	lastIndex := f.policyIndex
+	newMax := f.policyIndex
+
+	for {
+		policies, meta, err := f.nomadClient.ACLPolicies().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch ACL policies: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("ACL policies index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("ACL policies index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		current := make(map[string]*nomad.ACLPolicyListStub)
+
+		batch := f.pool.Batch()
+
+		// Iterate policies and find events that have changed since last run
+		for _, policy := range policies {
+			current[policy.Name] = policy
+
+			if policy.ModifyIndex <= lastIndex {
+				continue
+			}
+
+			if policy.ModifyIndex > newMax {
+				newMax = policy.ModifyIndex
+			}
+
+			updateType := "policy-modified"
+			if policy.CreateIndex > lastIndex {
+				updateType = "policy-created"
+			}
+
+			updateType, name := updateType, policy.Name
+			batch.Go(name, func() {
+				fullPolicy, _, err := f.nomadClient.ACLPolicies().Info(name, &nomad.QueryOptions{})
+				if err != nil {
+					log.Errorf("Could not read ACL policy %s: %s", name, err)
+					return
+				}
+
+				f.Publish(&ACLUpdate{Type: updateType, Policy: fullPolicy})
+			})
+		}
+
+		// wait for the events to be published, so a sink that is slow or down holds back the
+		// watcher instead of piling up goroutines
+		batch.Wait()
+
+		// Policies we knew about that are no longer listed have been deleted
+		for name, policy := range f.policies {
+			if _, ok := current[name]; ok {
+				continue
+			}
+
+			f.Publish(&ACLUpdate{
+				Type: "policy-deleted",
+				Policy: &nomad.ACLPolicy{
+					Name:        policy.Name,
+					Description: policy.Description,
+					CreateIndex: policy.CreateIndex,
+					ModifyIndex: policy.ModifyIndex,
+				},
+			})
+		}
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		lastIndex = newMax
+		f.policies = current
+		f.updateLastChangeIndex(&f.policyIndex, newMax)
+	}
+}
@@ -3,9 +3,11 @@ package allocations
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
 	"github.com/seatgeek/nomad-firehose/sink"
 	log "github.com/sirupsen/logrus"
 )
@@ -17,6 +19,10 @@ type Firehose struct {
 	nomadClient      *nomad.Client
 	sink             sink.Sink
 	stopCh           chan struct{}
+	namespaces       []string
+	// committed event time of every namespace watcher, lastChangeTime being the lowest of them
+	times map[string]int64
+	lock  sync.Mutex
 }
 
 // AllocationUpdate ...
@@ -24,6 +30,7 @@ type AllocationUpdate struct {
 	Name               string
 	NodeID             string
 	AllocationID       string
+	Namespace          string
 	DesiredStatus      string
 	DesiredDescription string
 	ClientStatus       string
@@ -40,13 +47,13 @@ type AllocationUpdate struct {
 }
 
 // NewFirehose ...
-func NewFirehose() (*Firehose, error) {
-	nomadClient, err := nomad.NewClient(nomad.DefaultConfig())
+func NewFirehose(region string, namespaces []string) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
 	if err != nil {
 		return nil, err
 	}
 
-	sink, err := sink.GetSink()
+	sink, err := sink.GetSink(region)
 	if err != nil {
 		return nil, err
 	}
@@ -56,6 +63,8 @@ func NewFirehose() (*Firehose, error) {
 		sink:             sink,
 		stopCh:           make(chan struct{}, 1),
 		lastChangeTimeCh: make(chan interface{}, 1),
+		namespaces:       namespaces,
+		times:            make(map[string]int64),
 	}, nil
 }
 
@@ -83,11 +92,17 @@ func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
 func (f *Firehose) Start() {
 	go f.sink.Start()
 
-	// Stop chan for all tasks to depend on
-	f.stopCh = make(chan struct{})
+	// watch for allocation changes, with a wait index per namespace, all starting from the
+	// restore point
+	f.lock.Lock()
+	for _, namespace := range f.namespaces {
+		f.times[namespace] = f.lastChangeTime
+	}
+	f.lock.Unlock()
 
-	// watch for allocation changes
-	go f.watch()
+	for _, namespace := range f.namespaces {
+		go f.watch(namespace)
+	}
 
 	// Save the last event time every 5s
 	go f.persistLastChangeTime(5 * time.Second)
@@ -116,10 +131,16 @@ func (f *Firehose) persistLastChangeTime(interval time.Duration) {
 	for {
 		select {
 		case <-f.stopCh:
-			f.lastChangeTimeCh <- f.lastChangeTime
+			value := f.restoreTime()
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
 			break
 		case <-ticker.C:
-			f.lastChangeTimeCh <- f.lastChangeTime
+			value := f.restoreTime()
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
 		}
 	}
 }
@@ -134,20 +155,55 @@ func (f *Firehose) publish(update *AllocationUpdate) {
 	f.sink.Put(b)
 }
 
-// Continously watch for changes to the allocation list and publish it as updates
-func (f *Firehose) watch() {
+// restoreTime is the event time to persist
+func (f *Firehose) restoreTime() int64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.lastChangeTime
+}
+
+// namespaceTime is the committed event time of a namespace watcher
+func (f *Firehose) namespaceTime(namespace string) int64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.times[namespace]
+}
+
+// updateLastChangeTime commit the event time of a namespace watcher, the restore point being the
+// lowest committed time so a lagging namespace doesn't lose its events on restart
+func (f *Firehose) updateLastChangeTime(namespace string, eventTime int64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.times[namespace] = eventTime
+
+	lowest := eventTime
+	for _, committed := range f.times {
+		if committed < lowest {
+			lowest = committed
+		}
+	}
+	f.lastChangeTime = lowest
+}
+
+// Continously watch for changes to the allocation list of a namespace and publish it as updates
+func (f *Firehose) watch(namespace string) {
 	q := &nomad.QueryOptions{
+		Namespace:  namespace,
 		WaitIndex:  1,
 		WaitTime:   5 * time.Minute,
 		AllowStale: true,
 	}
 
-	newMax := f.lastChangeTime
+	lastTime := f.namespaceTime(namespace)
+	newMax := lastTime
 
 	for {
 		allocations, meta, err := f.nomadClient.Allocations().List(q)
 		if err != nil {
-			log.Errorf("Unable to fetch allocations: %s", err)
+			log.Errorf("Unable to fetch allocations in namespace '%s': %s", namespace, err)
 			time.Sleep(10 * time.Second)
 			continue
 		}
@@ -157,17 +213,17 @@ func (f *Firehose) watch() {
 
 		// Only work if the WaitIndex have changed
 		if remoteWaitIndex == localWaitIndex {
-			log.Debugf("Allocations index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			log.Debugf("Allocations index of namespace '%s' is unchanged (%d == %d)", namespace, remoteWaitIndex, localWaitIndex)
 			continue
 		}
 
-		log.Debugf("Allocations index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+		log.Debugf("Allocations index of namespace '%s' is changed (%d <> %d)", namespace, remoteWaitIndex, localWaitIndex)
 
 		// Iterate allocations and find events that have changed since last run
 		for _, allocation := range allocations {
 			for taskName, taskInfo := range allocation.TaskStates {
 				for _, taskEvent := range taskInfo.Events {
-					if taskEvent.Time <= f.lastChangeTime {
+					if taskEvent.Time <= lastTime {
 						continue
 					}
 
@@ -179,6 +235,7 @@ func (f *Firehose) watch() {
 						Name:               allocation.Name,
 						NodeID:             allocation.NodeID,
 						AllocationID:       allocation.ID,
+						Namespace:          allocation.Namespace,
 						EvalID:             allocation.EvalID,
 						DesiredStatus:      allocation.DesiredStatus,
 						DesiredDescription: allocation.DesiredDescription,
@@ -201,6 +258,7 @@ func (f *Firehose) watch() {
 
 		// Update WaitIndex and Last Change Time for next iteration
 		q.WaitIndex = meta.LastIndex
-		f.lastChangeTime = newMax
+		lastTime = newMax
+		f.updateLastChangeTime(namespace, newMax)
 	}
 }
@@ -0,0 +1,206 @@
+package allocstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastSampleTime   int64
+	lastSampleTimeCh chan interface{}
+	nomadClient      *nomad.Client
+	sink             sink.Sink
+	pool             *helper.KeyedPool
+	stopCh           chan struct{}
+	interval         time.Duration
+}
+
+// AllocationStats ...
+type AllocationStats struct {
+	AllocationID string
+	Name         string
+	JobID        string
+	Namespace    string
+	NodeID       string
+	GroupName    string
+	Timestamp    int64
+	Usage        *nomad.AllocResourceUsage
+}
+
+// NewFirehose ...
+func NewFirehose(region string, workers int, interval time.Duration) (*Firehose, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("Invalid sample interval '%s', must be positive", interval)
+	}
+
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:      nomadClient,
+		sink:             sink,
+		pool:             helper.NewKeyedPool(workers),
+		stopCh:           make(chan struct{}, 1),
+		lastSampleTimeCh: make(chan interface{}, 1),
+		interval:         interval,
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "allocation-stats"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastSampleTimeCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastSampleTime = int64(restoreValue.(int))
+	case int64:
+		f.lastSampleTime = restoreValue.(int64)
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// sample allocation stats
+	go f.watch()
+
+	// Save the last sample time every 5s
+	go f.persistLastSampleTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Sample Time to Consul so if the process restarts,
+// it will wait for the next interval rather than sampling right away
+func (f *Firehose) persistLastSampleTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastSampleTime
+			if sink.Acknowledged(f.sink) {
+				f.lastSampleTimeCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastSampleTime
+			if sink.Acknowledged(f.sink) {
+				f.lastSampleTimeCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *AllocationStats) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// Periodically sample the resource usage of all running allocations and publish it as updates
+func (f *Firehose) watch() {
+	// resume the sampling schedule from the last sample
+	wait := f.interval - time.Since(time.Unix(0, f.lastSampleTime))
+	if wait < 0 {
+		wait = 0
+	}
+
+	timer := time.NewTimer(wait)
+
+	for {
+		select {
+		case <-f.stopCh:
+			timer.Stop()
+			return
+
+		case <-timer.C:
+			f.lastSampleTime = time.Now().UnixNano()
+			f.sample()
+			timer.Reset(f.interval)
+		}
+	}
+}
+
+// sample the resource usage of all running allocations
+func (f *Firehose) sample() {
+	allocations, _, err := f.nomadClient.Allocations().List(&nomad.QueryOptions{Namespace: "*", AllowStale: true})
+	if err != nil {
+		log.Errorf("Unable to fetch allocations: %s", err)
+		return
+	}
+
+	// allocations are sampled by the workers of the pool in parallel
+	batch := f.pool.Batch()
+
+	for _, allocation := range allocations {
+		if allocation.ClientStatus != "running" {
+			continue
+		}
+
+		allocationID, namespace := allocation.ID, allocation.Namespace
+		batch.Go(allocationID, func() {
+			fullAllocation, _, err := f.nomadClient.Allocations().Info(allocationID, &nomad.QueryOptions{Namespace: namespace})
+			if err != nil {
+				log.Errorf("Could not read allocation %s: %s", allocationID, err)
+				return
+			}
+
+			usage, err := f.nomadClient.Allocations().Stats(fullAllocation, &nomad.QueryOptions{Namespace: namespace})
+			if err != nil {
+				log.Errorf("Could not read stats of allocation %s: %s", allocationID, err)
+				return
+			}
+
+			f.Publish(&AllocationStats{
+				AllocationID: fullAllocation.ID,
+				Name:         fullAllocation.Name,
+				JobID:        fullAllocation.JobID,
+				Namespace:    fullAllocation.Namespace,
+				NodeID:       fullAllocation.NodeID,
+				GroupName:    fullAllocation.TaskGroup,
+				Timestamp:    usage.Timestamp,
+				Usage:        usage,
+			})
+		})
+	}
+
+	batch.Wait()
+}
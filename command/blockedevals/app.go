@@ -0,0 +1,239 @@
+package blockedevals
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	stopCh            chan struct{}
+	threshold         time.Duration
+	reported          map[string]*PlacementStarved
+}
+
+// PlacementStarved ...
+type PlacementStarved struct {
+	Type           string
+	EvalID         string
+	Namespace      string
+	JobID          string
+	TriggeredBy    string
+	PreviousEvalID string
+	BlockedSince   time.Time
+	BlockedFor     string
+	FailedTGAllocs map[string]*nomad.AllocationMetric `json:",omitempty"`
+}
+
+// NewFirehose ...
+func NewFirehose(region string, threshold time.Duration) (*Firehose, error) {
+	if threshold <= 0 {
+		return nil, fmt.Errorf("Invalid blocked threshold '%s', must be positive", threshold)
+	}
+
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		threshold:         threshold,
+		reported:          make(map[string]*PlacementStarved),
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "blocked-evaluations"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for blocked evaluations
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *PlacementStarved) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// Periodically check the blocked evaluations, and publish an update for those blocked
+// longer than the threshold, and once they are no longer blocked
+func (f *Firehose) watch() {
+	interval := f.threshold / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		f.check()
+
+		select {
+		case <-f.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check the blocked evaluations once
+func (f *Firehose) check() {
+	evaluations, meta, err := f.nomadClient.Evaluations().List(&nomad.QueryOptions{
+		Namespace:  "*",
+		Filter:     `Status == "blocked"`,
+		AllowStale: true,
+	})
+	if err != nil {
+		log.Errorf("Unable to fetch blocked evaluations: %s", err)
+		return
+	}
+
+	blocked := make(map[string]bool)
+
+	for _, evaluation := range evaluations {
+		if evaluation.Status != "blocked" {
+			continue
+		}
+		blocked[evaluation.ID] = true
+
+		if _, ok := f.reported[evaluation.ID]; ok {
+			continue
+		}
+
+		since := time.Unix(0, evaluation.CreateTime)
+		if time.Since(since) < f.threshold {
+			continue
+		}
+
+		update := &PlacementStarved{
+			Type:           "placement-starved",
+			EvalID:         evaluation.ID,
+			Namespace:      evaluation.Namespace,
+			JobID:          evaluation.JobID,
+			TriggeredBy:    evaluation.TriggeredBy,
+			PreviousEvalID: evaluation.PreviousEval,
+			BlockedSince:   since.UTC(),
+			BlockedFor:     time.Since(since).Round(time.Second).String(),
+			FailedTGAllocs: evaluation.FailedTGAllocs,
+		}
+
+		// the placement failures are recorded on the evaluation that created the blocked one
+		if evaluation.PreviousEval != "" {
+			previous, _, err := f.nomadClient.Evaluations().Info(evaluation.PreviousEval, &nomad.QueryOptions{Namespace: evaluation.Namespace})
+			if err != nil {
+				log.Errorf("Could not read evaluation %s: %s", evaluation.PreviousEval, err)
+			} else if len(previous.FailedTGAllocs) > 0 {
+				update.FailedTGAllocs = previous.FailedTGAllocs
+			}
+		}
+
+		f.reported[evaluation.ID] = update
+		f.Publish(update)
+	}
+
+	// evaluations we reported as starved that are no longer blocked got their placements,
+	// or were cancelled
+	for id, update := range f.reported {
+		if blocked[id] {
+			continue
+		}
+
+		delete(f.reported, id)
+
+		f.Publish(&PlacementStarved{
+			Type:           "placement-resolved",
+			EvalID:         update.EvalID,
+			Namespace:      update.Namespace,
+			JobID:          update.JobID,
+			TriggeredBy:    update.TriggeredBy,
+			PreviousEvalID: update.PreviousEvalID,
+			BlockedSince:   update.BlockedSince,
+			BlockedFor:     time.Since(update.BlockedSince).Round(time.Second).String(),
+		})
+	}
+
+	f.lastChangeIndex = meta.LastIndex
+}
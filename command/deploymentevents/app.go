@@ -0,0 +1,255 @@
+package deploymentevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	stopCh            chan struct{}
+	deployments       map[string]*nomad.Deployment
+}
+
+// DeploymentEvent ...
+type DeploymentEvent struct {
+	Type              string
+	DeploymentID      string
+	Namespace         string
+	JobID             string
+	JobVersion        uint64
+	TaskGroup         string `json:",omitempty"`
+	Status            string
+	StatusDescription string
+	ModifyIndex       uint64
+}
+
+// NewFirehose ...
+func NewFirehose(region string) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		deployments:       make(map[string]*nomad.Deployment),
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "deployment-events"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for deployment changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *DeploymentEvent) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+func newDeploymentEvent(eventType, taskGroup string, deployment *nomad.Deployment) *DeploymentEvent {
+	return &DeploymentEvent{
+		Type:              eventType,
+		DeploymentID:      deployment.ID,
+		Namespace:         deployment.Namespace,
+		JobID:             deployment.JobID,
+		JobVersion:        deployment.JobVersion,
+		TaskGroup:         taskGroup,
+		Status:            deployment.Status,
+		StatusDescription: deployment.StatusDescription,
+		ModifyIndex:       deployment.ModifyIndex,
+	}
+}
+
+// classify returns the lifecycle events that happened between the previous and current state
+// of a deployment
+func classify(previous, current *nomad.Deployment) []*DeploymentEvent {
+	var events []*DeploymentEvent
+
+	// canary promotions are tracked per task group
+	for name, group := range current.TaskGroups {
+		previousGroup, ok := previous.TaskGroups[name]
+		if group.Promoted && (!ok || !previousGroup.Promoted) {
+			events = append(events, newDeploymentEvent("promoted", name, current))
+		}
+	}
+
+	if previous.Status == current.Status {
+		return events
+	}
+
+	switch current.Status {
+	case "paused":
+		events = append(events, newDeploymentEvent("paused", "", current))
+	case "running":
+		if previous.Status == "paused" {
+			events = append(events, newDeploymentEvent("resumed", "", current))
+		}
+	case "successful":
+		events = append(events, newDeploymentEvent("successful", "", current))
+	case "cancelled":
+		events = append(events, newDeploymentEvent("cancelled", "", current))
+	case "failed":
+		events = append(events, newDeploymentEvent("failed", "", current))
+
+		// nomad tells in the description when a failed deployment triggers an auto revert
+		if strings.Contains(current.StatusDescription, "rolling back") {
+			events = append(events, newDeploymentEvent("auto-reverted", "", current))
+		}
+	}
+
+	return events
+}
+
+// Continously watch for changes to the deployment list and publish it as updates
+func (f *Firehose) watch() {
+	q := &nomad.QueryOptions{
+		Namespace:  "*",
+		WaitIndex:  f.lastChangeIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.lastChangeIndex
+
+	for {
+		deployments, meta, err := f.nomadClient.Deployments().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch deployments: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Deployments index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Deployments index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		current := make(map[string]*nomad.Deployment)
+
+		// Iterate deployments and classify the changes since last run
+		for _, deployment := range deployments {
+			current[deployment.ID] = deployment
+
+			if deployment.ModifyIndex <= f.lastChangeIndex {
+				continue
+			}
+
+			if deployment.ModifyIndex > newMax {
+				newMax = deployment.ModifyIndex
+			}
+
+			previous, ok := f.deployments[deployment.ID]
+			if !ok {
+				// only brand new deployments can be compared with an empty state
+				if deployment.CreateIndex <= f.lastChangeIndex {
+					continue
+				}
+
+				f.Publish(newDeploymentEvent("started", "", deployment))
+				previous = &nomad.Deployment{}
+			}
+
+			for _, event := range classify(previous, deployment) {
+				f.Publish(event)
+			}
+		}
+
+		f.deployments = current
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.lastChangeIndex = newMax
+	}
+}
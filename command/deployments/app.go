@@ -3,11 +3,11 @@ package deployments
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"strconv"
 	"time"
 
 	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
 	"github.com/seatgeek/nomad-firehose/sink"
 	log "github.com/sirupsen/logrus"
 )
@@ -18,25 +18,27 @@ type Firehose struct {
 	lastChangeTimeCh chan interface{}
 	nomadClient      *nomad.Client
 	sink             sink.Sink
+	pool             *helper.KeyedPool
 	stopCh           chan struct{}
 }
 
 // NewFirehose ...
-func NewFirehose() (*Firehose, error) {
-	nomadClient, err := nomad.NewClient(nomad.DefaultConfig())
+func NewFirehose(region string, workers int) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
 	if err != nil {
 		return nil, err
 	}
 
-	sink, err := sink.GetSink()
+	sink, err := sink.GetSink(region)
 	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
+		return nil, err
 	}
 
 	return &Firehose{
 		nomadClient:      nomadClient,
 		sink:             sink,
+		pool:             helper.NewKeyedPool(workers),
+		stopCh:           make(chan struct{}, 1),
 		lastChangeTimeCh: make(chan interface{}, 1),
 	}, nil
 }
@@ -69,9 +71,6 @@ func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
 func (f *Firehose) Start() {
 	go f.sink.Start()
 
-	// Stop chan for all tasks to depend on
-	f.stopCh = make(chan struct{})
-
 	// watch for deployment changes
 	go f.watch()
 
@@ -102,10 +101,16 @@ func (f *Firehose) persistLastChangeTime(interval time.Duration) {
 	for {
 		select {
 		case <-f.stopCh:
-			f.lastChangeTimeCh <- f.lastChangeTime
+			value := f.lastChangeTime
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
 			break
 		case <-ticker.C:
-			f.lastChangeTimeCh <- f.lastChangeTime
+			value := f.lastChangeTime
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
 		}
 	}
 }
@@ -123,12 +128,12 @@ func (f *Firehose) Publish(update *nomad.Deployment) {
 // Continously watch for changes to the deployment list and publish it as updates
 func (f *Firehose) watch() {
 	q := &nomad.QueryOptions{
-		WaitIndex:  uint64(f.lastChangeTime),
+		WaitIndex:  f.lastChangeTime,
 		WaitTime:   5 * time.Minute,
 		AllowStale: true,
 	}
 
-	newMax := uint64(f.lastChangeTime)
+	newMax := f.lastChangeTime
 
 	for {
 		deployments, meta, err := f.nomadClient.Deployments().List(q)
@@ -149,6 +154,8 @@ func (f *Firehose) watch() {
 
 		log.Debugf("Deployments index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
 
+		batch := f.pool.Batch()
+
 		// Iterate deployments and find events that have changed since last run
 		for _, deployment := range deployments {
 			if deployment.ModifyIndex <= f.lastChangeTime {
@@ -159,17 +166,22 @@ func (f *Firehose) watch() {
 				newMax = deployment.ModifyIndex
 			}
 
-			go func(DeploymentID string) {
-				fullDeployment, _, err := f.nomadClient.Deployments().Info(DeploymentID, &nomad.QueryOptions{})
+			deploymentID := deployment.ID
+			batch.Go(deploymentID, func() {
+				fullDeployment, _, err := f.nomadClient.Deployments().Info(deploymentID, &nomad.QueryOptions{})
 				if err != nil {
-					log.Errorf("Could not read deployment %s: %s", DeploymentID, err)
+					log.Errorf("Could not read deployment %s: %s", deploymentID, err)
 					return
 				}
 
 				f.Publish(fullDeployment)
-			}(deployment.ID)
+			})
 		}
 
+		// wait for the events to be published, so a sink that is slow or down holds back the
+		// watcher instead of piling up goroutines
+		batch.Wait()
+
 		// Update WaitIndex and Last Change Time for next iteration
 		q.WaitIndex = meta.LastIndex
 		f.lastChangeTime = newMax
@@ -0,0 +1,269 @@
+package dispatches
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	pool              *helper.KeyedPool
+	stopCh            chan struct{}
+	redactMeta        map[string]bool
+}
+
+// dispatchSuffix is what Nomad append to the parent job ID when dispatching a parameterized job
+const dispatchSuffix = "/dispatch-"
+
+// redactedValue replace the value of redacted meta keys
+const redactedValue = "<redacted>"
+
+// Dispatch ...
+type Dispatch struct {
+	ParentID     string
+	JobID        string
+	Namespace    string
+	DispatchTime time.Time
+	Meta         map[string]string
+	PayloadSize  int
+	PayloadKeys  []string `json:",omitempty"`
+}
+
+// NewFirehose ...
+func NewFirehose(region string, workers int, redactMeta []string) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	redact := make(map[string]bool)
+	for _, key := range redactMeta {
+		if key = strings.TrimSpace(key); key != "" {
+			redact[key] = true
+		}
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		pool:              helper.NewKeyedPool(workers),
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		redactMeta:        redact,
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "dispatches"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for job changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *Dispatch) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// redact replace the value of the configured meta keys, "*" redact all of them
+func (f *Firehose) redact(meta map[string]string) map[string]string {
+	result := make(map[string]string, len(meta))
+
+	for key, value := range meta {
+		if f.redactMeta["*"] || f.redactMeta[key] {
+			value = redactedValue
+		}
+
+		result[key] = value
+	}
+
+	return result
+}
+
+// decodePayload uncompress a dispatch payload, Nomad storing them snappy compressed
+func decodePayload(payload []byte) ([]byte, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	return snappy.Decode(nil, payload)
+}
+
+// payloadKeys return the sorted top level keys of a JSON object payload, the payload itself
+// is never published
+func payloadKeys(payload []byte) []string {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &object); err != nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Continously watch for changes to the job list and publish it as updates
+func (f *Firehose) watch() {
+	q := &nomad.QueryOptions{
+		Namespace:  "*",
+		WaitIndex:  f.lastChangeIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.lastChangeIndex
+
+	for {
+		jobs, meta, err := f.nomadClient.Jobs().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch jobs: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Jobs index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Jobs index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		batch := f.pool.Batch()
+
+		// Iterate jobs and find dispatched jobs created since last run
+		for _, job := range jobs {
+			if job.ModifyIndex > newMax {
+				newMax = job.ModifyIndex
+			}
+
+			if job.CreateIndex <= f.lastChangeIndex {
+				continue
+			}
+
+			if job.ParentID == "" || !strings.HasPrefix(job.ID, job.ParentID+dispatchSuffix) {
+				continue
+			}
+
+			job := job
+			batch.Go(job.Namespace+"/"+job.ID, func() {
+				fullJob, _, err := f.nomadClient.Jobs().Info(job.ID, &nomad.QueryOptions{Namespace: job.Namespace})
+				if err != nil {
+					log.Errorf("Could not read job %s/%s: %s", job.Namespace, job.ID, err)
+					return
+				}
+
+				payload, err := decodePayload(fullJob.Payload)
+				if err != nil {
+					log.Errorf("Could not decode the payload of job %s/%s: %s", job.Namespace, job.ID, err)
+				}
+
+				f.Publish(&Dispatch{
+					ParentID:     job.ParentID,
+					JobID:        job.ID,
+					Namespace:    job.Namespace,
+					DispatchTime: time.Unix(0, job.SubmitTime).UTC(),
+					Meta:         f.redact(fullJob.Meta),
+					PayloadSize:  len(payload),
+					PayloadKeys:  payloadKeys(payload),
+				})
+			})
+		}
+
+		// wait for the events to be published, so a sink that is slow or down holds back the
+		// watcher instead of piling up goroutines
+		batch.Wait()
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.lastChangeIndex = newMax
+	}
+}
@@ -0,0 +1,38 @@
+package dispatches
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+func TestDecodePayload(t *testing.T) {
+	raw := []byte(`{"region": "us-east-1", "count": 3, "image": "app:1.2.3"}`)
+
+	payload, err := decodePayload(snappy.Encode(nil, raw))
+	if err != nil {
+		t.Fatalf("decodePayload: %s", err)
+	}
+	if len(payload) != len(raw) {
+		t.Errorf("expected a payload of %d bytes, got %d", len(raw), len(payload))
+	}
+
+	expected := []string{"count", "image", "region"}
+	if keys := payloadKeys(payload); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected keys %v, got %v", expected, keys)
+	}
+}
+
+func TestDecodePayloadEmpty(t *testing.T) {
+	payload, err := decodePayload(nil)
+	if err != nil || payload != nil {
+		t.Errorf("expected no payload, got %q (%v)", payload, err)
+	}
+}
+
+func TestDecodePayloadInvalid(t *testing.T) {
+	if _, err := decodePayload([]byte(`{"not": "snappy"}`)); err == nil {
+		t.Error("expected an error for a payload which isn't snappy compressed")
+	}
+}
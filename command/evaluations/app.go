@@ -3,10 +3,10 @@ package evaluations
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"time"
 
 	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
 	"github.com/seatgeek/nomad-firehose/sink"
 	log "github.com/sirupsen/logrus"
 )
@@ -21,16 +21,15 @@ type Firehose struct {
 }
 
 // NewFirehose ...
-func NewFirehose() (*Firehose, error) {
-	nomadClient, err := nomad.NewClient(nomad.DefaultConfig())
+func NewFirehose(region string) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
 	if err != nil {
 		return nil, err
 	}
 
-	sink, err := sink.GetSink()
+	sink, err := sink.GetSink(region)
 	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
+		return nil, err
 	}
 
 	return &Firehose{
@@ -65,9 +64,6 @@ func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
 func (f *Firehose) Start() {
 	go f.sink.Start()
 
-	// Stop chan for all tasks to depend on
-	f.stopCh = make(chan struct{})
-
 	// watch for allocation changes
 	go f.watch()
 
@@ -96,10 +92,16 @@ func (f *Firehose) persistLastChangeTime(interval time.Duration) {
 	for {
 		select {
 		case <-f.stopCh:
-			f.lastChangeTimeCh <- f.lastChangeIndex
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
 			break
 		case <-ticker.C:
-			f.lastChangeTimeCh <- f.lastChangeIndex
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
 		}
 	}
 }
@@ -114,7 +116,7 @@ func (f *Firehose) Publish(update *nomad.Evaluation) {
 	f.sink.Put(b)
 }
 
-// Continously watch for changes to the allocation list and publish it as updates
+// Continously watch for changes to the evaluation list and publish it as updates
 func (f *Firehose) watch() {
 	q := &nomad.QueryOptions{
 		WaitIndex:  f.lastChangeIndex,
@@ -122,9 +124,9 @@ func (f *Firehose) watch() {
 		AllowStale: true,
 	}
 
-	for {
-		log.Infof("Fetching evaluations from Nomad: %+v", q)
+	newMax := f.lastChangeIndex
 
+	for {
 		evaluations, meta, err := f.nomadClient.Evaluations().List(q)
 		if err != nil {
 			log.Errorf("Unable to fetch evaluations: %s", err)
@@ -132,28 +134,32 @@ func (f *Firehose) watch() {
 			continue
 		}
 
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
 		// Only work if the WaitIndex have changed
-		if meta.LastIndex == f.lastChangeIndex {
-			log.Infof("Evaluations index is unchanged (%d == %d)", meta.LastIndex, f.lastChangeIndex)
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Evaluations index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
 			continue
 		}
 
-		log.Infof("Evaluations index is changed (%d <> %d)", meta.LastIndex, f.lastChangeIndex)
+		log.Debugf("Evaluations index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
 
-		// Iterate clients and find events that have changed since last run
+		// Iterate evaluations and find events that have changed since last run
 		for _, evaluation := range evaluations {
-			if evaluation.ModifyIndex != f.lastChangeIndex {
+			if evaluation.ModifyIndex <= f.lastChangeIndex {
 				continue
 			}
 
+			if evaluation.ModifyIndex > newMax {
+				newMax = evaluation.ModifyIndex
+			}
+
 			f.Publish(evaluation)
-			evaluation = nil
 		}
 
-		evaluations = nil
-
 		// Update WaitIndex and Last Change Time for next iteration
-		f.lastChangeIndex = meta.LastIndex
 		q.WaitIndex = meta.LastIndex
+		f.lastChangeIndex = newMax
 	}
 }
@@ -0,0 +1,242 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	stopCh            chan struct{}
+	topics            map[nomad.Topic][]string
+	lock              sync.Mutex
+}
+
+// NewFirehose ...
+func NewFirehose(region string, topics []string) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	topicFilter, err := parseTopics(topics)
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		topics:            topicFilter,
+	}, nil
+}
+
+// parseTopics converts a list of "Topic" or "Topic:Key" strings into the
+// topic filter understood by the Nomad event stream API
+func parseTopics(topics []string) (map[nomad.Topic][]string, error) {
+	result := make(map[nomad.Topic][]string)
+
+	for _, raw := range topics {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		topic, key := raw, "*"
+		if i := strings.Index(raw, ":"); i != -1 {
+			topic, key = raw[:i], raw[i+1:]
+		}
+
+		if topic == "" || key == "" {
+			return nil, fmt.Errorf("Invalid event topic '%s', expected 'Topic' or 'Topic:Key'", raw)
+		}
+
+		result[nomad.Topic(topic)] = append(result[nomad.Topic(topic)], key)
+	}
+
+	if len(result) == 0 {
+		result[nomad.TopicAll] = []string{"*"}
+	}
+
+	return result, nil
+}
+
+func (f *Firehose) Name() string {
+	return "events"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for events
+	go f.watch()
+
+	// Save the last event index every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Index to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.restoreIndex()
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.restoreIndex()
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *nomad.Event) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// restoreIndex is the index to persist
+func (f *Firehose) restoreIndex() uint64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.lastChangeIndex
+}
+
+// updateLastChangeIndex to the index of the events published, it never goes back
+func (f *Firehose) updateLastChangeIndex(index uint64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if index > f.lastChangeIndex {
+		f.lastChangeIndex = index
+	}
+}
+
+// Continously consume the Nomad event stream and publish each event as an update
+func (f *Firehose) watch() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		<-f.stopCh
+		cancel()
+	}()
+
+	for {
+		// resume from the event following the last one we published
+		index := f.restoreIndex()
+		if index > 0 {
+			index++
+		}
+
+		// a stream scoped context, so the reader goroutine is released if we bail out early
+		streamCtx, streamCancel := context.WithCancel(ctx)
+
+		eventCh, err := f.nomadClient.EventStream().Stream(streamCtx, f.topics, index, &nomad.QueryOptions{AllowStale: true})
+		if err != nil {
+			streamCancel()
+			log.Errorf("Unable to open event stream: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		log.Debugf("Event stream opened at index %d", index)
+
+		for events := range eventCh {
+			if events.Err != nil {
+				log.Errorf("Event stream error: %s", events.Err)
+				break
+			}
+
+			if events.IsHeartbeat() {
+				continue
+			}
+
+			last := f.restoreIndex()
+			for _, event := range events.Events {
+				if event.Index <= last {
+					continue
+				}
+
+				f.Publish(&event)
+			}
+
+			// Update Last Change Index for next iteration
+			f.updateLastChangeIndex(events.Index)
+		}
+
+		streamCancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		log.Warnf("Event stream closed, reconnecting in 10s")
+		time.Sleep(10 * time.Second)
+	}
+}
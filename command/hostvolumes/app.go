@@ -0,0 +1,199 @@
+package hostvolumes
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	stopCh            chan struct{}
+	volumes           map[string]*nomad.HostVolumeStub
+}
+
+// HostVolumeUpdate ...
+type HostVolumeUpdate struct {
+	Type          string
+	PreviousState string `json:",omitempty"`
+	Volume        *nomad.HostVolumeStub
+}
+
+// NewFirehose ...
+func NewFirehose(region string) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		volumes:           make(map[string]*nomad.HostVolumeStub),
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "host-volumes"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for host volume changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *HostVolumeUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// Continously watch for changes to the host volume list and publish it as updates
+func (f *Firehose) watch() {
+	q := &nomad.QueryOptions{
+		Namespace:  "*",
+		WaitIndex:  f.lastChangeIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.lastChangeIndex
+
+	for {
+		volumes, meta, err := f.nomadClient.HostVolumes().List(&nomad.HostVolumeListRequest{}, q)
+		if err != nil {
+			log.Errorf("Unable to fetch host volumes: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Host volumes index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Host volumes index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		current := make(map[string]*nomad.HostVolumeStub)
+
+		// Iterate host volumes and find events that have changed since last run
+		for _, volume := range volumes {
+			current[volume.Namespace+"/"+volume.ID] = volume
+
+			if volume.ModifyIndex <= f.lastChangeIndex {
+				continue
+			}
+
+			if volume.ModifyIndex > newMax {
+				newMax = volume.ModifyIndex
+			}
+
+			if volume.CreateIndex > f.lastChangeIndex {
+				f.Publish(&HostVolumeUpdate{Type: "created", Volume: volume})
+				continue
+			}
+
+			previous, ok := f.volumes[volume.Namespace+"/"+volume.ID]
+			if ok && previous.State != volume.State {
+				f.Publish(&HostVolumeUpdate{Type: "state-changed", PreviousState: previous.State, Volume: volume})
+				continue
+			}
+
+			f.Publish(&HostVolumeUpdate{Type: "modified", Volume: volume})
+		}
+
+		// Host volumes we knew about that are no longer listed have been deleted
+		for key, volume := range f.volumes {
+			if _, ok := current[key]; !ok {
+				f.Publish(&HostVolumeUpdate{Type: "deleted", Volume: volume})
+			}
+		}
+
+		f.volumes = current
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.lastChangeIndex = newMax
+	}
+}
@@ -0,0 +1,250 @@
+package jobdiffs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	pool              *helper.KeyedPool
+	stopCh            chan struct{}
+
+	// last published version of the jobs, a job being modified without a new version has
+	// nothing to diff
+	versions map[string]uint64
+	lock     sync.Mutex
+}
+
+// JobDiffUpdate ...
+type JobDiffUpdate struct {
+	JobID           string
+	Namespace       string
+	Version         uint64
+	PreviousVersion *uint64
+	Diff            *nomad.JobDiff
+	Job             *nomad.Job
+}
+
+// NewFirehose ...
+func NewFirehose(region string, workers int) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		pool:              helper.NewKeyedPool(workers),
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		versions:          make(map[string]uint64),
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "job-diffs"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for job changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *JobDiffUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// newVersion tell if version of the job key is newer than the last published one, and record it
+func (f *Firehose) newVersion(key string, version uint64) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if last, ok := f.versions[key]; ok && version <= last {
+		return false
+	}
+
+	f.versions[key] = version
+	return true
+}
+
+// Continously watch for changes to the job list and publish it as updates
+func (f *Firehose) watch() {
+	q := &nomad.QueryOptions{
+		Namespace:  "*",
+		WaitIndex:  f.lastChangeIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.lastChangeIndex
+
+	for {
+		jobs, meta, err := f.nomadClient.Jobs().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch jobs: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Jobs index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Jobs index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		batch := f.pool.Batch()
+		listed := make(map[string]bool, len(jobs))
+
+		// Iterate jobs and find events that have changed since last run
+		for _, job := range jobs {
+			listed[job.Namespace+"/"+job.ID] = true
+
+			if job.ModifyIndex <= f.lastChangeIndex {
+				continue
+			}
+
+			if job.ModifyIndex > newMax {
+				newMax = job.ModifyIndex
+			}
+
+			jobID, namespace := job.ID, job.Namespace
+			batch.Go(namespace+"/"+jobID, func() {
+				versions, diffs, _, err := f.nomadClient.Jobs().Versions(jobID, true, &nomad.QueryOptions{Namespace: namespace})
+				if err != nil {
+					log.Errorf("Could not read versions of job %s/%s: %s", namespace, jobID, err)
+					return
+				}
+
+				if len(versions) == 0 {
+					return
+				}
+
+				// versions are sorted newest first, and diffs[i] is the diff between versions[i+1] and versions[i]
+				update := &JobDiffUpdate{
+					JobID:     jobID,
+					Namespace: namespace,
+					Job:       versions[0],
+				}
+
+				if versions[0].Version != nil {
+					update.Version = *versions[0].Version
+				}
+
+				if !f.newVersion(namespace+"/"+jobID, update.Version) {
+					log.Debugf("Job %s/%s is still at version %d", namespace, jobID, update.Version)
+					return
+				}
+
+				if len(diffs) > 0 && len(versions) > 1 {
+					update.Diff = diffs[0]
+					update.PreviousVersion = versions[1].Version
+				}
+
+				f.Publish(update)
+			})
+		}
+
+		// wait for the events to be published, so a sink that is slow or down holds back the
+		// watcher instead of piling up goroutines
+		batch.Wait()
+
+		// forget the versions of the purged jobs
+		f.lock.Lock()
+		for key := range f.versions {
+			if !listed[key] {
+				delete(f.versions, key)
+			}
+		}
+		f.lock.Unlock()
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.lastChangeIndex = newMax
+	}
+}
@@ -3,9 +3,11 @@ package jobs
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
 	"github.com/seatgeek/nomad-firehose/sink"
 	log "github.com/sirupsen/logrus"
 )
@@ -16,17 +18,22 @@ type Firehose struct {
 	lastChangeTimeCh chan interface{}
 	nomadClient      *nomad.Client
 	sink             sink.Sink
+	pool             *helper.KeyedPool
 	stopCh           chan struct{}
+	namespaces       []string
+	// committed index of every namespace watcher, lastChangeIndex being the lowest of them
+	indexes map[string]uint64
+	lock    sync.Mutex
 }
 
 // NewFirehose ...
-func NewFirehose() (*Firehose, error) {
-	nomadClient, err := nomad.NewClient(nomad.DefaultConfig())
+func NewFirehose(region string, workers int, namespaces []string) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
 	if err != nil {
 		return nil, err
 	}
 
-	sink, err := sink.GetSink()
+	sink, err := sink.GetSink(region)
 	if err != nil {
 		return nil, err
 	}
@@ -34,8 +41,11 @@ func NewFirehose() (*Firehose, error) {
 	return &Firehose{
 		nomadClient:      nomadClient,
 		sink:             sink,
+		pool:             helper.NewKeyedPool(workers),
 		stopCh:           make(chan struct{}, 1),
 		lastChangeTimeCh: make(chan interface{}, 1),
+		namespaces:       namespaces,
+		indexes:          make(map[string]uint64),
 	}, nil
 }
 
@@ -63,8 +73,16 @@ func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
 func (f *Firehose) Start() {
 	go f.sink.Start()
 
-	// watch for allocation changes
-	go f.watch()
+	// watch for job changes, with a wait index per namespace, all starting from the restore point
+	f.lock.Lock()
+	for _, namespace := range f.namespaces {
+		f.indexes[namespace] = f.lastChangeIndex
+	}
+	f.lock.Unlock()
+
+	for _, namespace := range f.namespaces {
+		go f.watch(namespace)
+	}
 
 	// Save the last event time every 5s
 	go f.persistLastChangeTime(5 * time.Second)
@@ -91,10 +109,16 @@ func (f *Firehose) persistLastChangeTime(interval time.Duration) {
 	for {
 		select {
 		case <-f.stopCh:
-			f.lastChangeTimeCh <- f.lastChangeIndex
+			value := f.restoreIndex()
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
 			break
 		case <-ticker.C:
-			f.lastChangeTimeCh <- f.lastChangeIndex
+			value := f.restoreIndex()
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
 		}
 	}
 }
@@ -109,20 +133,55 @@ func (f *Firehose) Publish(update *nomad.Job) {
 	f.sink.Put(b)
 }
 
-// Continously watch for changes to the allocation list and publish it as updates
-func (f *Firehose) watch() {
+// restoreIndex is the index to persist
+func (f *Firehose) restoreIndex() uint64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.lastChangeIndex
+}
+
+// namespaceIndex is the committed index of a namespace watcher
+func (f *Firehose) namespaceIndex(namespace string) uint64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.indexes[namespace]
+}
+
+// updateLastChangeIndex commit the index of a namespace watcher, the restore point being the
+// lowest committed index so a lagging namespace doesn't lose its events on restart
+func (f *Firehose) updateLastChangeIndex(namespace string, index uint64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.indexes[namespace] = index
+
+	lowest := index
+	for _, committed := range f.indexes {
+		if committed < lowest {
+			lowest = committed
+		}
+	}
+	f.lastChangeIndex = lowest
+}
+
+// Continously watch for changes to the job list of a namespace and publish it as updates
+func (f *Firehose) watch(namespace string) {
+	lastIndex := f.namespaceIndex(namespace)
+	newMax := lastIndex
+
 	q := &nomad.QueryOptions{
-		WaitIndex:  f.lastChangeIndex,
+		Namespace:  namespace,
+		WaitIndex:  lastIndex,
 		WaitTime:   5 * time.Minute,
 		AllowStale: true,
 	}
 
-	newMax := f.lastChangeIndex
-
 	for {
 		jobs, meta, err := f.nomadClient.Jobs().List(q)
 		if err != nil {
-			log.Errorf("Unable to fetch jobs: %s", err)
+			log.Errorf("Unable to fetch jobs in namespace '%s': %s", namespace, err)
 			time.Sleep(10 * time.Second)
 			continue
 		}
@@ -132,15 +191,17 @@ func (f *Firehose) watch() {
 
 		// Only work if the WaitIndex have changed
 		if remoteWaitIndex == localWaitIndex {
-			log.Debugf("Jobs index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			log.Debugf("Jobs index of namespace '%s' is unchanged (%d == %d)", namespace, remoteWaitIndex, localWaitIndex)
 			continue
 		}
 
-		log.Debugf("Jobs index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+		log.Debugf("Jobs index of namespace '%s' is changed (%d <> %d)", namespace, remoteWaitIndex, localWaitIndex)
+
+		batch := f.pool.Batch()
 
 		// Iterate jobs and find events that have changed since last run
 		for _, job := range jobs {
-			if job.ModifyIndex <= f.lastChangeIndex {
+			if job.ModifyIndex <= lastIndex {
 				continue
 			}
 
@@ -148,19 +209,25 @@ func (f *Firehose) watch() {
 				newMax = job.ModifyIndex
 			}
 
-			go func(jobID string) {
-				fullJob, _, err := f.nomadClient.Jobs().Info(jobID, &nomad.QueryOptions{})
+			jobID, namespace := job.ID, job.Namespace
+			batch.Go(namespace+"/"+jobID, func() {
+				fullJob, _, err := f.nomadClient.Jobs().Info(jobID, &nomad.QueryOptions{Namespace: namespace})
 				if err != nil {
-					log.Errorf("Could not read job %s: %s", jobID, err)
+					log.Errorf("Could not read job %s/%s: %s", namespace, jobID, err)
 					return
 				}
 
 				f.Publish(fullJob)
-			}(job.ID)
+			})
 		}
 
+		// wait for the events to be published, so a sink that is slow or down holds back the
+		// watcher instead of piling up goroutines
+		batch.Wait()
+
 		// Update WaitIndex and Last Change Time for next iteration
 		q.WaitIndex = meta.LastIndex
-		f.lastChangeIndex = newMax
+		lastIndex = newMax
+		f.updateLastChangeIndex(namespace, newMax)
 	}
 }
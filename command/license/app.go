@@ -0,0 +1,238 @@
+package license
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeTime   int64
+	lastChangeTimeCh chan interface{}
+	nomadClient      *nomad.Client
+	sink             sink.Sink
+	stopCh           chan struct{}
+	interval         time.Duration
+	leadTime         time.Duration
+	license          *nomad.License
+	expiringNotified string
+	expiredNotified  string
+}
+
+// LicenseUpdate ...
+type LicenseUpdate struct {
+	Type            string
+	License         *nomad.License
+	Previous        *nomad.License `json:",omitempty"`
+	ExpiresIn       string         `json:",omitempty"`
+	AddedFeatures   []string       `json:",omitempty"`
+	RemovedFeatures []string       `json:",omitempty"`
+}
+
+// NewFirehose ...
+func NewFirehose(region string, interval, leadTime time.Duration) (*Firehose, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("Invalid poll interval '%s', must be positive", interval)
+	}
+
+	if leadTime < 0 {
+		return nil, fmt.Errorf("Invalid expiry lead time '%s', must not be negative", leadTime)
+	}
+
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:      nomadClient,
+		sink:             sink,
+		stopCh:           make(chan struct{}, 1),
+		lastChangeTimeCh: make(chan interface{}, 1),
+		interval:         interval,
+		leadTime:         leadTime,
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "license"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeTimeCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeTime = int64(restoreValue.(int))
+	case int64:
+		f.lastChangeTime = restoreValue.(int64)
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for license changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeTime
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeTime
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *LicenseUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.lastChangeTime = time.Now().UnixNano()
+	f.sink.Put(b)
+}
+
+// Periodically poll the license, and publish changes, upcoming expiry and expiry as updates
+//
+// The first poll only records the current license, but will still report it expiring or expired
+func (f *Firehose) watch() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		f.poll()
+
+		select {
+		case <-f.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll the license once
+func (f *Firehose) poll() {
+	reply, err := f.nomadClient.Operator().LicenseGet(&nomad.QueryOptions{AllowStale: true})
+	if err != nil {
+		log.Errorf("Unable to fetch license: %s", err)
+		return
+	}
+
+	license := reply.License
+	if license == nil {
+		log.Debugf("No license returned by the server")
+		return
+	}
+
+	if previous := f.license; previous != nil {
+		if previous.LicenseID != license.LicenseID {
+			f.Publish(&LicenseUpdate{Type: "license-changed", License: license, Previous: previous})
+		}
+
+		added, removed := diff(previous.Features, license.Features)
+		modulesAdded, modulesRemoved := diff(previous.Modules, license.Modules)
+		added = append(added, modulesAdded...)
+		removed = append(removed, modulesRemoved...)
+
+		if len(added) > 0 || len(removed) > 0 {
+			f.Publish(&LicenseUpdate{
+				Type:            "features-changed",
+				License:         license,
+				Previous:        previous,
+				AddedFeatures:   added,
+				RemovedFeatures: removed,
+			})
+		}
+	}
+
+	f.license = license
+
+	// only notify once per license about it expiring and expired
+	expiresIn := time.Until(license.ExpirationTime)
+
+	if expiresIn <= 0 {
+		if f.expiredNotified != license.LicenseID {
+			f.expiredNotified = license.LicenseID
+			f.Publish(&LicenseUpdate{Type: "expired", License: license})
+		}
+		return
+	}
+
+	if expiresIn <= f.leadTime && f.expiringNotified != license.LicenseID {
+		f.expiringNotified = license.LicenseID
+		f.Publish(&LicenseUpdate{Type: "expiring", License: license, ExpiresIn: expiresIn.Round(time.Minute).String()})
+	}
+}
+
+// diff return the entries only in b (added) and only in a (removed)
+func diff(a, b []string) (added, removed []string) {
+	seen := make(map[string]bool)
+	for _, v := range a {
+		seen[v] = true
+	}
+
+	for _, v := range b {
+		if !seen[v] {
+			added = append(added, v)
+		}
+		delete(seen, v)
+	}
+
+	for _, v := range a {
+		if seen[v] {
+			removed = append(removed, v)
+		}
+	}
+
+	return added, removed
+}
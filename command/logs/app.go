@@ -0,0 +1,322 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	stopCh            chan struct{}
+	jobFilter         *regexp.Regexp
+	taskFilter        *regexp.Regexp
+	logTypes          []string
+	followers         map[string]chan struct{}
+	followersLock     sync.Mutex
+}
+
+// LogLine ...
+type LogLine struct {
+	AllocationID string
+	JobID        string
+	Namespace    string
+	NodeID       string
+	GroupName    string
+	TaskName     string
+	Type         string
+	Line         string
+	Time         time.Time
+}
+
+// NewFirehose ...
+func NewFirehose(region, jobFilter, taskFilter string, logTypes []string) (*Firehose, error) {
+	jobRegexp, err := regexp.Compile(jobFilter)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid job filter '%s': %s", jobFilter, err)
+	}
+
+	taskRegexp, err := regexp.Compile(taskFilter)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid task filter '%s': %s", taskFilter, err)
+	}
+
+	for _, logType := range logTypes {
+		if logType != "stdout" && logType != "stderr" {
+			return nil, fmt.Errorf("Invalid log type '%s', must be stdout or stderr", logType)
+		}
+	}
+
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		jobFilter:         jobRegexp,
+		taskFilter:        taskRegexp,
+		logTypes:          logTypes,
+		followers:         make(map[string]chan struct{}),
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "logs"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for allocation changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+
+	f.followersLock.Lock()
+	for key, cancel := range f.followers {
+		close(cancel)
+		delete(f.followers, key)
+	}
+	f.followersLock.Unlock()
+
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *LogLine) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// Continously watch for changes to the allocation list and follow the logs of
+// running allocations matching the job and task filters
+func (f *Firehose) watch() {
+	q := &nomad.QueryOptions{
+		Namespace:  "*",
+		WaitIndex:  f.lastChangeIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	// allocations created after this index are followed from the start of their logs,
+	// older ones from the end, so a restart doesn't ship their whole log again
+	startIndex := f.lastChangeIndex
+
+	for {
+		allocations, meta, err := f.nomadClient.Allocations().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch allocations: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Allocations index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Allocations index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		running := make(map[string]bool)
+
+		for _, allocation := range allocations {
+			if allocation.ClientStatus != "running" || !f.jobFilter.MatchString(allocation.JobID) {
+				continue
+			}
+
+			origin := "end"
+			if startIndex > 0 && allocation.CreateIndex > startIndex {
+				origin = "start"
+			}
+
+			for taskName := range allocation.TaskStates {
+				if !f.taskFilter.MatchString(taskName) {
+					continue
+				}
+
+				for _, logType := range f.logTypes {
+					key := fmt.Sprintf("%s/%s/%s", allocation.ID, taskName, logType)
+					running[key] = true
+
+					f.ensureFollower(key, allocation, taskName, logType, origin)
+				}
+			}
+		}
+
+		// Stop following allocations that are no longer running
+		f.followersLock.Lock()
+		for key, cancel := range f.followers {
+			if !running[key] {
+				close(cancel)
+				delete(f.followers, key)
+			}
+		}
+		f.followersLock.Unlock()
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.lastChangeIndex = meta.LastIndex
+		if startIndex == 0 {
+			startIndex = meta.LastIndex
+		}
+	}
+}
+
+// ensureFollower start following a task log unless it's already followed
+func (f *Firehose) ensureFollower(key string, allocation *nomad.AllocationListStub, taskName, logType, origin string) {
+	f.followersLock.Lock()
+	defer f.followersLock.Unlock()
+
+	if _, ok := f.followers[key]; ok {
+		return
+	}
+
+	cancel := make(chan struct{})
+	f.followers[key] = cancel
+
+	go f.follow(key, allocation.ID, allocation.Namespace, taskName, logType, origin, cancel)
+}
+
+// follow stream a task log and publish it line by line until cancelled
+func (f *Firehose) follow(key, allocationID, namespace, taskName, logType, origin string, cancel chan struct{}) {
+	// forget the follower on error, so the next allocation change restarts it
+	defer func() {
+		f.followersLock.Lock()
+		if f.followers[key] == cancel {
+			delete(f.followers, key)
+		}
+		f.followersLock.Unlock()
+	}()
+
+	allocation, _, err := f.nomadClient.Allocations().Info(allocationID, &nomad.QueryOptions{Namespace: namespace})
+	if err != nil {
+		log.Errorf("Could not read allocation %s: %s", allocationID, err)
+		return
+	}
+
+	log.Infof("Following %s logs of %s/%s", logType, allocationID, taskName)
+
+	frames, errCh := f.nomadClient.AllocFS().Logs(allocation, true, taskName, logType, origin, 0, cancel, &nomad.QueryOptions{Namespace: namespace})
+
+	var partial []byte
+
+	for {
+		select {
+		case <-cancel:
+			return
+
+		case err := <-errCh:
+			log.Errorf("Could not follow %s logs of %s/%s: %s", logType, allocationID, taskName, err)
+			return
+
+		case frame, ok := <-frames:
+			if !ok {
+				return
+			}
+
+			if frame == nil || len(frame.Data) == 0 {
+				continue
+			}
+
+			lines := bytes.Split(append(partial, frame.Data...), []byte("\n"))
+
+			// the last element is either empty or an incomplete line
+			partial = lines[len(lines)-1]
+
+			for _, line := range lines[:len(lines)-1] {
+				f.Publish(&LogLine{
+					AllocationID: allocation.ID,
+					JobID:        allocation.JobID,
+					Namespace:    allocation.Namespace,
+					NodeID:       allocation.NodeID,
+					GroupName:    allocation.TaskGroup,
+					TaskName:     taskName,
+					Type:         logType,
+					Line:         string(line),
+					Time:         time.Now().UTC(),
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package members
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeTime   int64
+	lastChangeTimeCh chan interface{}
+	nomadClient      *nomad.Client
+	sink             sink.Sink
+	stopCh           chan struct{}
+	interval         time.Duration
+	members          map[string]*nomad.AgentMember
+	leader           string
+}
+
+// MemberUpdate ...
+type MemberUpdate struct {
+	Type           string
+	Member         *nomad.AgentMember `json:",omitempty"`
+	Leader         string             `json:",omitempty"`
+	PreviousLeader string             `json:",omitempty"`
+}
+
+// NewFirehose ...
+func NewFirehose(region string, interval time.Duration) (*Firehose, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("Invalid poll interval '%s', must be positive", interval)
+	}
+
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:      nomadClient,
+		sink:             sink,
+		stopCh:           make(chan struct{}, 1),
+		lastChangeTimeCh: make(chan interface{}, 1),
+		interval:         interval,
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "members"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeTimeCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeTime = int64(restoreValue.(int))
+	case int64:
+		f.lastChangeTime = restoreValue.(int64)
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for member changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeTime
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeTime
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *MemberUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.lastChangeTime = time.Now().UnixNano()
+	f.sink.Put(b)
+}
+
+// Periodically poll the server members and leader, and publish the changes as updates
+//
+// The first poll only records the current state, the members API has no index to resume from
+func (f *Firehose) watch() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		f.poll()
+
+		select {
+		case <-f.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll the server members and leader once
+func (f *Firehose) poll() {
+	servers, err := f.nomadClient.Agent().Members()
+	if err != nil {
+		log.Errorf("Unable to fetch server members: %s", err)
+		return
+	}
+
+	leader, err := f.nomadClient.Status().Leader()
+	if err != nil {
+		log.Errorf("Unable to fetch leader: %s", err)
+		return
+	}
+
+	current := make(map[string]*nomad.AgentMember)
+	for _, member := range servers.Members {
+		current[member.Name] = member
+	}
+
+	// nothing to compare with on the first poll
+	if f.members == nil {
+		f.members = current
+		f.leader = leader
+		return
+	}
+
+	for name, member := range current {
+		previous, ok := f.members[name]
+		if !ok {
+			f.Publish(&MemberUpdate{Type: "joined", Member: member})
+			continue
+		}
+
+		if previous.Status != member.Status {
+			f.Publish(&MemberUpdate{Type: member.Status, Member: member})
+		}
+	}
+
+	// members are eventually reaped from the gossip pool after leaving or failing
+	for name, member := range f.members {
+		if _, ok := current[name]; !ok {
+			f.Publish(&MemberUpdate{Type: "reaped", Member: member})
+		}
+	}
+
+	if leader != f.leader {
+		f.Publish(&MemberUpdate{Type: "leader-changed", Leader: leader, PreviousLeader: f.leader})
+	}
+
+	f.members = current
+	f.leader = leader
+}
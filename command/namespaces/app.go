@@ -0,0 +1,191 @@
+package namespaces
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	stopCh            chan struct{}
+	namespaces        map[string]*nomad.Namespace
+}
+
+// NamespaceUpdate ...
+type NamespaceUpdate struct {
+	Type      string
+	Namespace *nomad.Namespace
+}
+
+// NewFirehose ...
+func NewFirehose(region string) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		namespaces:        make(map[string]*nomad.Namespace),
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "namespaces"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for namespace changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *NamespaceUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// Continously watch for changes to the namespace list and publish it as updates
+func (f *Firehose) watch() {
+	q := &nomad.QueryOptions{
+		WaitIndex:  f.lastChangeIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.lastChangeIndex
+
+	for {
+		namespaces, meta, err := f.nomadClient.Namespaces().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch namespaces: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Namespaces index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Namespaces index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		current := make(map[string]*nomad.Namespace)
+
+		// Iterate namespaces and find events that have changed since last run
+		for _, namespace := range namespaces {
+			current[namespace.Name] = namespace
+
+			if namespace.ModifyIndex <= f.lastChangeIndex {
+				continue
+			}
+
+			if namespace.ModifyIndex > newMax {
+				newMax = namespace.ModifyIndex
+			}
+
+			if namespace.CreateIndex > f.lastChangeIndex {
+				f.Publish(&NamespaceUpdate{Type: "created", Namespace: namespace})
+				continue
+			}
+
+			f.Publish(&NamespaceUpdate{Type: "modified", Namespace: namespace})
+		}
+
+		// Namespaces we knew about that are no longer listed have been deleted
+		for name, namespace := range f.namespaces {
+			if _, ok := current[name]; !ok {
+				f.Publish(&NamespaceUpdate{Type: "deleted", Namespace: namespace})
+			}
+		}
+
+		f.namespaces = current
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.lastChangeIndex = newMax
+	}
+}
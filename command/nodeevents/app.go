@@ -0,0 +1,245 @@
+package nodeevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	stopCh            chan struct{}
+	nodes             map[string]*nomad.NodeListStub
+}
+
+// NodeEvent ...
+type NodeEvent struct {
+	Type                  string
+	NodeID                string
+	NodeName              string
+	Datacenter            string
+	NodeClass             string
+	NodePool              string
+	Status                string
+	StatusDescription     string
+	Drain                 bool
+	SchedulingEligibility string
+	ModifyIndex           uint64
+}
+
+// NewFirehose ...
+func NewFirehose(region string) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		nodes:             make(map[string]*nomad.NodeListStub),
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "node-events"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for client changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *NodeEvent) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+func newNodeEvent(eventType string, node *nomad.NodeListStub) *NodeEvent {
+	return &NodeEvent{
+		Type:                  eventType,
+		NodeID:                node.ID,
+		NodeName:              node.Name,
+		Datacenter:            node.Datacenter,
+		NodeClass:             node.NodeClass,
+		NodePool:              node.NodePool,
+		Status:                node.Status,
+		StatusDescription:     node.StatusDescription,
+		Drain:                 node.Drain,
+		SchedulingEligibility: node.SchedulingEligibility,
+		ModifyIndex:           node.ModifyIndex,
+	}
+}
+
+// classify returns the lifecycle events that happened between the previous and current state
+// of a node, previous is nil if the node has not been seen before
+func classify(previous, current *nomad.NodeListStub, lastChangeIndex uint64) []string {
+	if previous == nil {
+		if current.CreateIndex > lastChangeIndex {
+			return []string{"registered"}
+		}
+
+		return nil
+	}
+
+	var events []string
+
+	if previous.Status != current.Status {
+		switch current.Status {
+		case "down":
+			events = append(events, "down")
+		case "ready":
+			events = append(events, "ready")
+		case "disconnected":
+			events = append(events, "disconnected")
+		}
+	}
+
+	if !previous.Drain && current.Drain {
+		events = append(events, "drain-started")
+	}
+
+	if previous.Drain && !current.Drain {
+		events = append(events, "drain-complete")
+	}
+
+	if previous.SchedulingEligibility != current.SchedulingEligibility {
+		events = append(events, "eligibility-changed")
+	}
+
+	return events
+}
+
+// Continously watch for changes to the client list and publish it as updates
+func (f *Firehose) watch() {
+	q := &nomad.QueryOptions{
+		WaitIndex:  f.lastChangeIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.lastChangeIndex
+
+	for {
+		clients, meta, err := f.nomadClient.Nodes().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch clients: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Clients index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Clients index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		current := make(map[string]*nomad.NodeListStub)
+
+		// Iterate clients and classify the changes since last run
+		for _, client := range clients {
+			current[client.ID] = client
+
+			if client.ModifyIndex <= f.lastChangeIndex {
+				continue
+			}
+
+			if client.ModifyIndex > newMax {
+				newMax = client.ModifyIndex
+			}
+
+			for _, eventType := range classify(f.nodes[client.ID], client, f.lastChangeIndex) {
+				f.Publish(newNodeEvent(eventType, client))
+			}
+		}
+
+		f.nodes = current
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.lastChangeIndex = newMax
+	}
+}
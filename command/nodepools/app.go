@@ -0,0 +1,198 @@
+package nodepools
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	stopCh            chan struct{}
+	pools             map[string]*nomad.NodePool
+}
+
+// NodePoolUpdate ...
+type NodePoolUpdate struct {
+	Type     string
+	NodePool *nomad.NodePool
+}
+
+// NewFirehose ...
+func NewFirehose(region string) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		pools:             make(map[string]*nomad.NodePool),
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "node-pools"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for node pool changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *NodePoolUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// Continously watch for changes to the node pool list and publish it as updates
+func (f *Firehose) watch() {
+	q := &nomad.QueryOptions{
+		WaitIndex:  f.lastChangeIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.lastChangeIndex
+
+	for {
+		pools, meta, err := f.nomadClient.NodePools().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch node pools: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Node pools index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Node pools index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		current := make(map[string]*nomad.NodePool)
+
+		// Iterate node pools and find events that have changed since last run
+		for _, pool := range pools {
+			current[pool.Name] = pool
+
+			if pool.ModifyIndex <= f.lastChangeIndex {
+				continue
+			}
+
+			if pool.ModifyIndex > newMax {
+				newMax = pool.ModifyIndex
+			}
+
+			if pool.CreateIndex > f.lastChangeIndex {
+				f.Publish(&NodePoolUpdate{Type: "created", NodePool: pool})
+				continue
+			}
+
+			previous, ok := f.pools[pool.Name]
+			if ok && !reflect.DeepEqual(previous.SchedulerConfiguration, pool.SchedulerConfiguration) {
+				f.Publish(&NodePoolUpdate{Type: "scheduler-config-modified", NodePool: pool})
+				continue
+			}
+
+			f.Publish(&NodePoolUpdate{Type: "modified", NodePool: pool})
+		}
+
+		// Node pools we knew about that are no longer listed have been deleted
+		for name, pool := range f.pools {
+			if _, ok := current[name]; !ok {
+				f.Publish(&NodePoolUpdate{Type: "deleted", NodePool: pool})
+			}
+		}
+
+		f.pools = current
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.lastChangeIndex = newMax
+	}
+}
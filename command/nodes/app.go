@@ -6,6 +6,7 @@ import (
 	"time"
 
 	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
 	"github.com/seatgeek/nomad-firehose/sink"
 	log "github.com/sirupsen/logrus"
 )
@@ -16,17 +17,18 @@ type Firehose struct {
 	lastChangeIndexCh chan interface{}
 	nomadClient       *nomad.Client
 	sink              sink.Sink
+	pool              *helper.KeyedPool
 	stopCh            chan struct{}
 }
 
 // NewFirehose ...
-func NewFirehose() (*Firehose, error) {
-	nomadClient, err := nomad.NewClient(nomad.DefaultConfig())
+func NewFirehose(region string, workers int) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
 	if err != nil {
 		return nil, err
 	}
 
-	sink, err := sink.GetSink()
+	sink, err := sink.GetSink(region)
 	if err != nil {
 		return nil, err
 	}
@@ -34,6 +36,7 @@ func NewFirehose() (*Firehose, error) {
 	return &Firehose{
 		nomadClient:       nomadClient,
 		sink:              sink,
+		pool:              helper.NewKeyedPool(workers),
 		stopCh:            make(chan struct{}, 1),
 		lastChangeIndexCh: make(chan interface{}, 1),
 	}, nil
@@ -63,9 +66,6 @@ func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
 func (f *Firehose) Start() {
 	go f.sink.Start()
 
-	// Stop chan for all tasks to depend on
-	f.stopCh = make(chan struct{})
-
 	// watch for allocation changes
 	go f.watch()
 
@@ -94,10 +94,16 @@ func (f *Firehose) persistLastChangeTime(interval time.Duration) {
 	for {
 		select {
 		case <-f.stopCh:
-			f.lastChangeIndexCh <- f.lastChangeIndex
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
 			break
 		case <-ticker.C:
-			f.lastChangeIndexCh <- f.lastChangeIndex
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
 		}
 	}
 }
@@ -141,6 +147,8 @@ func (f *Firehose) watch() {
 
 		log.Debugf("Clients index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
 
+		batch := f.pool.Batch()
+
 		// Iterate clients and find events that have changed since last run
 		for _, client := range clients {
 			if client.ModifyIndex <= f.lastChangeIndex {
@@ -151,7 +159,8 @@ func (f *Firehose) watch() {
 				newMax = client.ModifyIndex
 			}
 
-			go func(clientId string) {
+			clientId := client.ID
+			batch.Go(clientId, func() {
 				fullClient, _, err := f.nomadClient.Nodes().Info(clientId, &nomad.QueryOptions{})
 				if err != nil {
 					log.Errorf("Could not read client %s: %s", clientId, err)
@@ -159,9 +168,13 @@ func (f *Firehose) watch() {
 				}
 
 				f.Publish(fullClient)
-			}(client.ID)
+			})
 		}
 
+		// wait for the events to be published, so a sink that is slow or down holds back the
+		// watcher instead of piling up goroutines
+		batch.Wait()
+
 		// Update WaitIndex and Last Change Time for next iteration
 		q.WaitIndex = meta.LastIndex
 		f.lastChangeIndex = newMax
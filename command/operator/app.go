@@ -0,0 +1,259 @@
+package operator
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeTime   int64
+	lastChangeTimeCh chan interface{}
+	nomadClient      *nomad.Client
+	sink             sink.Sink
+	stopCh           chan struct{}
+	interval         time.Duration
+	peers            map[string]*nomad.RaftServer
+	health           *nomad.OperatorHealthReply
+}
+
+// OperatorUpdate ...
+type OperatorUpdate struct {
+	Type             string
+	Peer             *nomad.RaftServer   `json:",omitempty"`
+	Server           *nomad.ServerHealth `json:",omitempty"`
+	Healthy          bool
+	FailureTolerance int
+}
+
+// NewFirehose ...
+func NewFirehose(region string, interval time.Duration) (*Firehose, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("Invalid poll interval '%s', must be positive", interval)
+	}
+
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:      nomadClient,
+		sink:             sink,
+		stopCh:           make(chan struct{}, 1),
+		lastChangeTimeCh: make(chan interface{}, 1),
+		interval:         interval,
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "operator"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeTimeCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeTime = int64(restoreValue.(int))
+	case int64:
+		f.lastChangeTime = restoreValue.(int64)
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for raft and autopilot changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeTime
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeTime
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *OperatorUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.lastChangeTime = time.Now().UnixNano()
+	f.sink.Put(b)
+}
+
+// Periodically poll the raft configuration and autopilot health, and publish the changes as updates
+//
+// The first poll only records the current state, changes are emitted from the second poll onwards
+func (f *Firehose) watch() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		f.pollRaft()
+		f.pollAutopilot()
+
+		select {
+		case <-f.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollRaft publish raft peers being added, removed or changing leader / voter status
+func (f *Firehose) pollRaft() {
+	configuration, err := f.nomadClient.Operator().RaftGetConfiguration(&nomad.QueryOptions{AllowStale: true})
+	if err != nil {
+		log.Errorf("Unable to fetch raft configuration: %s", err)
+		return
+	}
+
+	current := make(map[string]*nomad.RaftServer)
+	for _, peer := range configuration.Servers {
+		current[peer.ID] = peer
+	}
+
+	// nothing to compare with on the first poll
+	if f.peers == nil {
+		f.peers = current
+		return
+	}
+
+	for id, peer := range current {
+		previous, ok := f.peers[id]
+		if !ok {
+			f.Publish(&OperatorUpdate{Type: "peer-added", Peer: peer})
+			continue
+		}
+
+		if !previous.Leader && peer.Leader {
+			f.Publish(&OperatorUpdate{Type: "leader-elected", Peer: peer})
+		}
+
+		if previous.Voter != peer.Voter {
+			f.Publish(&OperatorUpdate{Type: "voter-changed", Peer: peer})
+		}
+	}
+
+	for id, peer := range f.peers {
+		if _, ok := current[id]; !ok {
+			f.Publish(&OperatorUpdate{Type: "peer-removed", Peer: peer})
+		}
+	}
+
+	f.peers = current
+}
+
+// pollAutopilot publish servers and the cluster becoming healthy / unhealthy
+func (f *Firehose) pollAutopilot() {
+	health, _, err := f.nomadClient.Operator().AutopilotServerHealth(&nomad.QueryOptions{})
+	if err != nil {
+		log.Errorf("Unable to fetch autopilot server health: %s", err)
+		return
+	}
+
+	// nothing to compare with on the first poll
+	if f.health == nil {
+		f.health = health
+		return
+	}
+
+	previous := make(map[string]bool)
+	for _, server := range f.health.Servers {
+		previous[server.ID] = server.Healthy
+	}
+
+	for i := range health.Servers {
+		server := &health.Servers[i]
+
+		wasHealthy, ok := previous[server.ID]
+		if ok && wasHealthy == server.Healthy {
+			continue
+		}
+
+		// new servers are reported by pollRaft, only report them here if they're unhealthy
+		if !ok && server.Healthy {
+			continue
+		}
+
+		updateType := "server-unhealthy"
+		if server.Healthy {
+			updateType = "server-healthy"
+		}
+
+		f.Publish(&OperatorUpdate{
+			Type:             updateType,
+			Server:           server,
+			Healthy:          health.Healthy,
+			FailureTolerance: health.FailureTolerance,
+		})
+	}
+
+	if f.health.Healthy != health.Healthy || f.health.FailureTolerance != health.FailureTolerance {
+		updateType := "cluster-unhealthy"
+		if health.Healthy {
+			updateType = "cluster-healthy"
+		}
+
+		f.Publish(&OperatorUpdate{
+			Type:             updateType,
+			Healthy:          health.Healthy,
+			FailureTolerance: health.FailureTolerance,
+		})
+	}
+
+	f.health = health
+}
@@ -0,0 +1,260 @@
+package quotas
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	stopCh            chan struct{}
+	threshold         float64
+	nearLimit         map[string]bool
+}
+
+// QuotaUpdate ...
+type QuotaUpdate struct {
+	Type     string
+	Name     string
+	Spec     *nomad.QuotaSpec  `json:",omitempty"`
+	Usage    *nomad.QuotaUsage `json:",omitempty"`
+	Region   string            `json:",omitempty"`
+	Resource string            `json:",omitempty"`
+	Used     int               `json:",omitempty"`
+	Limit    int               `json:",omitempty"`
+	Percent  float64           `json:",omitempty"`
+}
+
+// NewFirehose ...
+func NewFirehose(region string, threshold float64) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		threshold:         threshold,
+		nearLimit:         make(map[string]bool),
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "quotas"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for quota usage changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *QuotaUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// checkLimits publish a near-limit event when a quota usage cross the configured threshold
+// of its limit, and a below-limit event once it goes back under it
+func (f *Firehose) checkLimits(spec *nomad.QuotaSpec, usage *nomad.QuotaUsage) {
+	for _, limit := range spec.Limits {
+		if limit.RegionLimit == nil {
+			continue
+		}
+
+		for _, used := range usage.Used {
+			if used.Region != limit.Region || used.RegionLimit == nil {
+				continue
+			}
+
+			f.checkLimit(spec.Name, limit.Region, "cpu", used.RegionLimit.CPU, limit.RegionLimit.CPU)
+			f.checkLimit(spec.Name, limit.Region, "memory", used.RegionLimit.MemoryMB, limit.RegionLimit.MemoryMB)
+		}
+	}
+}
+
+func (f *Firehose) checkLimit(name, region, resource string, used, limit *int) {
+	// a limit of 0 is unlimited, and a negative limit disallow the resource completely
+	if used == nil || limit == nil || *limit <= 0 {
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s/%s", name, region, resource)
+	percent := float64(*used) / float64(*limit) * 100
+	nearLimit := percent >= f.threshold
+
+	if nearLimit == f.nearLimit[key] {
+		return
+	}
+	f.nearLimit[key] = nearLimit
+
+	update := &QuotaUpdate{
+		Type:     "below-limit",
+		Name:     name,
+		Region:   region,
+		Resource: resource,
+		Used:     *used,
+		Limit:    *limit,
+		Percent:  percent,
+	}
+
+	if nearLimit {
+		update.Type = "near-limit"
+	}
+
+	f.Publish(update)
+}
+
+// Continously watch for changes to the quota usage list and publish it as updates
+func (f *Firehose) watch() {
+	q := &nomad.QueryOptions{
+		WaitIndex:  f.lastChangeIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.lastChangeIndex
+
+	for {
+		usages, meta, err := f.nomadClient.Quotas().ListUsage(q)
+		if err != nil {
+			log.Errorf("Unable to fetch quota usages: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Quota usages index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Quota usages index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		specs, _, err := f.nomadClient.Quotas().List(&nomad.QueryOptions{AllowStale: true})
+		if err != nil {
+			log.Errorf("Unable to fetch quota specifications: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		// Iterate quota specifications and find events that have changed since last run
+		limits := make(map[string]*nomad.QuotaSpec)
+		for _, spec := range specs {
+			limits[spec.Name] = spec
+
+			if spec.ModifyIndex <= f.lastChangeIndex {
+				continue
+			}
+
+			if spec.ModifyIndex > newMax {
+				newMax = spec.ModifyIndex
+			}
+
+			f.Publish(&QuotaUpdate{Type: "spec", Name: spec.Name, Spec: spec})
+		}
+
+		// Iterate quota usages and find events that have changed since last run
+		for _, usage := range usages {
+			if spec, ok := limits[usage.Name]; ok {
+				f.checkLimits(spec, usage)
+			}
+
+			if usage.ModifyIndex <= f.lastChangeIndex {
+				continue
+			}
+
+			if usage.ModifyIndex > newMax {
+				newMax = usage.ModifyIndex
+			}
+
+			f.Publish(&QuotaUpdate{Type: "usage", Name: usage.Name, Usage: usage})
+		}
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.lastChangeIndex = newMax
+	}
+}
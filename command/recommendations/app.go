@@ -0,0 +1,243 @@
+package recommendations
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	pool              *helper.KeyedPool
+	stopCh            chan struct{}
+	recommendations   map[string]*nomad.Recommendation
+}
+
+// RecommendationUpdate ...
+type RecommendationUpdate struct {
+	Type           string
+	Recommendation *nomad.Recommendation
+}
+
+// NewFirehose ...
+func NewFirehose(region string, workers int) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		pool:              helper.NewKeyedPool(workers),
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		recommendations:   make(map[string]*nomad.Recommendation),
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "recommendations"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for recommendation changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *RecommendationUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// resolution tell if a recommendation that went away was applied or dismissed, by checking if
+// the task resources now match the recommended value
+func (f *Firehose) resolution(recommendation *nomad.Recommendation) string {
+	job, _, err := f.nomadClient.Jobs().Info(recommendation.JobID, &nomad.QueryOptions{Namespace: recommendation.Namespace})
+	if err != nil {
+		log.Errorf("Could not read job %s/%s: %s", recommendation.Namespace, recommendation.JobID, err)
+		return "removed"
+	}
+
+	for _, group := range job.TaskGroups {
+		if group.Name == nil || *group.Name != recommendation.Group {
+			continue
+		}
+
+		for _, task := range group.Tasks {
+			if task.Name != recommendation.Task || task.Resources == nil {
+				continue
+			}
+
+			var value *int
+			switch recommendation.Resource {
+			case "CPU":
+				value = task.Resources.CPU
+			case "MemoryMB":
+				value = task.Resources.MemoryMB
+			}
+
+			if value != nil && *value == recommendation.Value {
+				return "applied"
+			}
+
+			return "dismissed"
+		}
+	}
+
+	return "removed"
+}
+
+// Continously watch for changes to the recommendation list and publish it as updates
+func (f *Firehose) watch() {
+	q := &nomad.QueryOptions{
+		Namespace:  "*",
+		WaitIndex:  f.lastChangeIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.lastChangeIndex
+
+	for {
+		recommendations, meta, err := f.nomadClient.Recommendations().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch recommendations: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Recommendations index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Recommendations index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		current := make(map[string]*nomad.Recommendation)
+
+		// Iterate recommendations and find events that have changed since last run
+		for _, recommendation := range recommendations {
+			current[recommendation.ID] = recommendation
+
+			if recommendation.ModifyIndex <= f.lastChangeIndex {
+				continue
+			}
+
+			if recommendation.ModifyIndex > newMax {
+				newMax = recommendation.ModifyIndex
+			}
+
+			if recommendation.CreateIndex > f.lastChangeIndex {
+				f.Publish(&RecommendationUpdate{Type: "created", Recommendation: recommendation})
+				continue
+			}
+
+			f.Publish(&RecommendationUpdate{Type: "updated", Recommendation: recommendation})
+		}
+
+		batch := f.pool.Batch()
+
+		// Recommendations we knew about that are no longer listed have been applied or dismissed
+		for id, recommendation := range f.recommendations {
+			if _, ok := current[id]; ok {
+				continue
+			}
+
+			recommendation := recommendation
+			batch.Go(recommendation.ID, func() {
+				f.Publish(&RecommendationUpdate{Type: f.resolution(recommendation), Recommendation: recommendation})
+			})
+		}
+
+		// wait for the events to be published, so a sink that is slow or down holds back the
+		// watcher instead of piling up goroutines
+		batch.Wait()
+
+		f.recommendations = current
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.lastChangeIndex = newMax
+	}
+}
@@ -0,0 +1,312 @@
+package scaling
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	pool              *helper.KeyedPool
+	stopCh            chan struct{}
+	// committed index of the event and policy watchers, lastChangeIndex being the lowest of them
+	jobIndex    uint64
+	policyIndex uint64
+	lock        sync.Mutex
+}
+
+// ScalingUpdate ...
+type ScalingUpdate struct {
+	Type      string
+	JobID     string
+	Namespace string
+	TaskGroup string               `json:",omitempty"`
+	Event     *nomad.ScalingEvent  `json:",omitempty"`
+	Policy    *nomad.ScalingPolicy `json:",omitempty"`
+}
+
+// NewFirehose ...
+func NewFirehose(region string, workers int) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		pool:              helper.NewKeyedPool(workers),
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "scaling"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+
+	f.jobIndex = f.lastChangeIndex
+	f.policyIndex = f.lastChangeIndex
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for scaling events and scaling policy changes
+	go f.watchEvents()
+	go f.watchPolicies()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.restoreIndex()
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.restoreIndex()
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *ScalingUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// restoreIndex is the index to persist
+func (f *Firehose) restoreIndex() uint64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.lastChangeIndex
+}
+
+// updateLastChangeIndex commit the index of the event or policy watcher, the restore point being
+// the lowest of the two so the lagging watcher doesn't lose its events on restart
+func (f *Firehose) updateLastChangeIndex(index *uint64, newIndex uint64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	*index = newIndex
+
+	f.lastChangeIndex = f.jobIndex
+	if f.policyIndex < f.lastChangeIndex {
+		f.lastChangeIndex = f.policyIndex
+	}
+}
+
+// Continously watch for job changes and publish the new scaling events of the changed jobs
+//
+// Scaling events are read from the job scale status, so scale requests that only register an
+// event without changing the job are picked up with the next change to the job
+func (f *Firehose) watchEvents() {
+	q := &nomad.QueryOptions{
+		Namespace:  "*",
+		WaitIndex:  f.jobIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.jobIndex
+
+	for {
+		jobs, meta, err := f.nomadClient.Jobs().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch jobs: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Jobs index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Jobs index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		batch := f.pool.Batch()
+
+		// Iterate jobs and find events that have changed since last run
+		for _, job := range jobs {
+			if job.ModifyIndex <= f.jobIndex {
+				continue
+			}
+
+			if job.ModifyIndex > newMax {
+				newMax = job.ModifyIndex
+			}
+
+			jobID, namespace, since := job.ID, job.Namespace, f.jobIndex
+			batch.Go(namespace+"/"+jobID, func() {
+				status, _, err := f.nomadClient.Jobs().ScaleStatus(jobID, &nomad.QueryOptions{Namespace: namespace})
+				if err != nil {
+					log.Errorf("Could not read scale status of job %s/%s: %s", namespace, jobID, err)
+					return
+				}
+
+				for group, groupStatus := range status.TaskGroups {
+					for i := range groupStatus.Events {
+						event := groupStatus.Events[i]
+						if event.CreateIndex <= since {
+							continue
+						}
+
+						f.Publish(&ScalingUpdate{
+							Type:      "event",
+							JobID:     jobID,
+							Namespace: namespace,
+							TaskGroup: group,
+							Event:     &event,
+						})
+					}
+				}
+			})
+		}
+
+		// wait for the events to be published, so a sink that is slow or down holds back the
+		// watcher instead of piling up goroutines
+		batch.Wait()
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.updateLastChangeIndex(&f.jobIndex, newMax)
+	}
+}
+
+// Continously watch for changes to the scaling policy list and publish it as updates
+func (f *Firehose) watchPolicies() {
+	q := &nomad.QueryOptions{
+		Namespace:  "*",
+		WaitIndex:  f.policyIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.policyIndex
+
+	for {
+		policies, meta, err := f.nomadClient.Scaling().ListPolicies(q)
+		if err != nil {
+			log.Errorf("Unable to fetch scaling policies: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Scaling policies index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Scaling policies index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		batch := f.pool.Batch()
+
+		// Iterate policies and find events that have changed since last run
+		for _, policy := range policies {
+			if policy.ModifyIndex <= f.policyIndex {
+				continue
+			}
+
+			if policy.ModifyIndex > newMax {
+				newMax = policy.ModifyIndex
+			}
+
+			policyID := policy.ID
+			batch.Go(policyID, func() {
+				fullPolicy, _, err := f.nomadClient.Scaling().GetPolicy(policyID, &nomad.QueryOptions{})
+				if err != nil {
+					log.Errorf("Could not read scaling policy %s: %s", policyID, err)
+					return
+				}
+
+				f.Publish(&ScalingUpdate{
+					Type:      "policy",
+					JobID:     fullPolicy.Target["Job"],
+					Namespace: fullPolicy.Namespace,
+					TaskGroup: fullPolicy.Target["Group"],
+					Policy:    fullPolicy,
+				})
+			})
+		}
+
+		// wait for the events to be published, so a sink that is slow or down holds back the
+		// watcher instead of piling up goroutines
+		batch.Wait()
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.updateLastChangeIndex(&f.policyIndex, newMax)
+	}
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeIndex   uint64
+	lastChangeIndexCh chan interface{}
+	nomadClient       *nomad.Client
+	sink              sink.Sink
+	stopCh            chan struct{}
+	checkInterval     time.Duration
+	lock              sync.Mutex
+	// nil until the registrations are read for the first time
+	registrations map[string]*nomad.ServiceRegistration
+	// health of the registrations with checks, healthy or unhealthy, nil until the first poll
+	health map[string]string
+}
+
+// ServiceUpdate ...
+type ServiceUpdate struct {
+	Type    string
+	Service *nomad.ServiceRegistration
+	// the checks of the service, for the healthy and unhealthy events
+	Checks []nomad.AllocCheckStatus `json:",omitempty"`
+}
+
+// NewFirehose ...
+func NewFirehose(region string, checkInterval time.Duration) (*Firehose, error) {
+	if checkInterval <= 0 {
+		return nil, fmt.Errorf("Invalid check interval, must be positive")
+	}
+
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:       nomadClient,
+		sink:              sink,
+		stopCh:            make(chan struct{}, 1),
+		lastChangeIndexCh: make(chan interface{}, 1),
+		checkInterval:     checkInterval,
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "services"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeIndexCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeIndex = uint64(restoreValue.(int))
+	case int64:
+		f.lastChangeIndex = uint64(restoreValue.(int64))
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for service changes
+	go f.watch()
+
+	// poll the checks of the registrations for health changes
+	go f.watchHealth()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	select {
+	case <-f.stopCh:
+		return
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeIndex
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeIndexCh <- value
+			}
+		}
+	}
+}
+
+// Publish an update from the firehose
+func (f *Firehose) Publish(update *ServiceUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// Continously watch for changes to the service list and publish it as updates
+func (f *Firehose) watch() {
+	q := &nomad.QueryOptions{
+		Namespace:  "*",
+		WaitIndex:  f.lastChangeIndex,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.lastChangeIndex
+
+	for {
+		namespaces, meta, err := f.nomadClient.Services().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch services: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Services index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Services index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		// Read all registrations of all services, so we can find both new and removed instances
+		current := make(map[string]*nomad.ServiceRegistration)
+		complete := true
+
+		for _, namespace := range namespaces {
+			for _, service := range namespace.Services {
+				registrations, _, err := f.nomadClient.Services().Get(service.ServiceName, &nomad.QueryOptions{Namespace: namespace.Namespace})
+				if err != nil {
+					log.Errorf("Could not read service %s/%s: %s", namespace.Namespace, service.ServiceName, err)
+					complete = false
+					continue
+				}
+
+				for _, registration := range registrations {
+					current[registration.ID] = registration
+				}
+			}
+		}
+
+		// Iterate registrations and find those that have changed since last run
+		for id, registration := range current {
+			if registration.ModifyIndex <= f.lastChangeIndex {
+				continue
+			}
+
+			if registration.ModifyIndex > newMax {
+				newMax = registration.ModifyIndex
+			}
+
+			if _, ok := f.registrations[id]; ok {
+				f.Publish(&ServiceUpdate{Type: "update", Service: registration})
+				continue
+			}
+
+			f.Publish(&ServiceUpdate{Type: "register", Service: registration})
+		}
+
+		// Registrations we knew about that are gone have been deregistered, unless we
+		// failed to read some services and can't tell for sure
+		for id, registration := range f.registrations {
+			if _, ok := current[id]; ok {
+				continue
+			}
+
+			if !complete {
+				current[id] = registration
+				continue
+			}
+
+			f.Publish(&ServiceUpdate{Type: "deregister", Service: registration})
+		}
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.lastChangeIndex = newMax
+
+		f.lock.Lock()
+		f.registrations = current
+		f.lock.Unlock()
+	}
+}
+
+// Periodically poll the checks of the registrations, and publish health changes as updates
+//
+// Nomad keeps the registrations of instances failing their checks, and check results don't change
+// the service index, so they're read from the allocations. The first poll only records the
+// current health
+func (f *Firehose) watchHealth() {
+	ticker := time.NewTicker(f.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stopCh:
+			return
+		case <-ticker.C:
+			f.pollHealth()
+		}
+	}
+}
+
+// pollHealth read the checks of every allocation with registrations once
+func (f *Firehose) pollHealth() {
+	f.lock.Lock()
+	if f.registrations == nil {
+		f.lock.Unlock()
+		return
+	}
+	registrations := make([]*nomad.ServiceRegistration, 0, len(f.registrations))
+	for _, registration := range f.registrations {
+		registrations = append(registrations, registration)
+	}
+	f.lock.Unlock()
+
+	allocations := make(map[string]nomad.AllocCheckStatuses)
+	current := make(map[string]string)
+
+	for _, registration := range registrations {
+		checks, ok := allocations[registration.AllocID]
+		if !ok {
+			var err error
+			checks, err = f.nomadClient.Allocations().Checks(registration.AllocID, &nomad.QueryOptions{Namespace: registration.Namespace})
+			if err != nil {
+				// the registrations of the allocation keep their last known health
+				log.Errorf("Could not read checks of allocation %s: %s", registration.AllocID, err)
+			}
+			allocations[registration.AllocID] = checks
+		}
+
+		var serviceChecks []nomad.AllocCheckStatus
+		for _, check := range checks {
+			if check.Service == registration.ServiceName {
+				serviceChecks = append(serviceChecks, check)
+			}
+		}
+		sort.Slice(serviceChecks, func(i, j int) bool {
+			return serviceChecks[i].Check < serviceChecks[j].Check
+		})
+
+		health := serviceHealth(serviceChecks)
+		if health == "" {
+			// pending checks keep the last known health
+			if previous, ok := f.health[registration.ID]; ok {
+				current[registration.ID] = previous
+			}
+			continue
+		}
+		current[registration.ID] = health
+
+		// nothing to compare with on the first poll
+		if f.health == nil || f.health[registration.ID] == health {
+			continue
+		}
+
+		f.Publish(&ServiceUpdate{Type: health, Service: registration, Checks: serviceChecks})
+	}
+
+	f.health = current
+}
+
+// serviceHealth tell if the checks of a service pass, empty while they're pending or without
+// checks
+func serviceHealth(checks []nomad.AllocCheckStatus) string {
+	if len(checks) == 0 {
+		return ""
+	}
+
+	for _, check := range checks {
+		if check.Status == "failure" {
+			return "unhealthy"
+		}
+	}
+
+	for _, check := range checks {
+		if check.Status != "success" {
+			return ""
+		}
+	}
+
+	return "healthy"
+}
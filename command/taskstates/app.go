@@ -0,0 +1,209 @@
+package taskstates
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Firehose ...
+type Firehose struct {
+	lastChangeTime   int64
+	lastChangeTimeCh chan interface{}
+	nomadClient      *nomad.Client
+	sink             sink.Sink
+	stopCh           chan struct{}
+}
+
+// TaskStateUpdate is a compact, per task transition view of an allocation
+type TaskStateUpdate struct {
+	AllocationID   string
+	AllocationName string
+	JobID          string
+	Namespace      string
+	NodeID         string
+	GroupName      string
+	TaskName       string
+	State          string
+	Failed         bool
+	Restarts       uint64
+	Type           string
+	Time           int64
+	ExitCode       int
+	Signal         int
+	OOMKilled      bool
+	Message        string
+}
+
+// NewFirehose ...
+func NewFirehose(region string) (*Firehose, error) {
+	nomadClient, err := nomad.NewClient(helper.NomadConfig(region))
+	if err != nil {
+		return nil, err
+	}
+
+	sink, err := sink.GetSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Firehose{
+		nomadClient:      nomadClient,
+		sink:             sink,
+		stopCh:           make(chan struct{}, 1),
+		lastChangeTimeCh: make(chan interface{}, 1),
+	}, nil
+}
+
+func (f *Firehose) Name() string {
+	return "taskstates"
+}
+
+func (f *Firehose) UpdateCh() <-chan interface{} {
+	return f.lastChangeTimeCh
+}
+
+func (f *Firehose) SetRestoreValue(restoreValue interface{}) error {
+	switch restoreValue.(type) {
+	case int:
+		f.lastChangeTime = int64(restoreValue.(int))
+	case int64:
+		f.lastChangeTime = restoreValue.(int64)
+	default:
+		return fmt.Errorf("Unknown restore type '%T' with value '%+v'", restoreValue, restoreValue)
+	}
+	return nil
+}
+
+// Start the firehose
+func (f *Firehose) Start() {
+	go f.sink.Start()
+
+	// watch for allocation changes
+	go f.watch()
+
+	// Save the last event time every 5s
+	go f.persistLastChangeTime(5 * time.Second)
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-f.stopCh:
+			return
+		}
+	}
+}
+
+// Stop the firehose
+func (f *Firehose) Stop() {
+	close(f.stopCh)
+	f.sink.Stop()
+}
+
+// Write the Last Change Time to Consul so if the process restarts,
+// it will try to resume from where it left off, not emitting tons of double events for
+// old events
+func (f *Firehose) persistLastChangeTime(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	for {
+		select {
+		case <-f.stopCh:
+			value := f.lastChangeTime
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
+			return
+		case <-ticker.C:
+			value := f.lastChangeTime
+			if sink.Acknowledged(f.sink) {
+				f.lastChangeTimeCh <- value
+			}
+		}
+	}
+}
+
+// publish an update from the firehose
+func (f *Firehose) publish(update *TaskStateUpdate) {
+	b, err := json.Marshal(update)
+	if err != nil {
+		log.Error(err)
+	}
+
+	f.sink.Put(b)
+}
+
+// Continously watch for changes to the allocation list and publish a message per task transition
+func (f *Firehose) watch() {
+	q := &nomad.QueryOptions{
+		Namespace:  "*",
+		WaitIndex:  1,
+		WaitTime:   5 * time.Minute,
+		AllowStale: true,
+	}
+
+	newMax := f.lastChangeTime
+
+	for {
+		allocations, meta, err := f.nomadClient.Allocations().List(q)
+		if err != nil {
+			log.Errorf("Unable to fetch allocations: %s", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+
+		remoteWaitIndex := meta.LastIndex
+		localWaitIndex := q.WaitIndex
+
+		// Only work if the WaitIndex have changed
+		if remoteWaitIndex == localWaitIndex {
+			log.Debugf("Allocations index is unchanged (%d == %d)", remoteWaitIndex, localWaitIndex)
+			continue
+		}
+
+		log.Debugf("Allocations index is changed (%d <> %d)", remoteWaitIndex, localWaitIndex)
+
+		// Iterate allocations and find task transitions that have happened since last run
+		for _, allocation := range allocations {
+			for taskName, taskInfo := range allocation.TaskStates {
+				for _, taskEvent := range taskInfo.Events {
+					if taskEvent.Time <= f.lastChangeTime {
+						continue
+					}
+
+					if taskEvent.Time > newMax {
+						newMax = taskEvent.Time
+					}
+
+					f.publish(&TaskStateUpdate{
+						AllocationID:   allocation.ID,
+						AllocationName: allocation.Name,
+						JobID:          allocation.JobID,
+						Namespace:      allocation.Namespace,
+						NodeID:         allocation.NodeID,
+						GroupName:      allocation.TaskGroup,
+						TaskName:       taskName,
+						State:          taskInfo.State,
+						Failed:         taskInfo.Failed,
+						Restarts:       taskInfo.Restarts,
+						Type:           taskEvent.Type,
+						Time:           taskEvent.Time,
+						ExitCode:       taskEvent.ExitCode,
+						Signal:         taskEvent.Signal,
+						OOMKilled:      taskEvent.Details["oom_killed"] == "true",
+						Message:        taskEvent.DisplayMessage,
+					})
+				}
+			}
+		}
+
+		// Update WaitIndex and Last Change Time for next iteration
+		q.WaitIndex = meta.LastIndex
+		f.lastChangeTime = newMax
+	}
+}
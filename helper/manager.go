@@ -37,6 +37,12 @@ type Manager struct {
 	logger                   *log.Entry       // logger for the consul connection struct
 	stopCh                   chan interface{} // internal channel used to stop all go-routines when gracefully shutting down
 	voluntarilyReleaseLockCh chan interface{}
+	dryRun                   bool // run without the Consul lock and without writing the index to Consul KV
+}
+
+// SetDryRun run the firehose without the Consul lock and without writing its index
+func (m *Manager) SetDryRun(dryRun bool) {
+	m.dryRun = dryRun
 }
 
 // cleanup will do cleanup tasks when the reconciler is shutting down
@@ -217,9 +223,36 @@ func (m *Manager) Start() error {
 	}
 
 	go m.signalHandler()
+
+	if m.dryRun {
+		return m.runDryRun()
+	}
 	return m.continuouslyAcquireConsulLeadership()
 }
 
+// runDryRun run the firehose from the stored index, discarding the index updates, until the
+// stopCh is closed
+func (m *Manager) runDryRun() error {
+	m.logger.Warn("Dry run, not acquiring the Consul lock nor writing lastChangedTime to KV")
+
+	if err := m.runner.SetRestoreValue(m.restoreLastChangeTime()); err != nil {
+		return err
+	}
+
+	go m.runner.Start()
+	defer m.runner.Stop()
+
+	for {
+		select {
+		case v := <-m.runner.UpdateCh():
+			m.logger.Debugf("Dry run, not writing lastChangedTime to KV: %v", v)
+
+		case <-m.stopCh:
+			return nil
+		}
+	}
+}
+
 // Close the stopCh if we get a signal, so we can gracefully shut down
 func (m *Manager) signalHandler() {
 	m.logger.Info("Starting signal handler")
@@ -0,0 +1,69 @@
+package helper
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// DefaultPublishWorkers is the default number of workers of a KeyedPool (--publish-workers)
+const DefaultPublishWorkers = 8
+
+// KeyedPool publish the events of the watch passes of a firehose with a fixed number of workers,
+// the events of the same key (job, allocation, node, ...) always going to the same worker, so
+// they're published one after the other in the order they were submitted, while events of other
+// keys are published in parallel. The workers run as long as the firehose
+type KeyedPool struct {
+	workers []chan func()
+}
+
+// KeyedBatch are the functions submitted to a pool by a watch pass, waited for together
+type KeyedBatch struct {
+	pool *KeyedPool
+	wg   sync.WaitGroup
+}
+
+// NewKeyedPool start the workers of a pool, DefaultPublishWorkers of them unless count is positive
+func NewKeyedPool(count int) *KeyedPool {
+	if count < 1 {
+		count = DefaultPublishWorkers
+	}
+
+	p := &KeyedPool{workers: make([]chan func(), count)}
+	for i := range p.workers {
+		p.workers[i] = make(chan func(), 100)
+		go p.work(p.workers[i])
+	}
+
+	return p
+}
+
+// Batch start a watch pass, the watchers of a firehose sharing its pool each waiting for their
+// own functions
+func (p *KeyedPool) Batch() *KeyedBatch {
+	return &KeyedBatch{pool: p}
+}
+
+// Go run fn on the worker of key, after the functions submitted before for the same key
+func (b *KeyedBatch) Go(key string, fn func()) {
+	b.wg.Add(1)
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	b.pool.workers[h.Sum32()%uint32(len(b.pool.workers))] <- func() {
+		defer b.wg.Done()
+		fn()
+	}
+}
+
+// Wait for the functions of the batch to be done, before committing the index of the watch pass.
+// The workers keep running for the next passes
+func (b *KeyedBatch) Wait() {
+	b.wg.Wait()
+}
+
+func (p *KeyedPool) work(fns <-chan func()) {
+	for fn := range fns {
+		fn()
+	}
+}
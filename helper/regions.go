@@ -0,0 +1,67 @@
+package helper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	nomad "github.com/hashicorp/nomad/api"
+)
+
+// Regions returns the list of regions to watch from a comma separated list, "*" will
+// discover all the regions known to the nomad cluster
+func Regions(spec string) ([]string, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	if spec == "*" {
+		client, err := nomad.NewClient(nomad.DefaultConfig())
+		if err != nil {
+			return nil, err
+		}
+
+		regions, err := client.Regions().List()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to discover nomad regions: %s", err)
+		}
+
+		sort.Strings(regions)
+		return regions, nil
+	}
+
+	var regions []string
+	for _, region := range strings.Split(spec, ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			regions = append(regions, region)
+		}
+	}
+
+	return regions, nil
+}
+
+// NomadConfig return the configuration of the nomad client from the NOMAD_* environment
+// variables, for region when not empty
+func NomadConfig(region string) *nomad.Config {
+	config := nomad.DefaultConfig()
+	if region != "" {
+		config.Region = region
+	}
+	return config
+}
+
+// NewRegionRunner scope a runner to a region, so each region has its own Consul lock
+// and restore value
+func NewRegionRunner(r Runner, region string) Runner {
+	return &regionRunner{Runner: r, region: region}
+}
+
+type regionRunner struct {
+	Runner
+	region string
+}
+
+func (r *regionRunner) Name() string {
+	return fmt.Sprintf("%s/%s", r.Runner.Name(), r.region)
+}
@@ -1,16 +1,46 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"sort"
+	"strings"
+	"time"
 
 	gelf "github.com/seatgeek/logrus-gelf-formatter"
+	"github.com/seatgeek/nomad-firehose/command/acl"
 	"github.com/seatgeek/nomad-firehose/command/allocations"
+	"github.com/seatgeek/nomad-firehose/command/allocstats"
+	"github.com/seatgeek/nomad-firehose/command/blockedevals"
+	"github.com/seatgeek/nomad-firehose/command/csiplugins"
+	"github.com/seatgeek/nomad-firehose/command/csivolumes"
+	"github.com/seatgeek/nomad-firehose/command/deploymentevents"
 	"github.com/seatgeek/nomad-firehose/command/deployments"
+	"github.com/seatgeek/nomad-firehose/command/dispatches"
 	"github.com/seatgeek/nomad-firehose/command/evaluations"
+	"github.com/seatgeek/nomad-firehose/command/events"
+	"github.com/seatgeek/nomad-firehose/command/hostvolumes"
+	"github.com/seatgeek/nomad-firehose/command/jobdiffs"
 	"github.com/seatgeek/nomad-firehose/command/jobs"
+	"github.com/seatgeek/nomad-firehose/command/jobsummaries"
+	"github.com/seatgeek/nomad-firehose/command/license"
+	"github.com/seatgeek/nomad-firehose/command/logs"
+	"github.com/seatgeek/nomad-firehose/command/members"
+	"github.com/seatgeek/nomad-firehose/command/namespaces"
+	"github.com/seatgeek/nomad-firehose/command/nodeevents"
+	"github.com/seatgeek/nomad-firehose/command/nodepools"
 	"github.com/seatgeek/nomad-firehose/command/nodes"
+	"github.com/seatgeek/nomad-firehose/command/operator"
+	"github.com/seatgeek/nomad-firehose/command/periodic"
+	"github.com/seatgeek/nomad-firehose/command/quotas"
+	"github.com/seatgeek/nomad-firehose/command/recommendations"
+	"github.com/seatgeek/nomad-firehose/command/scaling"
+	"github.com/seatgeek/nomad-firehose/command/sentinel"
+	"github.com/seatgeek/nomad-firehose/command/services"
+	"github.com/seatgeek/nomad-firehose/command/taskstates"
+	"github.com/seatgeek/nomad-firehose/command/variables"
 	"github.com/seatgeek/nomad-firehose/helper"
+	"github.com/seatgeek/nomad-firehose/sink"
 	log "github.com/sirupsen/logrus"
 	cli "gopkg.in/urfave/cli.v1"
 )
@@ -34,96 +64,423 @@ func main() {
 			Usage:  "json or text",
 			EnvVar: "LOG_FORMAT",
 		},
+		cli.StringFlag{
+			Name:   "regions",
+			Usage:  "Comma separated list of nomad regions to watch, or * for all regions of the cluster (default: the region of the nomad agent)",
+			EnvVar: "NOMAD_FIREHOSE_REGIONS",
+		},
+		cli.BoolFlag{
+			Name:   "envelope",
+			Usage:  "Wrap every event in a {type, id, index, emitted_at, cluster, payload} envelope",
+			EnvVar: "NOMAD_FIREHOSE_ENVELOPE",
+		},
+		cli.IntFlag{
+			Name:   "publish-workers",
+			Value:  helper.DefaultPublishWorkers,
+			Usage:  "Number of workers publishing events in parallel, the events of a job, allocation, node, ... being published in order by the same worker",
+			EnvVar: "NOMAD_FIREHOSE_PUBLISH_WORKERS",
+		},
+		cli.BoolFlag{
+			Name:   "dry-run",
+			Usage:  "Count the events with the null sink, without the Consul lock and without persisting the index",
+			EnvVar: "NOMAD_FIREHOSE_DRY_RUN",
+		},
+		cli.StringFlag{
+			Name:   "encoding",
+			Value:  "json",
+			Usage:  "Encoding of the events, json, avro, msgpack or protobuf (proto/events.proto), for the sinks supporting it",
+			EnvVar: "NOMAD_FIREHOSE_ENCODING",
+		},
 	}
 	app.Commands = []cli.Command{
 		{
 			Name:  "allocations",
 			Usage: "Firehose nomad allocation changes",
+			Flags: []cli.Flag{namespaceFlag},
 			Action: func(c *cli.Context) error {
-				firehose, err := allocations.NewFirehose()
-				if err != nil {
-					return err
-				}
-
-				manager := helper.NewManager(firehose)
-				if err := manager.Start(); err != nil {
-					log.Fatal(err)
-					return err
-				}
-
-				return nil
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return allocations.NewFirehose(region, namespaceList(c))
+				})
 			},
 		},
 		{
 			Name:  "nodes",
 			Usage: "Firehose nomad node changes",
 			Action: func(c *cli.Context) error {
-				firehose, err := nodes.NewFirehose()
-				if err != nil {
-					return err
-				}
-
-				manager := helper.NewManager(firehose)
-				if err := manager.Start(); err != nil {
-					log.Fatal(err)
-					return err
-				}
-
-				return nil
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return nodes.NewFirehose(region, c.GlobalInt("publish-workers"))
+				})
 			},
 		},
 		{
 			Name:  "evaluations",
 			Usage: "Firehose nomad evaluation changes",
 			Action: func(c *cli.Context) error {
-				firehose, err := evaluations.NewFirehose()
-				if err != nil {
-					return err
-				}
-
-				manager := helper.NewManager(firehose)
-				if err := manager.Start(); err != nil {
-					log.Fatal(err)
-					return err
-				}
-
-				return nil
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return evaluations.NewFirehose(region)
+				})
 			},
 		},
 		{
 			Name:  "jobs",
 			Usage: "Firehose nomad job changes",
+			Flags: []cli.Flag{namespaceFlag},
 			Action: func(c *cli.Context) error {
-				firehose, err := jobs.NewFirehose()
-				if err != nil {
-					return err
-				}
-
-				manager := helper.NewManager(firehose)
-				if err := manager.Start(); err != nil {
-					log.Fatal(err)
-					return err
-				}
-
-				return nil
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return jobs.NewFirehose(region, c.GlobalInt("publish-workers"), namespaceList(c))
+				})
 			},
 		},
 		{
 			Name:  "deployments",
 			Usage: "Firehose nomad deployment changes",
 			Action: func(c *cli.Context) error {
-				firehose, err := deployments.NewFirehose()
-				if err != nil {
-					return err
-				}
-
-				manager := helper.NewManager(firehose)
-				if err := manager.Start(); err != nil {
-					log.Fatal(err)
-					return err
-				}
-
-				return nil
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return deployments.NewFirehose(region, c.GlobalInt("publish-workers"))
+				})
+			},
+		},
+		{
+			Name:  "events",
+			Usage: "Firehose the nomad event stream",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "topics",
+					Value:  "*",
+					Usage:  "Comma separated list of event topics to subscribe to (Job, Allocation, Deployment, Evaluation, Node, ...), optionally filtered by key (Job:my-job)",
+					EnvVar: "EVENT_TOPICS",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return events.NewFirehose(region, strings.Split(c.String("topics"), ","))
+				})
+			},
+		},
+		{
+			Name:  "services",
+			Usage: "Firehose nomad service registration and health changes",
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:   "check-interval",
+					Value:  10 * time.Second,
+					Usage:  "How often to poll the checks of the registered services",
+					EnvVar: "SERVICES_CHECK_INTERVAL",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return services.NewFirehose(region, c.Duration("check-interval"))
+				})
+			},
+		},
+		{
+			Name:  "csi-volumes",
+			Usage: "Firehose nomad CSI volume changes",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return csivolumes.NewFirehose(region, c.GlobalInt("publish-workers"))
+				})
+			},
+		},
+		{
+			Name:  "csi-plugins",
+			Usage: "Firehose nomad CSI plugin changes",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return csiplugins.NewFirehose(region, c.GlobalInt("publish-workers"))
+				})
+			},
+		},
+		{
+			Name:  "namespaces",
+			Usage: "Firehose nomad namespace changes",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return namespaces.NewFirehose(region)
+				})
+			},
+		},
+		{
+			Name:  "quotas",
+			Usage: "Firehose nomad quota specification and usage changes",
+			Flags: []cli.Flag{
+				cli.Float64Flag{
+					Name:   "near-limit-threshold",
+					Value:  90,
+					Usage:  "Percentage of a quota limit at which a near-limit event is emitted",
+					EnvVar: "QUOTA_NEAR_LIMIT_THRESHOLD",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return quotas.NewFirehose(region, c.Float64("near-limit-threshold"))
+				})
+			},
+		},
+		{
+			Name:  "acl",
+			Usage: "Firehose nomad ACL token and policy changes",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:   "skip-anonymous",
+					Usage:  "Do not emit events for the anonymous token",
+					EnvVar: "ACL_SKIP_ANONYMOUS",
+				},
+				cli.BoolFlag{
+					Name:   "skip-management",
+					Usage:  "Do not emit events for management tokens",
+					EnvVar: "ACL_SKIP_MANAGEMENT",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return acl.NewFirehose(region, c.GlobalInt("publish-workers"), c.Bool("skip-anonymous"), c.Bool("skip-management"))
+				})
+			},
+		},
+		{
+			Name:  "variables",
+			Usage: "Firehose nomad variable metadata changes",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return variables.NewFirehose(region)
+				})
+			},
+		},
+		{
+			Name:  "scaling",
+			Usage: "Firehose nomad scaling events and scaling policy changes",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return scaling.NewFirehose(region, c.GlobalInt("publish-workers"))
+				})
+			},
+		},
+		{
+			Name:  "job-diffs",
+			Usage: "Firehose nomad job changes with the diff to the previous job version",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return jobdiffs.NewFirehose(region, c.GlobalInt("publish-workers"))
+				})
+			},
+		},
+		{
+			Name:  "periodic-launches",
+			Usage: "Firehose nomad periodic job launches",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return periodic.NewFirehose(region)
+				})
+			},
+		},
+		{
+			Name:  "dispatches",
+			Usage: "Firehose nomad parameterized job dispatches",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "redact-meta",
+					Usage:  "Comma separated list of dispatch meta keys to redact the value of, or * to redact all values",
+					EnvVar: "DISPATCH_REDACT_META",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return dispatches.NewFirehose(region, c.GlobalInt("publish-workers"), strings.Split(c.String("redact-meta"), ","))
+				})
+			},
+		},
+		{
+			Name:  "node-pools",
+			Usage: "Firehose nomad node pool changes",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return nodepools.NewFirehose(region)
+				})
+			},
+		},
+		{
+			Name:  "node-events",
+			Usage: "Firehose nomad node lifecycle events",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return nodeevents.NewFirehose(region)
+				})
+			},
+		},
+		{
+			Name:  "taskstates",
+			Usage: "Firehose nomad task state transitions",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return taskstates.NewFirehose(region)
+				})
+			},
+		},
+		{
+			Name:  "allocation-stats",
+			Usage: "Periodically firehose the resource usage of running nomad allocations",
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:   "interval",
+					Value:  time.Minute,
+					Usage:  "How often to sample the resource usage of running allocations",
+					EnvVar: "ALLOCATION_STATS_INTERVAL",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return allocstats.NewFirehose(region, c.GlobalInt("publish-workers"), c.Duration("interval"))
+				})
+			},
+		},
+		{
+			Name:  "job-summaries",
+			Usage: "Firehose nomad job summary changes",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return jobsummaries.NewFirehose(region)
+				})
+			},
+		},
+		{
+			Name:  "logs",
+			Usage: "Firehose the stdout / stderr of running nomad allocations",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "job",
+					Value:  ".*",
+					Usage:  "Regular expression the job ID must match for its allocation logs to be followed",
+					EnvVar: "LOGS_JOB_FILTER",
+				},
+				cli.StringFlag{
+					Name:   "task",
+					Value:  ".*",
+					Usage:  "Regular expression the task name must match for its logs to be followed",
+					EnvVar: "LOGS_TASK_FILTER",
+				},
+				cli.StringFlag{
+					Name:   "log-types",
+					Value:  "stdout,stderr",
+					Usage:  "Comma separated list of logs to follow (stdout, stderr)",
+					EnvVar: "LOGS_TYPES",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return logs.NewFirehose(region, c.String("job"), c.String("task"), strings.Split(c.String("log-types"), ","))
+				})
+			},
+		},
+		{
+			Name:  "members",
+			Usage: "Firehose nomad server membership and leadership changes",
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:   "interval",
+					Value:  10 * time.Second,
+					Usage:  "How often to poll the server members",
+					EnvVar: "MEMBERS_INTERVAL",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return members.NewFirehose(region, c.Duration("interval"))
+				})
+			},
+		},
+		{
+			Name:  "operator",
+			Usage: "Firehose nomad raft peer and autopilot server health changes",
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:   "interval",
+					Value:  10 * time.Second,
+					Usage:  "How often to poll the raft configuration and autopilot health",
+					EnvVar: "OPERATOR_INTERVAL",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return operator.NewFirehose(region, c.Duration("interval"))
+				})
+			},
+		},
+		{
+			Name:  "host-volumes",
+			Usage: "Firehose nomad dynamic host volume changes",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return hostvolumes.NewFirehose(region)
+				})
+			},
+		},
+		{
+			Name:  "recommendations",
+			Usage: "Firehose nomad dynamic application sizing recommendation changes",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return recommendations.NewFirehose(region, c.GlobalInt("publish-workers"))
+				})
+			},
+		},
+		{
+			Name:  "sentinel-policies",
+			Usage: "Firehose nomad sentinel policy changes",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return sentinel.NewFirehose(region, c.GlobalInt("publish-workers"))
+				})
+			},
+		},
+		{
+			Name:  "deployment-events",
+			Usage: "Firehose nomad deployment lifecycle events",
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return deploymentevents.NewFirehose(region)
+				})
+			},
+		},
+		{
+			Name:  "blocked-evaluations",
+			Usage: "Firehose nomad evaluations blocked longer than a threshold",
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:   "threshold",
+					Value:  5 * time.Minute,
+					Usage:  "How long an evaluation must be blocked before emitting a placement-starved event",
+					EnvVar: "BLOCKED_EVALUATIONS_THRESHOLD",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return blockedevals.NewFirehose(region, c.Duration("threshold"))
+				})
+			},
+		},
+		{
+			Name:  "license",
+			Usage: "Firehose nomad enterprise license changes and upcoming expiry",
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:   "interval",
+					Value:  time.Hour,
+					Usage:  "How often to poll the license",
+					EnvVar: "LICENSE_INTERVAL",
+				},
+				cli.DurationFlag{
+					Name:   "lead-time",
+					Value:  30 * 24 * time.Hour,
+					Usage:  "How long before the license expires to emit an expiring event",
+					EnvVar: "LICENSE_EXPIRY_LEAD_TIME",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return runFirehose(c, func(region string) (helper.Runner, error) {
+					return license.NewFirehose(region, c.Duration("interval"), c.Duration("lead-time"))
+				})
 			},
 		},
 	}
@@ -150,3 +507,109 @@ func main() {
 	sort.Sort(cli.FlagsByName(app.Flags))
 	app.Run(os.Args)
 }
+
+// namespaceFlag is shared by the firehoses supporting multiple namespaces
+var namespaceFlag = cli.StringFlag{
+	Name:   "namespace",
+	Usage:  "Comma separated list of nomad namespaces to watch, or * for all namespaces (default: the namespace of the nomad client, usually default)",
+	EnvVar: "NOMAD_FIREHOSE_NAMESPACES",
+}
+
+// namespaceList returns the namespaces to watch, an empty namespace is the nomad client default
+func namespaceList(c *cli.Context) []string {
+	var result []string
+	for _, namespace := range strings.Split(c.String("namespace"), ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			result = append(result, namespace)
+		}
+	}
+
+	if len(result) == 0 {
+		return []string{""}
+	}
+
+	return result
+}
+
+// runFirehose start a firehose, or one firehose per region when --regions is used
+func runFirehose(c *cli.Context, newFirehose func(region string) (helper.Runner, error)) error {
+	// exposed to the sink templates
+	os.Setenv("SINK_FIREHOSE", c.Command.Name)
+
+	if c.GlobalBool("envelope") {
+		os.Setenv("SINK_ENVELOPE", "true")
+	}
+
+	// passed to the firehoses publishing with a pool
+	if c.GlobalInt("publish-workers") < 1 {
+		return fmt.Errorf("Invalid --publish-workers value, must be positive")
+	}
+
+	// nothing is sent anywhere
+	dryRun := c.GlobalBool("dry-run")
+	if dryRun {
+		os.Setenv("SINK_TYPE", "null")
+		os.Setenv("SINK_OVERSIZED_PAYLOADS", "truncate")
+		os.Unsetenv("SINK_DEAD_LETTER_TYPE")
+	}
+
+	switch encoding := c.GlobalString("encoding"); encoding {
+	case "json", "avro", "msgpack", "protobuf":
+		os.Setenv("SINK_ENCODING", encoding)
+	default:
+		return fmt.Errorf("Invalid --encoding value, must be one of: json, avro, msgpack, protobuf")
+	}
+
+	regions, err := helper.Regions(c.GlobalString("regions"))
+	if err != nil {
+		return err
+	}
+
+	if len(regions) == 0 {
+		firehose, err := newFirehose("")
+		if err != nil {
+			return err
+		}
+
+		manager := helper.NewManager(firehose)
+		manager.SetDryRun(dryRun)
+		if err := manager.Start(); err != nil {
+			log.Fatal(err)
+			return err
+		}
+
+		return nil
+	}
+
+	if err := sink.ValidateRegions(); err != nil {
+		return err
+	}
+
+	managers := make([]*helper.Manager, 0, len(regions))
+	for _, region := range regions {
+		firehose, err := newFirehose(region)
+		if err != nil {
+			return err
+		}
+
+		manager := helper.NewManager(helper.NewRegionRunner(firehose, region))
+		manager.SetDryRun(dryRun)
+		managers = append(managers, manager)
+	}
+
+	errCh := make(chan error, len(managers))
+	for _, manager := range managers {
+		go func(manager *helper.Manager) {
+			errCh <- manager.Start()
+		}(manager)
+	}
+
+	for range managers {
+		if err := <-errCh; err != nil {
+			log.Fatal(err)
+			return err
+		}
+	}
+
+	return nil
+}
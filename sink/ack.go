@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Acknowledger sinks tell when the events put so far are delivered, so the firehoses only
+// persist the index of events which can't be lost anymore
+type Acknowledger interface {
+	// WaitForAcks block until the events put so far are delivered or dead-lettered, and tell if
+	// no event was lost since the last wait
+	WaitForAcks() bool
+}
+
+// Acknowledged wait for the events put in s so far to be acknowledged, when the sink supports it,
+// and tell if the firehose can persist its index
+func Acknowledged(s Sink) bool {
+	a, ok := s.(Acknowledger)
+	if !ok {
+		return true
+	}
+	return a.WaitForAcks()
+}
+
+// ackTracker count the events put in a sink and the events delivered or given up on, as sequence
+// numbers, so waiting for the events put so far isn't held back by the events put meanwhile
+type ackTracker struct {
+	lock  sync.Mutex
+	cond  *sync.Cond
+	put   uint64
+	acked uint64
+	// events lost since the last wait
+	lost int
+	// acknowledgements of an event, one per sink of a fan-out
+	perEvent int
+}
+
+// acknowledging sinks acknowledge the events they delivered or gave up on, instead of the events
+// being acknowledged once handed to them
+type acknowledging interface {
+	setAckTracker(acks *ackTracker)
+}
+
+// newAckTracker ...
+func newAckTracker() *ackTracker {
+	t := &ackTracker{perEvent: 1}
+	t.cond = sync.NewCond(&t.lock)
+	return t
+}
+
+// add n events to acknowledge, once by every sink of a fan-out
+func (t *ackTracker) add(n int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.put += uint64(n * t.perEvent)
+}
+
+// forEvents is the number of acknowledgements of n events, for the sinks in front of a fan-out
+// which give up on whole events
+func (t *ackTracker) forEvents(n int) int {
+	if t == nil {
+		return n
+	}
+	return n * t.perEvent
+}
+
+// Ack n delivered events, a nil tracker ignores them
+func (t *ackTracker) Ack(n int) {
+	t.done(n, false)
+}
+
+// Lose n events which couldn't be delivered, a nil tracker ignores them
+func (t *ackTracker) Lose(n int) {
+	t.done(n, true)
+}
+
+func (t *ackTracker) done(n int, lost bool) {
+	if t == nil || n == 0 {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if lost {
+		t.lost += n
+	}
+
+	t.acked += uint64(n)
+	t.cond.Broadcast()
+}
+
+// wait until as many events as were put when called are done, and tell if none was lost since
+// the last wait. The loss is reported once, the next waits persisting the index again
+func (t *ackTracker) wait() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	mark := t.put
+	for t.acked < mark {
+		t.cond.Wait()
+	}
+
+	if t.lost > 0 {
+		log.Errorf("[sink/ack] %d events lost, skipping the firehose index persisted meanwhile", t.lost)
+		t.lost = 0
+		return false
+	}
+
+	return true
+}
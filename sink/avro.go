@@ -0,0 +1,379 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// avroSchema encode JSON events as Avro binary, with the schema of the firehose from
+// <dir>/<firehose>.avsc, or the built-in generic schema wrapping the JSON event
+type avroSchema struct {
+	text     string
+	codec    *goavro.Codec
+	generic  bool
+	schema   interface{}
+	named    map[string]interface{}
+	firehose string
+}
+
+// newAvroSchema load the schema of the firehose, dir may be empty to always use the generic schema
+func newAvroSchema(dir, firehose string) (*avroSchema, error) {
+	s := &avroSchema{
+		firehose: firehose,
+		named:    make(map[string]interface{}),
+	}
+
+	path := "generic"
+	s.text = avroGenericSchema(firehose)
+	s.generic = true
+
+	if dir != "" {
+		b, err := ioutil.ReadFile(filepath.Join(dir, firehose+".avsc"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if err == nil {
+			path = filepath.Join(dir, firehose+".avsc")
+			s.text = string(b)
+			s.generic = false
+		}
+	}
+
+	if err := json.Unmarshal([]byte(s.text), &s.schema); err != nil {
+		return nil, fmt.Errorf("Invalid Avro schema %s: %s", path, err)
+	}
+	s.register(s.schema, "")
+
+	codec, err := goavro.NewCodec(s.text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Avro schema %s: %s", path, err)
+	}
+	s.codec = codec
+
+	return s, nil
+}
+
+// register the named types (records, enums, fixed) of a schema, so references to them can be resolved
+func (s *avroSchema) register(schema interface{}, namespace string) {
+	switch t := schema.(type) {
+	case []interface{}:
+		for _, branch := range t {
+			s.register(branch, namespace)
+		}
+
+	case map[string]interface{}:
+		if _, ok := t["name"].(string); ok {
+			name := avroTypeName(t, namespace)
+			s.named[name] = t
+			if i := strings.LastIndex(name, "."); i >= 0 {
+				namespace = name[:i]
+			}
+		}
+
+		if fields, ok := t["fields"].([]interface{}); ok {
+			for _, f := range fields {
+				if field, ok := f.(map[string]interface{}); ok {
+					s.register(field["type"], namespace)
+				}
+			}
+		}
+
+		s.register(t["items"], namespace)
+		s.register(t["values"], namespace)
+	}
+}
+
+// avroGenericSchema is a record per firehose with the common fields of the events, and the
+// event itself as a JSON string
+func avroGenericSchema(firehose string) string {
+	name := ""
+	for _, part := range strings.FieldsFunc(firehose, func(r rune) bool { return r == '-' || r == '_' }) {
+		name += strings.ToUpper(part[:1]) + part[1:]
+	}
+
+	return `{
+	"type": "record",
+	"name": "` + name + `Event",
+	"namespace": "nomad.firehose",
+	"fields": [
+		{"name": "firehose", "type": "string"},
+		{"name": "region", "type": "string", "default": ""},
+		{"name": "id", "type": "string", "default": ""},
+		{"name": "namespace", "type": "string", "default": ""},
+		{"name": "modify_index", "type": "long", "default": 0},
+		{"name": "created_at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "payload", "type": "string", "doc": "the JSON encoded event"}
+	]
+}`
+}
+
+// Schema return the canonical form of the schema, as registered in a schema registry
+func (s *avroSchema) Schema() string {
+	return s.codec.CanonicalSchema()
+}
+
+// Encode a JSON event as Avro binary
+func (s *avroSchema) Encode(data []byte) ([]byte, error) {
+	native, err := s.Native(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.codec.BinaryFromNative(nil, native)
+}
+
+// EncodeSingle encode a JSON event with the Avro single object encoding, the binary event
+// prefixed by the fingerprint of its schema, for consumers with the schema at hand
+func (s *avroSchema) EncodeSingle(data []byte) ([]byte, error) {
+	native, err := s.Native(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.codec.SingleFromNative(nil, native)
+}
+
+// Container encode goavro native events as an Avro object container file, which embeds the
+// schema, compressed with codec (null, deflate or snappy)
+func (s *avroSchema) Container(natives []interface{}, codec string) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:               &buf,
+		Codec:           s.codec,
+		CompressionName: codec,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.Append(natives); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Native convert a JSON event to the goavro native value of the schema
+func (s *avroSchema) Native(data []byte) (interface{}, error) {
+	if s.generic {
+		fields := extractEventFields(data)
+
+		return map[string]interface{}{
+			"firehose":     s.firehose,
+			"region":       fields.Region,
+			"id":           fields.ID,
+			"namespace":    fields.Namespace,
+			"modify_index": int64(fields.ModifyIndex),
+			"created_at":   time.Now().UTC(),
+			"payload":      string(data),
+		}, nil
+	}
+
+	var event interface{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+
+	return s.native(s.schema, "", event)
+}
+
+// native convert a decoded JSON value to the goavro native value of the schema, so plain JSON
+// events can be encoded with any schema (unions are resolved from the value, missing record
+// fields use their default)
+func (s *avroSchema) native(schema interface{}, namespace string, value interface{}) (interface{}, error) {
+	switch t := schema.(type) {
+	case string:
+		// reference to a named type
+		if named, ok := s.named[t]; ok {
+			return s.native(named, namespace, value)
+		}
+		if named, ok := s.named[namespace+"."+t]; ok {
+			return s.native(named, namespace, value)
+		}
+		return avroPrimitive(t, value)
+
+	case []interface{}:
+		if value == nil {
+			return nil, nil
+		}
+
+		var lastErr error
+		for _, branch := range t {
+			if branch == "null" {
+				continue
+			}
+
+			native, err := s.native(branch, namespace, value)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			return goavro.Union(avroTypeName(branch, namespace), native), nil
+		}
+		return nil, fmt.Errorf("No union branch matches %v: %v", value, lastErr)
+
+	case map[string]interface{}:
+		typeName, _ := t["type"].(string)
+
+		// names in a named type are relative to its namespace
+		if _, ok := t["name"].(string); ok {
+			if name := avroTypeName(t, namespace); strings.Contains(name, ".") {
+				namespace = name[:strings.LastIndex(name, ".")]
+			}
+		}
+
+		switch typeName {
+		case "record":
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Expected an object, got %T", value)
+			}
+
+			fields, _ := t["fields"].([]interface{})
+			record := make(map[string]interface{}, len(fields))
+
+			for _, f := range fields {
+				field, _ := f.(map[string]interface{})
+				name, _ := field["name"].(string)
+
+				v, ok := object[name]
+				if !ok {
+					// goavro uses the default of the field
+					continue
+				}
+
+				native, err := s.native(field["type"], namespace, v)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s", name, err)
+				}
+				record[name] = native
+			}
+			return record, nil
+
+		case "array":
+			items, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Expected an array, got %T", value)
+			}
+
+			result := make([]interface{}, 0, len(items))
+			for _, item := range items {
+				native, err := s.native(t["items"], namespace, item)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, native)
+			}
+			return result, nil
+
+		case "map":
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Expected an object, got %T", value)
+			}
+
+			result := make(map[string]interface{}, len(object))
+			for key, item := range object {
+				native, err := s.native(t["values"], namespace, item)
+				if err != nil {
+					return nil, err
+				}
+				result[key] = native
+			}
+			return result, nil
+
+		case "enum", "fixed":
+			return value, nil
+		}
+
+		// primitive with a logical type, example: {"type": "long", "logicalType": "timestamp-millis"}
+		if logicalType, _ := t["logicalType"].(string); strings.HasPrefix(logicalType, "timestamp-") {
+			if str, ok := value.(string); ok {
+				return time.Parse(time.RFC3339Nano, str)
+			}
+		}
+		return s.native(t["type"], namespace, value)
+	}
+
+	return nil, fmt.Errorf("Unsupported schema %v", schema)
+}
+
+// avroTypeName of a union branch, as expected by goavro.Union
+func avroTypeName(schema interface{}, namespace string) string {
+	switch t := schema.(type) {
+	case string:
+		return t
+	case map[string]interface{}:
+		if name, ok := t["name"].(string); ok {
+			if ns, ok := t["namespace"].(string); ok {
+				namespace = ns
+			}
+			if !strings.Contains(name, ".") && namespace != "" {
+				return namespace + "." + name
+			}
+			return name
+		}
+		if logicalType, ok := t["logicalType"].(string); ok {
+			return fmt.Sprintf("%v.%s", t["type"], logicalType)
+		}
+		typeName, _ := t["type"].(string)
+		return typeName
+	}
+	return ""
+}
+
+// avroPrimitive convert a decoded JSON value to a primitive Avro type
+func avroPrimitive(typeName string, value interface{}) (interface{}, error) {
+	switch typeName {
+	case "null":
+		if value != nil {
+			return nil, fmt.Errorf("Expected null, got %T", value)
+		}
+		return nil, nil
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return nil, fmt.Errorf("Expected a boolean, got %T", value)
+		}
+		return value, nil
+	case "int", "long":
+		n, ok := value.(float64)
+		if !ok || n != float64(int64(n)) {
+			return nil, fmt.Errorf("Expected an integer, got %v", value)
+		}
+		if typeName == "int" {
+			return int32(n), nil
+		}
+		return int64(n), nil
+	case "float", "double":
+		n, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("Expected a number, got %T", value)
+		}
+		if typeName == "float" {
+			return float32(n), nil
+		}
+		return n, nil
+	case "string":
+		if _, ok := value.(string); !ok {
+			return nil, fmt.Errorf("Expected a string, got %T", value)
+		}
+		return value, nil
+	case "bytes":
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("Expected a string, got %T", value)
+		}
+		return []byte(str), nil
+	}
+
+	return nil, fmt.Errorf("Unknown type %s", typeName)
+}
@@ -0,0 +1,142 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/appendblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	log "github.com/sirupsen/logrus"
+)
+
+// maximum size of a single append block
+const azblobMaxAppendBlock = 4 * 1024 * 1024
+
+// AzureBlobSink ...
+type AzureBlobSink struct {
+	*objectBatcher
+
+	container *container.Client
+	blobType  string
+}
+
+// NewAzureBlob ...
+func NewAzureBlob() (*AzureBlobSink, error) {
+	containerURL := os.Getenv("SINK_AZBLOB_CONTAINER_URL")
+	if containerURL == "" {
+		return nil, fmt.Errorf("[sink/azblob] Missing SINK_AZBLOB_CONTAINER_URL (example: https://account.blob.core.windows.net/nomad-archive)")
+	}
+
+	var client *container.Client
+	var err error
+
+	// a SAS token in the container url, or in SINK_AZBLOB_SAS_TOKEN, otherwise use the
+	// default Azure credential chain (environment, workload or managed identity)
+	if sas := os.Getenv("SINK_AZBLOB_SAS_TOKEN"); sas != "" {
+		containerURL = containerURL + "?" + strings.TrimPrefix(sas, "?")
+	}
+
+	if strings.Contains(containerURL, "sig=") {
+		log.Infof("[sink/azblob] SINK_AZBLOB_CONTAINER_URL=%s (SAS token)", containerURL[:strings.Index(containerURL, "?")])
+		client, err = container.NewClientWithNoCredential(containerURL, nil)
+	} else {
+		log.Infof("[sink/azblob] SINK_AZBLOB_CONTAINER_URL=%s", containerURL)
+
+		credential, credErr := azidentity.NewDefaultAzureCredential(nil)
+		if credErr != nil {
+			return nil, fmt.Errorf("[sink/azblob] Failed to load Azure credentials: %s", credErr)
+		}
+		client, err = container.NewClient(containerURL, credential, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[sink/azblob] Failed to create container client: %s", err)
+	}
+
+	blobType := os.Getenv("SINK_AZBLOB_BLOB_TYPE")
+	switch blobType {
+	case "":
+		blobType = "block"
+	case "block", "append":
+	default:
+		return nil, fmt.Errorf("[sink/azblob] Invalid SINK_AZBLOB_BLOB_TYPE: %s, Valid values: block or append", blobType)
+	}
+
+	s := &AzureBlobSink{
+		container: client,
+		blobType:  blobType,
+	}
+
+	batcher, err := newObjectBatcher("azblob", "AZBLOB", s.upload)
+	if err != nil {
+		return nil, err
+	}
+	// an avro container file can't be appended to with another one
+	if blobType == "append" && batcher.avro != nil {
+		return nil, fmt.Errorf("[sink/azblob] SINK_AZBLOB_BLOB_TYPE=append can't be used with SINK_AZBLOB_ENCODING=avro")
+	}
+	batcher.appending = blobType == "append"
+	s.objectBatcher = batcher
+
+	return s, nil
+}
+
+func (s *AzureBlobSink) upload(key string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	contentType := s.contentType()
+	headers := &blob.HTTPHeaders{
+		BlobContentType: &contentType,
+	}
+	if contentEncoding := s.contentEncoding(); contentEncoding != "" {
+		headers.BlobContentEncoding = &contentEncoding
+	}
+
+	if s.blobType == "block" {
+		_, err := s.container.NewBlockBlobClient(key).UploadBuffer(ctx, body, &blockblob.UploadBufferOptions{HTTPHeaders: headers})
+		return azureRetryable(err)
+	}
+
+	// every flush appends a gzip member to the hourly blob, which is still a valid gzip stream
+	client := s.container.NewAppendBlobClient(key)
+
+	_, err := client.Create(ctx, &appendblob.CreateOptions{HTTPHeaders: headers})
+	if err != nil && !bloberror.HasCode(err, bloberror.BlobAlreadyExists) {
+		return azureRetryable(err)
+	}
+
+	for len(body) > 0 {
+		n := len(body)
+		if n > azblobMaxAppendBlock {
+			n = azblobMaxAppendBlock
+		}
+
+		if _, err := client.AppendBlock(ctx, streaming.NopCloser(bytes.NewReader(body[:n])), nil); err != nil {
+			return azureRetryable(err)
+		}
+		body = body[n:]
+	}
+
+	return nil
+}
+
+// azureRetryable mark the errors of the responses with a status uploading again won't fix as
+// permanent, like httpRetryable
+func azureRetryable(err error) error {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return httpRetryable(respErr.StatusCode, err)
+	}
+	return err
+}
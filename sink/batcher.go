@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"fmt"
+	"time"
+)
+
+// eventBatcher group the events of a sink in batches of at most maxSize events and maxBytes
+// bytes, handed to the sink when full or every flushInterval, for sinks with a batch API
+type eventBatcher struct {
+	maxSize       int
+	maxBytes      int
+	flushInterval time.Duration
+}
+
+// newEventBatcher read SINK_<env>_BATCH_SIZE (default: defaultSize, at most limitSize),
+// SINK_<env>_BATCH_BYTES (default and at most limitBytes, 0 being unlimited) and
+// SINK_<env>_FLUSH_INTERVAL (default: 1s)
+func newEventBatcher(env string, defaultSize, limitSize, limitBytes int) (*eventBatcher, error) {
+	maxSize, err := getenvInt("SINK_"+env+"_BATCH_SIZE", defaultSize)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize < 1 {
+		return nil, fmt.Errorf("Invalid SINK_%s_BATCH_SIZE value, must be positive", env)
+	}
+	if limitSize > 0 && maxSize > limitSize {
+		return nil, fmt.Errorf("Invalid SINK_%s_BATCH_SIZE value, must be at most %d", env, limitSize)
+	}
+
+	maxBytes, err := getenvInt("SINK_"+env+"_BATCH_BYTES", limitBytes)
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes < 0 || (limitBytes > 0 && maxBytes == 0) {
+		return nil, fmt.Errorf("Invalid SINK_%s_BATCH_BYTES value, must be positive", env)
+	}
+	if limitBytes > 0 && maxBytes > limitBytes {
+		return nil, fmt.Errorf("Invalid SINK_%s_BATCH_BYTES value, must be at most %d", env, limitBytes)
+	}
+
+	flushInterval, err := getenvDuration("SINK_"+env+"_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if flushInterval <= 0 {
+		return nil, fmt.Errorf("Invalid SINK_%s_FLUSH_INTERVAL value, must be positive", env)
+	}
+
+	return &eventBatcher{
+		maxSize:       maxSize,
+		maxBytes:      maxBytes,
+		flushInterval: flushInterval,
+	}, nil
+}
+
+// Run batch the events read from putCh and hand the batches to flush, until stopCh is closed,
+// flushing the last partial batch before returning. An event larger than maxBytes is a batch
+// on its own
+func (b *eventBatcher) Run(putCh <-chan []byte, stopCh <-chan interface{}, flush func(batch [][]byte)) {
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([][]byte, 0, b.maxSize)
+	size := 0
+
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		flush(batch)
+		batch = make([][]byte, 0, b.maxSize)
+		size = 0
+	}
+
+	for {
+		select {
+		case <-stopCh:
+			send()
+			return
+
+		case <-ticker.C:
+			send()
+
+		case data := <-putCh:
+			if b.maxBytes > 0 && size+len(data) > b.maxBytes {
+				send()
+			}
+
+			batch = append(batch, data)
+			size += len(data)
+
+			if len(batch) >= b.maxSize || (b.maxBytes > 0 && size >= b.maxBytes) {
+				send()
+			}
+		}
+	}
+}
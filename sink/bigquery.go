@@ -0,0 +1,303 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// bigqueryTableSchema is the schema of the table created for each firehose type
+var bigqueryTableSchema = bigquery.Schema{
+	{Name: "firehose", Type: bigquery.StringFieldType, Required: true},
+	{Name: "event_id", Type: bigquery.StringFieldType},
+	{Name: "namespace", Type: bigquery.StringFieldType},
+	{Name: "modify_index", Type: bigquery.IntegerFieldType},
+	{Name: "created_at", Type: bigquery.TimestampFieldType, Required: true},
+	{Name: "payload", Type: bigquery.JSONFieldType, Required: true},
+}
+
+// BigQuerySink stream events into a BigQuery table with the Storage Write API
+type BigQuerySink struct {
+	client        *bigquery.Client
+	writer        *managedwriter.Client
+	stream        *managedwriter.ManagedStream
+	descriptor    protoreflect.MessageDescriptor
+	table         string
+	firehose      string
+	batchSize     int
+	flushInterval time.Duration
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+// NewBigQuery ...
+func NewBigQuery() (*BigQuerySink, error) {
+	project := os.Getenv("SINK_BIGQUERY_PROJECT")
+	if project == "" {
+		return nil, fmt.Errorf("[sink/bigquery] Missing SINK_BIGQUERY_PROJECT (example: my-gcp-project)")
+	}
+
+	dataset := os.Getenv("SINK_BIGQUERY_DATASET")
+	if dataset == "" {
+		return nil, fmt.Errorf("[sink/bigquery] Missing SINK_BIGQUERY_DATASET (example: nomad)")
+	}
+
+	firehose := os.Getenv("SINK_FIREHOSE")
+
+	// a table per firehose type by default
+	table := os.Getenv("SINK_BIGQUERY_TABLE")
+	if table == "" {
+		table = "nomad_firehose_" + strings.Replace(firehose, "-", "_", -1)
+	}
+	log.Infof("[sink/bigquery] Writing to %s.%s.%s", project, dataset, table)
+
+	batchSize, err := getenvInt("SINK_BIGQUERY_BATCH_SIZE", 500)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/bigquery] %s", err)
+	}
+
+	flushInterval, err := getenvDuration("SINK_BIGQUERY_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/bigquery] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/bigquery] %s", err)
+	}
+
+	ctx := context.Background()
+
+	// credentials are resolved through Application Default Credentials
+	client, err := bigquery.NewClient(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/bigquery] Failed to create BigQuery client: %s", err)
+	}
+
+	// create the table, partitioned by day, unless it exists
+	err = client.Dataset(dataset).Table(table).Create(ctx, &bigquery.TableMetadata{
+		Schema:           bigqueryTableSchema,
+		TimePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "created_at"},
+		Clustering:       &bigquery.Clustering{Fields: []string{"namespace", "event_id"}},
+	})
+	if apiErr, ok := err.(*googleapi.Error); err != nil && !(ok && apiErr.Code == http.StatusConflict) {
+		return nil, fmt.Errorf("[sink/bigquery] Failed to create table %s: %s", table, err)
+	}
+
+	descriptor, normalized, err := bigqueryDescriptor()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/bigquery] %s", err)
+	}
+
+	writer, err := managedwriter.NewClient(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/bigquery] Failed to create Storage Write client: %s", err)
+	}
+
+	// the default stream commits rows as soon as they are appended
+	stream, err := writer.NewManagedStream(ctx,
+		managedwriter.WithDestinationTable(managedwriter.TableParentFromParts(project, dataset, table)),
+		managedwriter.WithType(managedwriter.DefaultStream),
+		managedwriter.WithSchemaDescriptor(normalized),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/bigquery] Failed to open write stream: %s", err)
+	}
+
+	return &BigQuerySink{
+		client:        client,
+		writer:        writer,
+		stream:        stream,
+		descriptor:    descriptor,
+		table:         table,
+		firehose:      firehose,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}, nil
+}
+
+// bigqueryDescriptor build the protobuf descriptor of a table row from the table schema
+func bigqueryDescriptor() (protoreflect.MessageDescriptor, *descriptorpb.DescriptorProto, error) {
+	tableSchema, err := adapt.BQSchemaToStorageTableSchema(bigqueryTableSchema)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d, err := adapt.StorageSchemaToProto2Descriptor(tableSchema, "root")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	descriptor, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, nil, fmt.Errorf("Unexpected descriptor type %T", d)
+	}
+
+	normalized, err := adapt.NormalizeDescriptor(descriptor)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return descriptor, normalized, nil
+}
+
+// Start ...
+func (s *BigQuerySink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *BigQuerySink) Stop() {
+	log.Infof("[sink/bigquery] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/bigquery] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer appends the last partial batch when stopping
+	close(s.stopCh)
+	<-s.doneCh
+
+	s.stream.Close()
+	s.writer.Close()
+	s.client.Close()
+}
+
+// setDeadLetterQueue ...
+func (s *BigQuerySink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *BigQuerySink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *BigQuerySink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *BigQuerySink) write() {
+	log.Infof("[sink/bigquery] Starting writer to table '%s'", s.table)
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	// the events of the rows, to dead-letter them
+	batch := make([][]byte, 0, s.batchSize)
+	events := make([][]byte, 0, s.batchSize)
+
+	for {
+		select {
+		case <-s.stopCh:
+			s.append(batch, events)
+			return
+
+		case <-ticker.C:
+			s.append(batch, events)
+			batch = make([][]byte, 0, s.batchSize)
+			events = make([][]byte, 0, s.batchSize)
+
+		case data := <-s.putCh:
+			row, err := s.row(data)
+			if err != nil {
+				log.Errorf("[sink/bigquery] Could not encode row: %s", err)
+				s.retry.undeliverable("bigquery", data, err)
+				continue
+			}
+
+			batch = append(batch, row)
+			events = append(events, data)
+
+			if len(batch) >= s.batchSize {
+				s.append(batch, events)
+				batch = make([][]byte, 0, s.batchSize)
+				events = make([][]byte, 0, s.batchSize)
+			}
+		}
+	}
+}
+
+// row encode an event as a serialized table row
+func (s *BigQuerySink) row(data []byte) ([]byte, error) {
+	fields := extractEventFields(data)
+	message := dynamicpb.NewMessage(s.descriptor)
+	columns := s.descriptor.Fields()
+
+	message.Set(columns.ByName("firehose"), protoreflect.ValueOfString(s.firehose))
+	message.Set(columns.ByName("event_id"), protoreflect.ValueOfString(fields.ID))
+	message.Set(columns.ByName("namespace"), protoreflect.ValueOfString(fields.Namespace))
+	message.Set(columns.ByName("modify_index"), protoreflect.ValueOfInt64(int64(fields.ModifyIndex)))
+	message.Set(columns.ByName("created_at"), protoreflect.ValueOfInt64(time.Now().UnixNano()/int64(time.Microsecond)))
+	message.Set(columns.ByName("payload"), protoreflect.ValueOfString(string(data)))
+
+	return proto.Marshal(message)
+}
+
+// append a batch of rows to the stream and wait for the result
+func (s *BigQuerySink) append(batch, events [][]byte) {
+	if len(batch) == 0 {
+		return
+	}
+
+	err := s.retry.Do("bigquery", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		result, err := s.stream.AppendRows(ctx, batch)
+		if err != nil {
+			return grpcRetryable(err)
+		}
+
+		_, err = result.GetResult(ctx)
+		return grpcRetryable(err)
+	})
+	if err != nil {
+		log.Errorf("[sink/bigquery] Failed to append %d rows: %s", len(batch), err)
+		for _, data := range events {
+			s.retry.undeliverable("bigquery", data, err)
+		}
+		return
+	}
+
+	log.Debugf("[sink/bigquery] Appended %d rows", len(batch))
+	s.retry.delivered(len(batch))
+}
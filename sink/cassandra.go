@@ -0,0 +1,274 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	log "github.com/sirupsen/logrus"
+)
+
+// CassandraSink insert events into a Cassandra / ScyllaDB table with batched prepared
+// statements, partitioned by firehose and day
+type CassandraSink struct {
+	session       *gocql.Session
+	table         string
+	firehose      string
+	insert        string
+	ttl           int
+	batchSize     int
+	flushInterval time.Duration
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+const cassandraSchema = `CREATE TABLE IF NOT EXISTS %s (
+	firehose text,
+	day date,
+	id timeuuid,
+	event_id text,
+	namespace text,
+	modify_index bigint,
+	payload text,
+	PRIMARY KEY ((firehose, day), id)
+) WITH CLUSTERING ORDER BY (id DESC)`
+
+// NewCassandra ...
+func NewCassandra() (*CassandraSink, error) {
+	hosts := os.Getenv("SINK_CASSANDRA_HOSTS")
+	if hosts == "" {
+		return nil, fmt.Errorf("[sink/cassandra] Missing SINK_CASSANDRA_HOSTS (example: 10.0.0.1,10.0.0.2:9042)")
+	}
+
+	keyspace := os.Getenv("SINK_CASSANDRA_KEYSPACE")
+	if keyspace == "" {
+		return nil, fmt.Errorf("[sink/cassandra] Missing SINK_CASSANDRA_KEYSPACE (example: nomad)")
+	}
+
+	table := os.Getenv("SINK_CASSANDRA_TABLE")
+	if table == "" {
+		table = "nomad_firehose_events"
+	}
+	if !sqlTableName.MatchString(table) {
+		return nil, fmt.Errorf("[sink/cassandra] Invalid SINK_CASSANDRA_TABLE: %s", table)
+	}
+	log.Infof("[sink/cassandra] SINK_CASSANDRA_KEYSPACE=%s SINK_CASSANDRA_TABLE=%s", keyspace, table)
+
+	ttl, err := getenvDuration("SINK_CASSANDRA_TTL", 0)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/cassandra] %s", err)
+	}
+
+	batchSize, err := getenvInt("SINK_CASSANDRA_BATCH_SIZE", 50)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/cassandra] %s", err)
+	}
+	if batchSize < 1 {
+		return nil, fmt.Errorf("[sink/cassandra] Invalid SINK_CASSANDRA_BATCH_SIZE value, must be positive")
+	}
+
+	flushInterval, err := getenvDuration("SINK_CASSANDRA_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/cassandra] %s", err)
+	}
+
+	createTable, err := getenvBool("SINK_CASSANDRA_CREATE_TABLE", true)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/cassandra] %s", err)
+	}
+
+	cluster := gocql.NewCluster(strings.Split(hosts, ",")...)
+	cluster.Keyspace = keyspace
+	cluster.Consistency = gocql.LocalQuorum
+
+	if consistency := os.Getenv("SINK_CASSANDRA_CONSISTENCY"); consistency != "" {
+		if err := cluster.Consistency.UnmarshalText([]byte(strings.ToUpper(consistency))); err != nil {
+			return nil, fmt.Errorf("[sink/cassandra] Invalid SINK_CASSANDRA_CONSISTENCY: %s", err)
+		}
+	}
+
+	if username := os.Getenv("SINK_CASSANDRA_USERNAME"); username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: username,
+			Password: os.Getenv("SINK_CASSANDRA_PASSWORD"),
+		}
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/cassandra] %s", err)
+	}
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/cassandra] Failed to connect: %s", err)
+	}
+
+	if createTable {
+		if err := session.Query(fmt.Sprintf(cassandraSchema, table)).Exec(); err != nil {
+			session.Close()
+			return nil, fmt.Errorf("[sink/cassandra] Failed to create table %s: %s", table, err)
+		}
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %s (firehose, day, id, event_id, namespace, modify_index, payload) VALUES (?, ?, ?, ?, ?, ?, ?)", table)
+	if ttl > 0 {
+		insert += " USING TTL ?"
+	}
+
+	return &CassandraSink{
+		session:       session,
+		table:         table,
+		firehose:      os.Getenv("SINK_FIREHOSE"),
+		insert:        insert,
+		ttl:           int(ttl.Seconds()),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *CassandraSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *CassandraSink) Stop() {
+	log.Infof("[sink/cassandra] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/cassandra] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer inserts the last partial batch when stopping
+	close(s.stopCh)
+	<-s.doneCh
+
+	s.session.Close()
+}
+
+// setDeadLetterQueue ...
+func (s *CassandraSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *CassandraSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *CassandraSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *CassandraSink) write() {
+	log.Infof("[sink/cassandra] Starting writer to table '%s'", s.table)
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]sqlRow, 0, s.batchSize)
+
+	for {
+		select {
+		case <-s.stopCh:
+			s.flush(batch)
+			return
+
+		case <-ticker.C:
+			s.flush(batch)
+			batch = batch[:0]
+
+		case data := <-s.putCh:
+			batch = append(batch, sqlRow{
+				fields:    extractEventFields(data),
+				createdAt: time.Now().UTC(),
+				payload:   data,
+			})
+
+			if len(batch) >= s.batchSize {
+				s.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// flush insert a batch of rows as an unlogged batch, rows of a batch almost always share
+// the same (firehose, day) partition
+func (s *CassandraSink) flush(batch []sqlRow) {
+	if len(batch) == 0 {
+		return
+	}
+
+	b := s.session.NewBatch(gocql.UnloggedBatch)
+
+	for _, row := range batch {
+		args := []interface{}{
+			s.firehose,
+			row.createdAt.Truncate(24 * time.Hour),
+			gocql.UUIDFromTime(row.createdAt),
+			row.fields.ID,
+			row.fields.Namespace,
+			int64(row.fields.ModifyIndex),
+			string(row.payload),
+		}
+		if s.ttl > 0 {
+			args = append(args, s.ttl)
+		}
+
+		b.Query(s.insert, args...)
+	}
+
+	err := s.retry.Do("cassandra", func() error {
+		return cassandraRetryable(s.session.ExecuteBatch(b))
+	})
+	if err != nil {
+		log.Errorf("[sink/cassandra] Failed to insert %d events: %s", len(batch), err)
+		for _, row := range batch {
+			s.retry.undeliverable("cassandra", row.payload, err)
+		}
+		return
+	}
+
+	log.Debugf("[sink/cassandra] Inserted %d events", len(batch))
+	s.retry.delivered(len(batch))
+}
+
+// cassandraRetryable mark the errors of invalid queries and missing permissions as permanent
+func cassandraRetryable(err error) error {
+	if reqErr, ok := err.(gocql.RequestError); ok {
+		switch reqErr.Code() {
+		case gocql.ErrCodeSyntax, gocql.ErrCodeUnauthorized, gocql.ErrCodeInvalid, gocql.ErrCodeConfig:
+			return permanent(err)
+		}
+	}
+	return err
+}
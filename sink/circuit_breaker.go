@@ -0,0 +1,153 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// circuit breaker states
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// errCircuitOpen is returned instead of publishing while the circuit is open and the events spill
+var errCircuitOpen = permanent(fmt.Errorf("circuit breaker is open"))
+
+// circuitBreaker stop publishing to a destination after SINK_CIRCUIT_BREAKER_FAILURES consecutive
+// failed attempts, and probe it every SINK_CIRCUIT_BREAKER_PROBE_INTERVAL with a single attempt
+// until one succeeds. While the circuit is open, SINK_CIRCUIT_BREAKER_POLICY either holds back the
+// writers, so the events are buffered in the queue (buffer), or sends the events to the
+// dead-letter sink, a file sink spilling them to disk for example (spill)
+type circuitBreaker struct {
+	failures      int
+	probeInterval time.Duration
+	spill         bool
+
+	lock     sync.Mutex
+	cond     *sync.Cond
+	state    int
+	failed   int
+	probeAt  time.Time
+	openings uint64
+}
+
+// newCircuitBreaker return the circuit breaker of a sink, nil when SINK_CIRCUIT_BREAKER_FAILURES
+// isn't set
+func newCircuitBreaker() (*circuitBreaker, error) {
+	failures, err := getenvInt("SINK_CIRCUIT_BREAKER_FAILURES", 0)
+	if err != nil {
+		return nil, err
+	}
+	if failures <= 0 {
+		return nil, nil
+	}
+
+	probeInterval, err := getenvDuration("SINK_CIRCUIT_BREAKER_PROBE_INTERVAL", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	var spill bool
+	switch policy := os.Getenv("SINK_CIRCUIT_BREAKER_POLICY"); policy {
+	case "", "buffer":
+	case "spill":
+		if os.Getenv("SINK_DEAD_LETTER_TYPE") == "" {
+			return nil, fmt.Errorf("SINK_CIRCUIT_BREAKER_POLICY=spill requires a dead-letter sink (SINK_DEAD_LETTER_TYPE)")
+		}
+		spill = true
+	default:
+		return nil, fmt.Errorf("Invalid SINK_CIRCUIT_BREAKER_POLICY value, must be one of: buffer, spill")
+	}
+
+	b := &circuitBreaker{
+		failures:      failures,
+		probeInterval: probeInterval,
+		spill:         spill,
+	}
+	b.cond = sync.NewCond(&b.lock)
+
+	return b, nil
+}
+
+// allow an attempt, waiting for the circuit to close or to be probed when buffering
+func (b *circuitBreaker) allow(name string) error {
+	if b == nil {
+		return nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for {
+		switch b.state {
+		case circuitClosed:
+			return nil
+
+		case circuitOpen:
+			wait := time.Until(b.probeAt)
+			if wait <= 0 {
+				b.state = circuitHalfOpen
+				log.Infof("[sink/%s] Circuit breaker half-open, probing the destination", name)
+				return nil
+			}
+			if b.spill {
+				return errCircuitOpen
+			}
+
+			b.lock.Unlock()
+			time.Sleep(wait)
+			b.lock.Lock()
+
+		case circuitHalfOpen:
+			if b.spill {
+				return errCircuitOpen
+			}
+
+			// the probe tells if the circuit closes or opens again
+			b.cond.Wait()
+		}
+	}
+}
+
+// record the result of an attempt, errors publishing again can't fix telling the destination
+// is up
+func (b *circuitBreaker) record(name string, err error) {
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err == nil || !isRetryable(err) {
+		if b.state != circuitClosed {
+			log.Infof("[sink/%s] Circuit breaker closed, the destination is back", name)
+			b.cond.Broadcast()
+		}
+		b.state = circuitClosed
+		b.failed = 0
+		return
+	}
+
+	b.failed++
+
+	switch {
+	case b.state == circuitHalfOpen:
+		b.state = circuitOpen
+		b.probeAt = time.Now().Add(b.probeInterval)
+		log.Warnf("[sink/%s] Circuit breaker probe failed, open for %s: %s", name, b.probeInterval, err)
+		b.cond.Broadcast()
+
+	case b.state == circuitClosed && b.failed >= b.failures:
+		b.state = circuitOpen
+		b.probeAt = time.Now().Add(b.probeInterval)
+		b.openings++
+		log.Errorf("[sink/%s] Circuit breaker open after %d consecutive failures (opened %d times so far), probing every %s: %s", name, b.failed, b.openings, b.probeInterval, err)
+	}
+}
@@ -0,0 +1,253 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// ClaimCheckSink keep the events sent to the sink under SINK_MAX_PAYLOAD_BYTES, events larger
+// than that (large job specs don't fit the 256 KiB of SQS and SNS) are either truncated, or
+// stored in a S3 or GCS bucket with a message pointing to them sent instead. It's behind the
+// queue, so storing events doesn't hold back the firehose, and the events which couldn't be
+// stored are dead-lettered and acknowledged like the ones the sink gave up on
+type ClaimCheckSink struct {
+	Sink
+	maxBytes int
+	firehose string
+	prefix   string
+	retry    *retryPolicy
+
+	// the sink acknowledges the events it delivered, otherwise they're acknowledged once handed
+	// to it
+	acking bool
+
+	// url of a stored payload, nil when oversized events are truncated
+	location func(key string) string
+	store    func(key string, data []byte) error
+}
+
+// truncatedEvent replace an oversized event, with the beginning of the event
+type truncatedEvent struct {
+	Truncated   bool   `json:"truncated"`
+	Size        int    `json:"size"`
+	ID          string `json:"id,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	ModifyIndex uint64 `json:"modify_index,omitempty"`
+	Payload     string `json:"payload"`
+}
+
+// claimCheckEvent replace an oversized event, pointing to where it's stored
+type claimCheckEvent struct {
+	ClaimCheck  string `json:"claim_check"`
+	Size        int    `json:"size"`
+	SHA256      string `json:"sha256"`
+	Type        string `json:"type"`
+	ID          string `json:"id,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	ModifyIndex uint64 `json:"modify_index,omitempty"`
+}
+
+// NewClaimCheck create a claim check sink, SINK_OVERSIZED_PAYLOADS being truncate (default), s3
+// or gcs, the payloads being stored in SINK_CLAIM_CHECK_BUCKET under SINK_CLAIM_CHECK_PREFIX
+func NewClaimCheck(s Sink, maxBytes int) (*ClaimCheckSink, error) {
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/claim-check] %s", err)
+	}
+
+	c := &ClaimCheckSink{
+		Sink:     s,
+		maxBytes: maxBytes,
+		firehose: os.Getenv("SINK_FIREHOSE"),
+		prefix:   os.Getenv("SINK_CLAIM_CHECK_PREFIX"),
+		retry:    retry,
+	}
+
+	mode := os.Getenv("SINK_OVERSIZED_PAYLOADS")
+	if mode == "" || mode == "truncate" {
+		return c, nil
+	}
+
+	bucket := os.Getenv("SINK_CLAIM_CHECK_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("[sink/claim-check] Missing SINK_CLAIM_CHECK_BUCKET (example: my-nomad-payloads)")
+	}
+	log.Infof("[sink/claim-check] Storing events larger than %d bytes in %s bucket %s", maxBytes, mode, bucket)
+
+	switch mode {
+	case "s3":
+		client := s3.New(session.Must(session.NewSession()))
+
+		c.location = func(key string) string { return "s3://" + bucket + "/" + key }
+		c.store = func(key string, data []byte) error {
+			_, err := client.PutObject(&s3.PutObjectInput{
+				Bucket:      aws.String(bucket),
+				Key:         aws.String(key),
+				Body:        bytes.NewReader(data),
+				ContentType: aws.String("application/json"),
+			})
+			return awsRetryable(err)
+		}
+	case "gcs":
+		// credentials are resolved through Application Default Credentials
+		client, err := storage.NewClient(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("[sink/claim-check] Failed to create storage client: %s", err)
+		}
+		handle := client.Bucket(bucket)
+
+		c.location = func(key string) string { return "gs://" + bucket + "/" + key }
+		c.store = func(key string, data []byte) error {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			w := handle.Object(key).NewWriter(ctx)
+			w.ContentType = "application/json"
+			if _, err := w.Write(data); err != nil {
+				w.Close()
+				return googleapiRetryable(err)
+			}
+			return googleapiRetryable(w.Close())
+		}
+	default:
+		return nil, fmt.Errorf("[sink/claim-check] Invalid SINK_OVERSIZED_PAYLOADS: %s, Valid values: truncate, s3 or gcs", mode)
+	}
+
+	return c, nil
+}
+
+// setDeadLetterQueue of the claim check and the sink
+func (s *ClaimCheckSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+
+	if d, ok := s.Sink.(deadLettering); ok {
+		d.setDeadLetterQueue(queue)
+	}
+}
+
+// setAckTracker of the claim check and the sink
+func (s *ClaimCheckSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+
+	if a, ok := s.Sink.(acknowledging); ok {
+		a.setAckTracker(acks)
+		s.acking = true
+	}
+}
+
+// Put ..
+func (s *ClaimCheckSink) Put(data []byte) error {
+	if len(data) > s.maxBytes {
+		var replaced []byte
+		var err error
+		if s.store != nil {
+			replaced, err = s.claimCheck(data)
+		} else {
+			replaced, err = s.truncate(data)
+		}
+		if err != nil {
+			log.Errorf("[sink/claim-check] %s", err)
+			s.retry.undeliverable("claim-check", data, err)
+			// the event won't reach the other sinks of a fan-out either
+			s.retry.acks.Ack(s.retry.acks.forEvents(1) - 1)
+			return nil
+		}
+		data = replaced
+	}
+
+	if err := s.Sink.Put(data); err != nil {
+		return err
+	}
+	if !s.acking {
+		s.retry.delivered(1)
+	}
+	return nil
+}
+
+// claimCheck store an event, returning the message pointing to it
+func (s *ClaimCheckSink) claimCheck(data []byte) ([]byte, error) {
+	fields := extractEventFields(data)
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	// partitioned by day, so a lifecycle rule can expire them
+	key := path.Join(s.prefix, s.firehose, time.Now().UTC().Format("2006-01-02"), digest+".json")
+	err := s.retry.Do("claim-check", func() error {
+		return s.store(key, data)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to store oversized event (%d bytes): %s", len(data), err)
+	}
+	log.Debugf("[sink/claim-check] Stored oversized event (%d bytes) as %s", len(data), key)
+
+	return json.Marshal(&claimCheckEvent{
+		ClaimCheck:  s.location(key),
+		Size:        len(data),
+		SHA256:      digest,
+		Type:        s.firehose,
+		ID:          fields.ID,
+		Namespace:   fields.Namespace,
+		ModifyIndex: fields.ModifyIndex,
+	})
+}
+
+// truncate an event to the beginning of it fitting in SINK_MAX_PAYLOAD_BYTES
+func (s *ClaimCheckSink) truncate(data []byte) ([]byte, error) {
+	fields := extractEventFields(data)
+	event := &truncatedEvent{
+		Truncated:   true,
+		Size:        len(data),
+		ID:          fields.ID,
+		Namespace:   fields.Namespace,
+		ModifyIndex: fields.ModifyIndex,
+	}
+
+	// escaping the payload makes it larger, cut it until the event fits
+	for n := s.maxBytes; ; {
+		n = len(truncateUTF8(data, n))
+		event.Payload = string(data[:n])
+
+		b, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) <= s.maxBytes {
+			log.Warnf("[sink/claim-check] Truncated oversized event (%d bytes) to %d bytes", len(data), len(b))
+			return b, nil
+		}
+
+		if n == 0 {
+			break
+		}
+		if n -= len(b) - s.maxBytes; n < 0 {
+			n = 0
+		}
+	}
+
+	return nil, fmt.Errorf("SINK_MAX_PAYLOAD_BYTES (%d) is too small to truncate events", s.maxBytes)
+}
+
+// truncateUTF8 cut data to at most n bytes, without splitting a character
+func truncateUTF8(data []byte, n int) []byte {
+	if n >= len(data) {
+		return data
+	}
+	for n > 0 && !utf8.RuneStart(data[n]) {
+		n--
+	}
+	return data[:n]
+}
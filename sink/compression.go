@@ -0,0 +1,78 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// payloadCompressor compress events before publishing them, with the codec from
+// SINK_<env>_COMPRESSION. The gzip, framed snappy and zstd formats all start with a magic
+// number, so consumers can tell compressed payloads apart even without a content encoding
+type payloadCompressor struct {
+	// content encoding of the payloads, empty when they aren't compressed
+	encoding string
+	writer   func(w io.Writer) (io.WriteCloser, error)
+}
+
+// newPayloadCompressor ...
+func newPayloadCompressor(env string) (*payloadCompressor, error) {
+	name := "SINK_" + env + "_COMPRESSION"
+
+	switch encoding := os.Getenv(name); encoding {
+	case "", "none":
+		return &payloadCompressor{}, nil
+	case "gzip":
+		return &payloadCompressor{encoding: encoding, writer: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		}}, nil
+	case "snappy":
+		return &payloadCompressor{encoding: encoding, writer: func(w io.Writer) (io.WriteCloser, error) {
+			return snappy.NewBufferedWriter(w), nil
+		}}, nil
+	case "zstd":
+		return &payloadCompressor{encoding: encoding, writer: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		}}, nil
+	default:
+		return nil, fmt.Errorf("Invalid %s value, must be one of: none, gzip, snappy, zstd", name)
+	}
+}
+
+// Enabled tell if payloads are compressed
+func (c *payloadCompressor) Enabled() bool {
+	return c.encoding != ""
+}
+
+// Encoding is the content encoding of the payloads (gzip, snappy or zstd), empty when they
+// aren't compressed
+func (c *payloadCompressor) Encoding() string {
+	return c.encoding
+}
+
+// Compress a payload, returned as-is when compression isn't enabled
+func (c *payloadCompressor) Compress(data []byte) ([]byte, error) {
+	if !c.Enabled() {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	w, err := c.writer(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
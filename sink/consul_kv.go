@@ -0,0 +1,269 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maximum number of operations of a Consul transaction
+	consulKVMaxTxnOps = 64
+
+	// maximum size of a Consul KV value
+	consulKVMaxValue = 512 * 1024
+)
+
+// ConsulKVSink mirror the latest event of every entity (allocation, job, node, ...) to Consul KV,
+// for consul-template and other Consul watchers
+type ConsulKVSink struct {
+	client        *consulapi.Client
+	prefix        string
+	key           *payloadTemplate
+	firehose      string
+	flushInterval time.Duration
+	indexes       map[string]uint64
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+// NewConsulKV ...
+func NewConsulKV() (*ConsulKVSink, error) {
+	prefix := os.Getenv("SINK_CONSUL_KV_PREFIX")
+	if prefix == "" {
+		prefix = "nomad-events"
+	}
+	prefix = strings.Trim(prefix, "/")
+	log.Infof("[sink/consul-kv] SINK_CONSUL_KV_PREFIX=%s", prefix)
+
+	var key *payloadTemplate
+	if spec := os.Getenv("SINK_CONSUL_KV_KEY"); spec != "" {
+		var err error
+		key, err = newPayloadTemplate("key", spec)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/consul-kv] Invalid SINK_CONSUL_KV_KEY: %s", err)
+		}
+	}
+
+	flushInterval, err := getenvDuration("SINK_CONSUL_KV_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/consul-kv] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/consul-kv] %s", err)
+	}
+
+	// same CONSUL_* configuration as the leader lock
+	client, err := consulapi.NewClient(consulapi.DefaultConfig())
+	if err != nil {
+		return nil, fmt.Errorf("[sink/consul-kv] %s", err)
+	}
+
+	return &ConsulKVSink{
+		client:        client,
+		prefix:        prefix,
+		key:           key,
+		firehose:      os.Getenv("SINK_FIREHOSE"),
+		flushInterval: flushInterval,
+		indexes:       make(map[string]uint64),
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *ConsulKVSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *ConsulKVSink) Stop() {
+	log.Infof("[sink/consul-kv] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/consul-kv] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer writes the pending keys when stopping
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+// setDeadLetterQueue ...
+func (s *ConsulKVSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *ConsulKVSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *ConsulKVSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *ConsulKVSink) write() {
+	log.Infof("[sink/consul-kv] Starting writer to '%s/'", s.prefix)
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	// latest event of each key since the last flush, so a burst of updates is a single write
+	pending := make(map[string][]byte)
+
+	for {
+		select {
+		case <-s.stopCh:
+			s.flush(pending)
+			return
+
+		case <-ticker.C:
+			s.flush(pending)
+			pending = make(map[string][]byte)
+
+		case data := <-s.putCh:
+			fields := extractEventFields(data)
+
+			key, err := s.keyOf(data, fields)
+			if err != nil {
+				log.Errorf("[sink/consul-kv] %s", err)
+				s.retry.undeliverable("consul-kv", data, err)
+				continue
+			}
+
+			if len(data) > consulKVMaxValue {
+				err := fmt.Errorf("Event for '%s' is too large (%d bytes), skipping it", key, len(data))
+				log.Errorf("[sink/consul-kv] %s", err)
+				s.retry.undeliverable("consul-kv", data, err)
+				continue
+			}
+
+			// events older than the one already mirrored, or replaced by a newer one before being
+			// written, have nothing left to deliver
+			if fields.ModifyIndex > 0 && fields.ModifyIndex < s.indexes[key] {
+				s.retry.delivered(1)
+				continue
+			}
+			s.indexes[key] = fields.ModifyIndex
+
+			if _, ok := pending[key]; ok {
+				s.retry.delivered(1)
+			}
+			pending[key] = data
+		}
+	}
+}
+
+// keyOf the event, <prefix>/<firehose>/[<namespace>/]<id> unless set by the key template
+func (s *ConsulKVSink) keyOf(data []byte, fields eventFields) (string, error) {
+	if s.key != nil {
+		key, err := s.key.Render(data)
+		if err != nil {
+			return "", fmt.Errorf("Could not render key: %s", err)
+		}
+		if key == "" {
+			return "", fmt.Errorf("Empty key for event, skipping it")
+		}
+		return s.prefix + "/" + strings.TrimLeft(key, "/"), nil
+	}
+
+	if fields.ID == "" {
+		return "", fmt.Errorf("No id in event, skipping it")
+	}
+
+	parts := []string{s.prefix, s.firehose}
+	if fields.Namespace != "" {
+		parts = append(parts, fields.Namespace)
+	}
+
+	return strings.Join(append(parts, fields.ID), "/"), nil
+}
+
+// flush write the pending keys in transactions
+func (s *ConsulKVSink) flush(pending map[string][]byte) {
+	if len(pending) == 0 {
+		return
+	}
+
+	ops := make(consulapi.TxnOps, 0, consulKVMaxTxnOps)
+
+	for key, data := range pending {
+		ops = append(ops, &consulapi.TxnOp{
+			KV: &consulapi.KVTxnOp{
+				Verb:  consulapi.KVSet,
+				Key:   key,
+				Value: data,
+			},
+		})
+
+		if len(ops) == consulKVMaxTxnOps {
+			s.txn(ops)
+			ops = ops[:0]
+		}
+	}
+
+	s.txn(ops)
+}
+
+func (s *ConsulKVSink) txn(ops consulapi.TxnOps) {
+	if len(ops) == 0 {
+		return
+	}
+
+	err := s.retry.Do("consul-kv", func() error {
+		ok, response, _, err := s.client.Txn().Txn(ops, nil)
+		if err != nil {
+			return err
+		}
+
+		// the transaction is rolled back, writing it again fails the same way
+		if !ok {
+			for _, txnErr := range response.Errors {
+				log.Errorf("[sink/consul-kv] Failed to write key '%s': %s", ops[txnErr.OpIndex].KV.Key, txnErr.What)
+			}
+			return permanent(fmt.Errorf("Transaction rolled back"))
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Errorf("[sink/consul-kv] Failed to write %d keys: %s", len(ops), err)
+		for _, op := range ops {
+			s.retry.undeliverable("consul-kv", op.KV.Value, err)
+		}
+		return
+	}
+
+	log.Debugf("[sink/consul-kv] Wrote %d keys", len(ops))
+	s.retry.delivered(len(ops))
+}
@@ -0,0 +1,278 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// limits of the Datadog events API
+const (
+	datadogMaxTitle = 100
+	datadogMaxText  = 4000
+)
+
+// DatadogSink post selected events to the Datadog Events API
+type DatadogSink struct {
+	client         *http.Client
+	url            string
+	apiKey         string
+	filter         *payloadTemplate
+	title          *payloadTemplate
+	alertType      *payloadTemplate
+	aggregationKey *payloadTemplate
+	tags           map[string]*payloadTemplate
+	retry          *retryPolicy
+	stopCh         chan interface{}
+	putCh          chan []byte
+}
+
+// datadogEvent is the body of the events API
+type datadogEvent struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	Tags           []string `json:"tags,omitempty"`
+	AlertType      string   `json:"alert_type,omitempty"`
+	AggregationKey string   `json:"aggregation_key,omitempty"`
+	SourceTypeName string   `json:"source_type_name"`
+}
+
+// NewDatadog ...
+func NewDatadog() (*DatadogSink, error) {
+	apiKey := os.Getenv("SINK_DATADOG_API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("[sink/datadog] Missing SINK_DATADOG_API_KEY")
+	}
+
+	site := os.Getenv("SINK_DATADOG_SITE")
+	if site == "" {
+		site = "datadoghq.com"
+	}
+	log.Infof("[sink/datadog] SINK_DATADOG_SITE=%s", site)
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/datadog] %s", err)
+	}
+
+	s := &DatadogSink{
+		client: &http.Client{Timeout: 30 * time.Second},
+		url:    "https://api." + site + "/api/v1/events",
+		apiKey: apiKey,
+		retry:  retry,
+		stopCh: make(chan interface{}),
+		putCh:  make(chan []byte, 1000),
+	}
+
+	templates := []struct {
+		env  string
+		def  string
+		dest **payloadTemplate
+	}{
+		{"SINK_DATADOG_FILTER", "true", &s.filter},
+		{"SINK_DATADOG_TITLE", "Nomad {{ firehose }} {{ .Type }}", &s.title},
+		{"SINK_DATADOG_ALERT_TYPE", "info", &s.alertType},
+		{"SINK_DATADOG_AGGREGATION_KEY", "", &s.aggregationKey},
+	}
+
+	for _, t := range templates {
+		value := os.Getenv(t.env)
+		if value == "" {
+			value = t.def
+		}
+
+		tmpl, err := newPayloadTemplate(t.env, value)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/datadog] Invalid %s: %s", t.env, err)
+		}
+		*t.dest = tmpl
+	}
+
+	tagsStr, ok := os.LookupEnv("SINK_DATADOG_TAGS")
+	if !ok {
+		tagsStr = "firehose={{ firehose }},namespace={{ .Namespace }},job_id={{ .JobID }},type={{ .Type }}"
+	}
+
+	tags, err := newPayloadTemplates(tagsStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/datadog] Invalid SINK_DATADOG_TAGS: %s", err)
+	}
+	s.tags = tags
+
+	return s, nil
+}
+
+// Start ...
+func (s *DatadogSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *DatadogSink) Stop() {
+	log.Infof("[sink/datadog] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/datadog] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+}
+
+// setDeadLetterQueue ...
+func (s *DatadogSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *DatadogSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *DatadogSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *DatadogSink) write() {
+	log.Info("[sink/datadog] Starting writer")
+
+	for {
+		select {
+		case data := <-s.putCh:
+			event, err := s.event(data)
+			if err != nil {
+				log.Errorf("[sink/datadog] %s", err)
+				s.retry.undeliverable("datadog", data, err)
+				continue
+			}
+
+			// not selected by the filter
+			if event == nil {
+				s.retry.delivered(1)
+				continue
+			}
+
+			err = s.retry.Do("datadog", func() error {
+				return s.post(event)
+			})
+			if err != nil {
+				log.Errorf("[sink/datadog] %s", err)
+				s.retry.undeliverable("datadog", data, err)
+			} else {
+				log.Debugf("[sink/datadog] Posted event '%s'", event.Title)
+				s.retry.delivered(1)
+			}
+		}
+	}
+}
+
+// event build the Datadog event for an event, or nil if it isn't selected by the filter
+func (s *DatadogSink) event(data []byte) (*datadogEvent, error) {
+	selected, err := s.filter.Render(data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not render filter: %s", err)
+	}
+	if selected != "true" {
+		return nil, nil
+	}
+
+	title, err := s.title.Render(data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not render title: %s", err)
+	}
+
+	alertType, err := s.alertType.Render(data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not render alert type: %s", err)
+	}
+
+	aggregationKey, err := s.aggregationKey.Render(data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not render aggregation key: %s", err)
+	}
+
+	tags, err := renderPayloadTemplates(s.tags, data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not render tags: %s", err)
+	}
+
+	event := &datadogEvent{
+		Title:          truncate(title, datadogMaxTitle),
+		AlertType:      alertType,
+		AggregationKey: aggregationKey,
+		SourceTypeName: "nomad",
+	}
+
+	for _, name := range sortedKeys(tags) {
+		event.Tags = append(event.Tags, name+":"+tags[name])
+	}
+
+	// the event as a markdown code block
+	var text bytes.Buffer
+	if err := json.Indent(&text, data, "", "  "); err != nil {
+		text.Reset()
+		text.Write(data)
+	}
+	prefix, suffix := "%%% \n```json\n", "\n```\n %%%"
+	event.Text = prefix + truncate(text.String(), datadogMaxText-len(prefix)-len(suffix)) + suffix
+
+	return event, nil
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
+
+// post an event to the events API
+func (s *DatadogSink) post(event *datadogEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return permanent(err)
+	}
+
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", s.apiKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return httpRetryable(resp.StatusCode, fmt.Errorf("Failed to post event: status %d: %s", resp.StatusCode, b))
+	}
+
+	return nil
+}
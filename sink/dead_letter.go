@@ -0,0 +1,144 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DeadLetterSink send the events the wrapped sink couldn't deliver to a dead-letter sink,
+// configured by SINK_DEAD_LETTER_TYPE
+type DeadLetterSink struct {
+	Sink
+	queue *deadLetterQueue
+}
+
+// deadLetterQueue is where sinks put the events they gave up on
+type deadLetterQueue struct {
+	sink Sink
+}
+
+// deadLetter is an event that couldn't be delivered, with the reason why
+type deadLetter struct {
+	Sink     string
+	Error    string
+	FailedAt time.Time
+	Event    json.RawMessage
+}
+
+// deadLettering sinks put the events they gave up on in a dead-letter queue
+type deadLettering interface {
+	setDeadLetterQueue(queue *deadLetterQueue)
+}
+
+// NewDeadLetter create a dead-letter sink of type sinkType for s, refusing sinks which never give
+// up on events (stdout, null, websocket)
+func NewDeadLetter(s Sink, sinkType, region string) (*DeadLetterSink, error) {
+	d, ok := s.(deadLettering)
+
+	// the claim check dead-letters the events it couldn't store, not the ones of the sink
+	if c, isClaimCheck := s.(*ClaimCheckSink); isClaimCheck {
+		_, ok = c.Sink.(deadLettering)
+	}
+	if !ok {
+		return nil, fmt.Errorf("[sink/dead-letter] SINK_TYPE %s doesn't report undeliverable events, unset SINK_DEAD_LETTER_TYPE", os.Getenv("SINK_TYPE"))
+	}
+
+	deadLetterSink, err := newDeadLetterSink(sinkType, region)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/dead-letter] %s", err)
+	}
+
+	queue := &deadLetterQueue{sink: deadLetterSink}
+	d.setDeadLetterQueue(queue)
+
+	log.Infof("[sink/dead-letter] Sending undeliverable events to the %s sink", sinkType)
+
+	return &DeadLetterSink{Sink: s, queue: queue}, nil
+}
+
+// newDeadLetterSink create a sink with the SINK_DEAD_LETTER_ prefixed environment variables
+// overriding the SINK_ ones (example: SINK_DEAD_LETTER_KAFKA_TOPIC for SINK_KAFKA_TOPIC), so the
+// dead-letter sink can be of the same type as the sink, with a different destination
+func newDeadLetterSink(sinkType, region string) (Sink, error) {
+	const prefix = "SINK_DEAD_LETTER_"
+
+	restore := make(map[string]*string)
+	defer func() {
+		for name, value := range restore {
+			if value == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *value)
+			}
+		}
+	}()
+
+	for _, pair := range os.Environ() {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) || parts[0] == prefix+"TYPE" {
+			continue
+		}
+
+		name := "SINK_" + strings.TrimPrefix(parts[0], prefix)
+		if value, ok := os.LookupEnv(name); ok {
+			restore[name] = &value
+		} else {
+			restore[name] = nil
+		}
+		os.Setenv(name, parts[1])
+	}
+
+	return newSink(sinkType, region)
+}
+
+// Start ...
+func (s *DeadLetterSink) Start() error {
+	go func() {
+		if err := s.queue.sink.Start(); err != nil {
+			log.Errorf("[sink/dead-letter] %s", err)
+		}
+	}()
+
+	return s.Sink.Start()
+}
+
+// Stop the sink first, so the events it gives up on while stopping still go to the dead-letter sink
+func (s *DeadLetterSink) Stop() {
+	s.Sink.Stop()
+	s.queue.sink.Stop()
+}
+
+// Put an event the sink gave up on after the error err in the dead-letter queue, a nil queue
+// drops it
+func (q *deadLetterQueue) Put(sinkName string, data []byte, err error) {
+	if q == nil {
+		return
+	}
+
+	letter := &deadLetter{
+		Sink:     sinkName,
+		Error:    err.Error(),
+		FailedAt: time.Now().UTC(),
+		Event:    data,
+	}
+
+	// keep the event as a string if it's not JSON
+	if !json.Valid(data) {
+		letter.Event, _ = json.Marshal(string(data))
+	}
+
+	b, err := json.Marshal(letter)
+	if err != nil {
+		log.Errorf("[sink/dead-letter] %s", err)
+		return
+	}
+
+	if err := q.sink.Put(b); err != nil {
+		log.Errorf("[sink/dead-letter] %s", err)
+	}
+}
@@ -0,0 +1,194 @@
+package sink
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultDedupIgnoreFields are the fields changing without the entity changing, blocking queries
+// returning the same job again with a new index for example
+const defaultDedupIgnoreFields = "ModifyIndex,ModifyTime,JobModifyIndex,AllocModifyIndex,SubmitTime,Index"
+
+// DedupSink skip the events identical to the previous event of the same entity (allocation, job,
+// node, ...), the fields of SINK_DEDUP_IGNORE_FIELDS aside, remembering the last event of up to
+// SINK_DEDUP_SIZE entities
+type DedupSink struct {
+	Sink
+	key     *payloadTemplate
+	ignore  map[string]bool
+	size    int
+	skipped uint64
+
+	lock    sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// dedupEntry is the last event of an entity
+type dedupEntry struct {
+	key  string
+	hash [sha256.Size]byte
+}
+
+// NewDedup create a dedup sink, entities being told apart by SINK_DEDUP_KEY (template, default:
+// the namespace and id of the event)
+func NewDedup(s Sink) (*DedupSink, error) {
+	size, err := getenvInt("SINK_DEDUP_SIZE", 10000)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/dedup] %s", err)
+	}
+	if size < 1 {
+		return nil, fmt.Errorf("[sink/dedup] Invalid SINK_DEDUP_SIZE value, must be positive")
+	}
+
+	var key *payloadTemplate
+	if keyStr := os.Getenv("SINK_DEDUP_KEY"); keyStr != "" {
+		key, err = newPayloadTemplate("dedup-key", keyStr)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/dedup] Invalid SINK_DEDUP_KEY: %s", err)
+		}
+	}
+
+	fields, ok := os.LookupEnv("SINK_DEDUP_IGNORE_FIELDS")
+	if !ok {
+		fields = defaultDedupIgnoreFields
+	}
+
+	ignore := make(map[string]bool)
+	for _, field := range strings.Split(fields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			ignore[field] = true
+		}
+	}
+
+	return &DedupSink{
+		Sink:    s,
+		key:     key,
+		ignore:  ignore,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}, nil
+}
+
+// WaitForAcks ...
+func (s *DedupSink) WaitForAcks() bool {
+	return Acknowledged(s.Sink)
+}
+
+// Put ..
+func (s *DedupSink) Put(data []byte) error {
+	key, err := s.entity(data)
+	if err != nil {
+		log.Warnf("[sink/dedup] Could not render dedup key: %s", err)
+		return s.Sink.Put(data)
+	}
+
+	// events without an entity are always sent
+	if key == "" {
+		return s.Sink.Put(data)
+	}
+
+	hash, err := s.hash(data)
+	if err != nil {
+		log.Debugf("[sink/dedup] not deduplicating non JSON event: %s", err)
+		return s.Sink.Put(data)
+	}
+
+	if s.duplicate(key, hash) {
+		s.lock.Lock()
+		s.skipped++
+		skipped := s.skipped
+		s.lock.Unlock()
+
+		log.Debugf("[sink/dedup] Skipping duplicate event of %s (%d skipped so far)", key, skipped)
+		return nil
+	}
+
+	return s.Sink.Put(data)
+}
+
+// entity of an event, empty when it has none
+func (s *DedupSink) entity(data []byte) (string, error) {
+	if s.key != nil {
+		return s.key.Render(data)
+	}
+
+	fields := extractEventFields(data)
+	if fields.ID == "" {
+		return "", nil
+	}
+	return fields.Namespace + "/" + fields.ID, nil
+}
+
+// hash the event without the ignored fields, the keys of the objects being sorted when marshalled
+func (s *DedupSink) hash(data []byte) ([sha256.Size]byte, error) {
+	var event interface{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return [sha256.Size]byte{}, err
+	}
+
+	b, err := json.Marshal(s.normalize(event))
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+
+	return sha256.Sum256(b), nil
+}
+
+// normalize remove the ignored fields at any depth
+func (s *DedupSink) normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if s.ignore[key] {
+				delete(v, key)
+				continue
+			}
+			v[key] = s.normalize(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = s.normalize(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// duplicate tell if the event is the same as the last one of the entity, remembering it otherwise
+func (s *DedupSink) duplicate(key string, hash [sha256.Size]byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		s.lru.MoveToFront(element)
+
+		entry := element.Value.(*dedupEntry)
+		if entry.hash == hash {
+			return true
+		}
+		entry.hash = hash
+		return false
+	}
+
+	s.entries[key] = s.lru.PushFront(&dedupEntry{key: key, hash: hash})
+
+	// forget the least recently seen entity
+	if s.lru.Len() > s.size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*dedupEntry).key)
+	}
+
+	return false
+}
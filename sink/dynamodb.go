@@ -0,0 +1,352 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	log "github.com/sirupsen/logrus"
+)
+
+// maximum number of items of a BatchWriteItem call
+const dynamoDBMaxBatchSize = 25
+
+// DynamoDBSink write events as items of a DynamoDB table, either appending every event
+// (event log keyed by partition and sort key) or overwriting an item per event id (latest state)
+type DynamoDBSink struct {
+	session       *session.Session
+	dynamodb      *dynamodb.DynamoDB
+	table         string
+	firehose      string
+	upsert        bool
+	partitionKey  string
+	sortKey       string
+	key           *payloadTemplate
+	ttl           time.Duration
+	batchSize     int
+	flushInterval time.Duration
+	sequence      uint64
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+// NewDynamoDB ...
+func NewDynamoDB() (*DynamoDBSink, error) {
+	table := os.Getenv("SINK_DYNAMODB_TABLE")
+	if table == "" {
+		return nil, fmt.Errorf("[sink/dynamodb] Missing SINK_DYNAMODB_TABLE (example: nomad-firehose-events)")
+	}
+	log.Infof("[sink/dynamodb] SINK_DYNAMODB_TABLE=%s", table)
+
+	mode := os.Getenv("SINK_DYNAMODB_MODE")
+	if mode == "" {
+		mode = "append"
+	}
+	if mode != "append" && mode != "upsert" {
+		return nil, fmt.Errorf("[sink/dynamodb] Invalid SINK_DYNAMODB_MODE value, must be one of: append, upsert")
+	}
+
+	partitionKey := os.Getenv("SINK_DYNAMODB_PARTITION_KEY")
+	if partitionKey == "" {
+		partitionKey = "pk"
+	}
+
+	sortKey := os.Getenv("SINK_DYNAMODB_SORT_KEY")
+	if sortKey == "" {
+		sortKey = "sk"
+	}
+
+	var key *payloadTemplate
+	if spec := os.Getenv("SINK_DYNAMODB_KEY"); spec != "" {
+		var err error
+		key, err = newPayloadTemplate("key", spec)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/dynamodb] Invalid SINK_DYNAMODB_KEY: %s", err)
+		}
+	}
+
+	ttl, err := getenvDuration("SINK_DYNAMODB_TTL", 0)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/dynamodb] %s", err)
+	}
+
+	batchSize, err := getenvInt("SINK_DYNAMODB_BATCH_SIZE", dynamoDBMaxBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/dynamodb] %s", err)
+	}
+	if batchSize < 1 || batchSize > dynamoDBMaxBatchSize {
+		return nil, fmt.Errorf("[sink/dynamodb] Invalid SINK_DYNAMODB_BATCH_SIZE value, must be between 1 and %d", dynamoDBMaxBatchSize)
+	}
+
+	flushInterval, err := getenvDuration("SINK_DYNAMODB_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/dynamodb] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/dynamodb] %s", err)
+	}
+
+	sess := session.Must(session.NewSession())
+
+	s := &DynamoDBSink{
+		session:       sess,
+		dynamodb:      dynamodb.New(sess),
+		table:         table,
+		firehose:      os.Getenv("SINK_FIREHOSE"),
+		upsert:        mode == "upsert",
+		partitionKey:  partitionKey,
+		key:           key,
+		ttl:           ttl,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}
+
+	// the latest state items only have a partition key
+	if !s.upsert {
+		s.sortKey = sortKey
+	}
+
+	if err := s.describe(); err != nil {
+		return nil, fmt.Errorf("[sink/dynamodb] %s", err)
+	}
+
+	return s, nil
+}
+
+// describe check the key schema of the table matches the mode, and log its billing mode
+func (s *DynamoDBSink) describe() error {
+	output, err := s.dynamodb.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(s.table)})
+	if err != nil {
+		return fmt.Errorf("Failed to describe table %s: %s", s.table, err)
+	}
+
+	keys := make(map[string]string)
+	for _, element := range output.Table.KeySchema {
+		keys[aws.StringValue(element.KeyType)] = aws.StringValue(element.AttributeName)
+	}
+
+	if keys[dynamodb.KeyTypeHash] != s.partitionKey || keys[dynamodb.KeyTypeRange] != s.sortKey {
+		return fmt.Errorf("Table %s key schema (partition key '%s', sort key '%s') doesn't match, expected partition key '%s' and sort key '%s'",
+			s.table, keys[dynamodb.KeyTypeHash], keys[dynamodb.KeyTypeRange], s.partitionKey, s.sortKey)
+	}
+
+	billingMode := dynamodb.BillingModeProvisioned
+	if output.Table.BillingModeSummary != nil {
+		billingMode = aws.StringValue(output.Table.BillingModeSummary.BillingMode)
+	}
+
+	if billingMode == dynamodb.BillingModeProvisioned && output.Table.ProvisionedThroughput != nil {
+		log.Infof("[sink/dynamodb] Table %s is provisioned with %d write capacity units, throttled writes are retried", s.table, aws.Int64Value(output.Table.ProvisionedThroughput.WriteCapacityUnits))
+	} else {
+		log.Infof("[sink/dynamodb] Table %s billing mode is %s", s.table, billingMode)
+	}
+
+	return nil
+}
+
+// Start ...
+func (s *DynamoDBSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *DynamoDBSink) Stop() {
+	log.Infof("[sink/dynamodb] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/dynamodb] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer writes the last partial batch when stopping
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+// setDeadLetterQueue ...
+func (s *DynamoDBSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *DynamoDBSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *DynamoDBSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *DynamoDBSink) write() {
+	log.Infof("[sink/dynamodb] Starting writer to table '%s'", s.table)
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]map[string]*dynamodb.AttributeValue, 0, s.batchSize)
+
+	for {
+		select {
+		case <-s.stopCh:
+			s.flush(batch)
+			return
+
+		case <-ticker.C:
+			s.flush(batch)
+			batch = batch[:0]
+
+		case data := <-s.putCh:
+			item, err := s.item(data)
+			if err != nil {
+				log.Errorf("[sink/dynamodb] %s", err)
+				s.retry.undeliverable("dynamodb", data, err)
+				continue
+			}
+
+			// a batch can't write the same item twice, only the latest state of it is kept, the
+			// replaced state having nothing left to deliver
+			if s.upsert {
+				var replaced bool
+				batch, replaced = s.replace(batch, item)
+				if replaced {
+					s.retry.delivered(1)
+				}
+			} else {
+				batch = append(batch, item)
+			}
+
+			if len(batch) >= s.batchSize {
+				s.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// item build the DynamoDB item of an event
+func (s *DynamoDBSink) item(data []byte) (map[string]*dynamodb.AttributeValue, error) {
+	fields := extractEventFields(data)
+	now := time.Now().UTC()
+
+	key := s.firehose + "#" + fields.ID
+	if s.key != nil {
+		var err error
+		key, err = s.key.Render(data)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to render key: %s", err)
+		}
+	}
+	if key == "" {
+		return nil, fmt.Errorf("Empty key for event, skipping it")
+	}
+
+	item := map[string]*dynamodb.AttributeValue{
+		s.partitionKey: {S: aws.String(key)},
+		"firehose":     {S: aws.String(s.firehose)},
+		"modify_index": {N: aws.String(strconv.FormatUint(fields.ModifyIndex, 10))},
+		"created_at":   {S: aws.String(now.Format(time.RFC3339Nano))},
+		"payload":      {S: aws.String(string(data))},
+	}
+
+	if fields.ID != "" {
+		item["event_id"] = &dynamodb.AttributeValue{S: aws.String(fields.ID)}
+	}
+
+	if fields.Namespace != "" {
+		item["namespace"] = &dynamodb.AttributeValue{S: aws.String(fields.Namespace)}
+	}
+
+	// time ordered and unique, even for events created in the same nanosecond
+	if s.sortKey != "" {
+		s.sequence++
+		item[s.sortKey] = &dynamodb.AttributeValue{S: aws.String(fmt.Sprintf("%s#%06d", now.Format("2006-01-02T15:04:05.000000000Z"), s.sequence%1000000))}
+	}
+
+	if s.ttl > 0 {
+		item["expires_at"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(now.Add(s.ttl).Unix(), 10))}
+	}
+
+	return item, nil
+}
+
+// replace the item with the same key in the batch, or append it, telling if an item was replaced
+func (s *DynamoDBSink) replace(batch []map[string]*dynamodb.AttributeValue, item map[string]*dynamodb.AttributeValue) ([]map[string]*dynamodb.AttributeValue, bool) {
+	key := aws.StringValue(item[s.partitionKey].S)
+
+	for i, existing := range batch {
+		if aws.StringValue(existing[s.partitionKey].S) == key {
+			batch[i] = item
+			return batch, true
+		}
+	}
+
+	return append(batch, item), false
+}
+
+// flush write a batch, retrying the unprocessed (throttled) items with a backoff
+func (s *DynamoDBSink) flush(batch []map[string]*dynamodb.AttributeValue) {
+	if len(batch) == 0 {
+		return
+	}
+
+	requests := make([]*dynamodb.WriteRequest, 0, len(batch))
+	for _, item := range batch {
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+
+	// the unprocessed (throttled) items are sent again until none is left
+	err := s.retry.Do("dynamodb", func() error {
+		output, err := s.dynamodb.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{s.table: requests},
+		})
+		if err != nil {
+			return awsRetryable(err)
+		}
+
+		unprocessed := output.UnprocessedItems[s.table]
+		log.Debugf("[sink/dynamodb] Wrote %d items (%d unprocessed)", len(requests)-len(unprocessed), len(unprocessed))
+		s.retry.delivered(len(requests) - len(unprocessed))
+
+		requests = unprocessed
+		if len(requests) > 0 {
+			return fmt.Errorf("%d items were throttled", len(requests))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("[sink/dynamodb] Failed to write %d items: %s", len(requests), err)
+		for _, request := range requests {
+			s.retry.undeliverable("dynamodb", []byte(aws.StringValue(request.PutRequest.Item["payload"].S)), err)
+		}
+	}
+}
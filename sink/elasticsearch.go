@@ -0,0 +1,291 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ElasticsearchSink index events with the bulk API, which is the same for Elasticsearch and OpenSearch
+type ElasticsearchSink struct {
+	client        *http.Client
+	url           string
+	index         *payloadTemplate
+	username      string
+	password      string
+	apiKey        string
+	batchSize     int
+	flushInterval time.Duration
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+// bulkResponse is the part of the bulk API response needed to find failed documents
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// NewElasticsearch ...
+func NewElasticsearch() (*ElasticsearchSink, error) {
+	url := os.Getenv("SINK_ELASTICSEARCH_URL")
+	if url == "" {
+		return nil, fmt.Errorf("[sink/elasticsearch] Missing SINK_ELASTICSEARCH_URL (example: https://127.0.0.1:9200)")
+	}
+	log.Infof("[sink/elasticsearch] SINK_ELASTICSEARCH_URL=%s", url)
+
+	indexStr := os.Getenv("SINK_ELASTICSEARCH_INDEX")
+	if indexStr == "" {
+		indexStr = `nomad-firehose-{{ firehose }}-{{ now.Format "2006.01.02" }}`
+	}
+	log.Infof("[sink/elasticsearch] SINK_ELASTICSEARCH_INDEX=%s", indexStr)
+
+	index, err := newPayloadTemplate("index", indexStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/elasticsearch] Invalid SINK_ELASTICSEARCH_INDEX: %s", err)
+	}
+
+	batchSize, err := getenvInt("SINK_ELASTICSEARCH_BATCH_SIZE", 500)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/elasticsearch] %s", err)
+	}
+
+	flushInterval, err := getenvDuration("SINK_ELASTICSEARCH_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/elasticsearch] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/elasticsearch] %s", err)
+	}
+	if err := retry.maxRetriesFrom("SINK_ELASTICSEARCH_MAX_RETRIES"); err != nil {
+		return nil, fmt.Errorf("[sink/elasticsearch] %s", err)
+	}
+
+	return &ElasticsearchSink{
+		client:        &http.Client{Timeout: 30 * time.Second},
+		url:           strings.TrimRight(url, "/") + "/_bulk",
+		index:         index,
+		username:      os.Getenv("SINK_ELASTICSEARCH_USERNAME"),
+		password:      os.Getenv("SINK_ELASTICSEARCH_PASSWORD"),
+		apiKey:        os.Getenv("SINK_ELASTICSEARCH_API_KEY"),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *ElasticsearchSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *ElasticsearchSink) Stop() {
+	log.Infof("[sink/elasticsearch] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/elasticsearch] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer sends the last partial batch when stopping
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+// setDeadLetterQueue ...
+func (s *ElasticsearchSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *ElasticsearchSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *ElasticsearchSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *ElasticsearchSink) write() {
+	log.Info("[sink/elasticsearch] Starting writer")
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	// each document is an action line followed by the source line
+	batch := make([][]byte, 0, s.batchSize*2)
+
+	for {
+		select {
+		case <-s.stopCh:
+			s.bulk(batch)
+			return
+
+		case <-ticker.C:
+			s.bulk(batch)
+			batch = make([][]byte, 0, s.batchSize*2)
+
+		case data := <-s.putCh:
+			index, err := s.index.Render(data)
+			if err != nil {
+				log.Errorf("[sink/elasticsearch] Could not render index: %s", err)
+				s.retry.undeliverable("elasticsearch", data, err)
+				continue
+			}
+
+			action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": index}})
+			batch = append(batch, action, withTimestamp(data))
+
+			if len(batch) >= s.batchSize*2 {
+				s.bulk(batch)
+				batch = make([][]byte, 0, s.batchSize*2)
+			}
+		}
+	}
+}
+
+// withTimestamp add an @timestamp field to the event, so it can be used as the time field in
+// Kibana / OpenSearch Dashboards
+func withTimestamp(data []byte) []byte {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(data, &event); err != nil {
+		return data
+	}
+
+	if _, ok := event["@timestamp"]; ok {
+		return data
+	}
+
+	event["@timestamp"], _ = json.Marshal(time.Now().UTC())
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return data
+	}
+	return b
+}
+
+// bulk index a batch, sending the documents rejected with 429 (or all on 429 / 5xx responses)
+// again, the documents given up on being dead-lettered, with the @timestamp field
+func (s *ElasticsearchSink) bulk(batch [][]byte) {
+	if len(batch) == 0 {
+		return
+	}
+
+	err := s.retry.Do("elasticsearch", func() error {
+		var err error
+		batch, err = s.send(batch)
+		if err == nil && len(batch) > 0 {
+			err = fmt.Errorf("%d documents rejected with 429 Too Many Requests", len(batch)/2)
+		}
+		return err
+	})
+	if err != nil {
+		log.Errorf("[sink/elasticsearch] Giving up indexing %d documents: %s", len(batch)/2, err)
+		for i := 1; i < len(batch); i += 2 {
+			s.retry.undeliverable("elasticsearch", batch[i], err)
+		}
+	}
+}
+
+// send a single bulk request, returning the action and source lines not indexed yet, all of them
+// on errors
+func (s *ElasticsearchSink) send(batch [][]byte) ([][]byte, error) {
+	var body bytes.Buffer
+	for _, line := range batch {
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest("POST", s.url, &body)
+	if err != nil {
+		return batch, permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+s.apiKey)
+	} else if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return batch, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return batch, err
+	}
+
+	if resp.StatusCode >= 300 {
+		return batch, httpRetryable(resp.StatusCode, fmt.Errorf("Bulk request failed with status %d: %s", resp.StatusCode, b))
+	}
+
+	var result bulkResponse
+	if err := json.Unmarshal(b, &result); err != nil {
+		return batch, permanent(err)
+	}
+
+	if !result.Errors {
+		log.Debugf("[sink/elasticsearch] Indexed %d documents", len(batch)/2)
+		s.retry.delivered(len(batch) / 2)
+		return nil, nil
+	}
+
+	// items are in the same order as the documents, only retry those rejected by back pressure
+	var retry [][]byte
+	for i, item := range result.Items {
+		for _, status := range item {
+			switch {
+			case status.Status == http.StatusTooManyRequests:
+				retry = append(retry, batch[i*2], batch[i*2+1])
+			case status.Status >= 300:
+				log.Errorf("[sink/elasticsearch] Failed to index document: %s", status.Error)
+				s.retry.undeliverable("elasticsearch", batch[i*2+1], fmt.Errorf("status %d: %s", status.Status, status.Error))
+			default:
+				s.retry.delivered(1)
+			}
+		}
+	}
+
+	return retry, nil
+}
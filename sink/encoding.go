@@ -0,0 +1,77 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+)
+
+// getenvEncoding read the encoding of the events of a sink, SINK_<env>_ENCODING defaulting to
+// SINK_ENCODING (set by --encoding) and then json
+func getenvEncoding(env string) string {
+	if encoding := os.Getenv("SINK_" + env + "_ENCODING"); encoding != "" {
+		return encoding
+	}
+	if encoding := os.Getenv("SINK_ENCODING"); encoding != "" {
+		return encoding
+	}
+	return "json"
+}
+
+// newEventEncoder return the encoder of the events of a sink, nil when they're sent as JSON
+func newEventEncoder(env string) (func(data []byte) ([]byte, error), error) {
+	switch encoding := getenvEncoding(env); encoding {
+	case "json":
+		return nil, nil
+	case "protobuf":
+		// the messages are the events themselves
+		if os.Getenv("SINK_ENVELOPE") == "true" {
+			return nil, fmt.Errorf("The protobuf encoding can't be used with --envelope")
+		}
+
+		encoder, err := newProtobufEncoder(os.Getenv("SINK_FIREHOSE"))
+		if err != nil {
+			return nil, err
+		}
+		return encoder.Encode, nil
+	case "msgpack":
+		return msgpackEncode, nil
+	case "avro":
+		schema, err := newAvroSchema(os.Getenv("SINK_"+env+"_AVRO_SCHEMA_DIR"), os.Getenv("SINK_FIREHOSE"))
+		if err != nil {
+			return nil, err
+		}
+
+		// every message is a container file with the schema of its event, unless consumers have
+		// the schemas at hand and the messages only carry their fingerprint
+		switch value := os.Getenv("SINK_" + env + "_AVRO_SCHEMA"); value {
+		case "", "embedded":
+			return func(data []byte) ([]byte, error) {
+				native, err := schema.Native(data)
+				if err != nil {
+					return nil, err
+				}
+				return schema.Container([]interface{}{native}, "null")
+			}, nil
+		case "external":
+			return schema.EncodeSingle, nil
+		default:
+			return nil, fmt.Errorf("Invalid SINK_%s_AVRO_SCHEMA value, must be one of: embedded, external", env)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid SINK_%s_ENCODING value, must be one of: json, avro, msgpack, protobuf", env)
+	}
+}
+
+// eventContentType is the MIME type of the events sent with an encoding
+func eventContentType(encoding string) string {
+	switch encoding {
+	case "avro":
+		return "avro/binary"
+	case "msgpack":
+		return "application/msgpack"
+	case "protobuf":
+		return "application/x-protobuf"
+	default:
+		return "application/json"
+	}
+}
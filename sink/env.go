@@ -0,0 +1,64 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// getenvInt read an integer environment variable, returning def when it's not set
+func getenvInt(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s value, must be an integer", name)
+	}
+	return i, nil
+}
+
+// getenvBool read a boolean environment variable, returning def when it's not set
+func getenvBool(name string, def bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid %s value, must be true or false", name)
+	}
+	return b, nil
+}
+
+// getenvDuration read a duration environment variable (example: 5s), returning def when it's not set
+func getenvDuration(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s value, must be a duration (example: 5s)", name)
+	}
+	return d, nil
+}
+
+// getenvFloat read a float environment variable, returning def when it's not set
+func getenvFloat(name string, def float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s value, must be a number", name)
+	}
+	return f, nil
+}
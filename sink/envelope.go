@@ -0,0 +1,74 @@
+package sink
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// EnvelopeSink wrap every event in an envelope with its firehose type, id and modify index, so
+// consumers reading the events of several firehoses from one destination can tell them apart
+type EnvelopeSink struct {
+	Sink
+	firehose string
+	cluster  string
+}
+
+// envelope of an event
+type envelope struct {
+	Type      string          `json:"type"`
+	ID        string          `json:"id"`
+	Index     uint64          `json:"index"`
+	EmittedAt time.Time       `json:"emitted_at"`
+	Cluster   string          `json:"cluster"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// NewEnvelope create an envelope sink, the cluster being SINK_ENVELOPE_CLUSTER or by default
+// the nomad region
+func NewEnvelope(s Sink, region string) (*EnvelopeSink, error) {
+	cluster := os.Getenv("SINK_ENVELOPE_CLUSTER")
+	if cluster == "" {
+		cluster = region
+	}
+	if cluster == "" {
+		cluster = os.Getenv("NOMAD_REGION")
+	}
+
+	return &EnvelopeSink{
+		Sink:     s,
+		firehose: os.Getenv("SINK_FIREHOSE"),
+		cluster:  cluster,
+	}, nil
+}
+
+// WaitForAcks ...
+func (s *EnvelopeSink) WaitForAcks() bool {
+	return Acknowledged(s.Sink)
+}
+
+// Put ..
+func (s *EnvelopeSink) Put(data []byte) error {
+	fields := extractEventFields(data)
+
+	e := &envelope{
+		Type:      s.firehose,
+		ID:        fields.ID,
+		Index:     fields.ModifyIndex,
+		EmittedAt: time.Now().UTC(),
+		Cluster:   s.cluster,
+		Payload:   data,
+	}
+
+	// keep the event as a string if it's not JSON
+	if !json.Valid(data) {
+		e.Payload, _ = json.Marshal(string(data))
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return s.Sink.Put(b)
+}
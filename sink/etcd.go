@@ -0,0 +1,256 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EtcdSink write events to etcd v3, as the latest state per entity key and / or appended to a
+// log prefix, for tools watching etcd
+type EtcdSink struct {
+	client   *clientv3.Client
+	prefix   string
+	firehose string
+	latest   bool
+	append   bool
+	key      *payloadTemplate
+	ttl      time.Duration
+	lease    clientv3.LeaseID
+	leasedAt time.Time
+	sequence uint64
+	retry    *retryPolicy
+	stopCh   chan interface{}
+	putCh    chan []byte
+}
+
+// NewEtcd ...
+func NewEtcd() (*EtcdSink, error) {
+	endpoints := os.Getenv("SINK_ETCD_ENDPOINTS")
+	if endpoints == "" {
+		return nil, fmt.Errorf("[sink/etcd] Missing SINK_ETCD_ENDPOINTS (example: https://10.0.0.1:2379,https://10.0.0.2:2379)")
+	}
+	log.Infof("[sink/etcd] SINK_ETCD_ENDPOINTS=%s", endpoints)
+
+	prefix := os.Getenv("SINK_ETCD_PREFIX")
+	if prefix == "" {
+		prefix = "/nomad-events"
+	}
+	prefix = "/" + strings.Trim(prefix, "/")
+
+	mode := os.Getenv("SINK_ETCD_MODE")
+	if mode == "" {
+		mode = "latest"
+	}
+	if mode != "latest" && mode != "append" && mode != "both" {
+		return nil, fmt.Errorf("[sink/etcd] Invalid SINK_ETCD_MODE value, must be one of: latest, append, both")
+	}
+	log.Infof("[sink/etcd] SINK_ETCD_PREFIX=%s SINK_ETCD_MODE=%s", prefix, mode)
+
+	var key *payloadTemplate
+	if spec := os.Getenv("SINK_ETCD_KEY"); spec != "" {
+		var err error
+		key, err = newPayloadTemplate("key", spec)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/etcd] Invalid SINK_ETCD_KEY: %s", err)
+		}
+	}
+
+	ttl, err := getenvDuration("SINK_ETCD_APPEND_TTL", 24*time.Hour)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/etcd] %s", err)
+	}
+
+	tlsConfig, err := getenvTLSConfig("ETCD", false)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/etcd] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/etcd] %s", err)
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(endpoints, ","),
+		DialTimeout: 10 * time.Second,
+		Username:    os.Getenv("SINK_ETCD_USERNAME"),
+		Password:    os.Getenv("SINK_ETCD_PASSWORD"),
+		TLS:         tlsConfig,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[sink/etcd] Failed to connect: %s", err)
+	}
+
+	return &EtcdSink{
+		client:   client,
+		prefix:   prefix,
+		firehose: os.Getenv("SINK_FIREHOSE"),
+		latest:   mode != "append",
+		append:   mode != "latest",
+		key:      key,
+		ttl:      ttl,
+		retry:    retry,
+		stopCh:   make(chan interface{}),
+		putCh:    make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *EtcdSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *EtcdSink) Stop() {
+	log.Infof("[sink/etcd] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/etcd] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+	s.client.Close()
+}
+
+// setDeadLetterQueue ...
+func (s *EtcdSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *EtcdSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *EtcdSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *EtcdSink) write() {
+	log.Infof("[sink/etcd] Starting writer to '%s/'", s.prefix)
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+
+		case data := <-s.putCh:
+			err := s.retry.Do("etcd", func() error {
+				return s.put(data)
+			})
+			if err != nil {
+				log.Errorf("[sink/etcd] %s", err)
+				s.retry.undeliverable("etcd", data, err)
+			} else {
+				s.retry.delivered(1)
+			}
+		}
+	}
+}
+
+// put the latest state key and / or the log key of an event in a single transaction
+func (s *EtcdSink) put(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var ops []clientv3.Op
+
+	if s.latest {
+		key, err := s.latestKey(data)
+		if err != nil {
+			return permanent(err)
+		}
+		ops = append(ops, clientv3.OpPut(key, string(data)))
+	}
+
+	// log keys sort by time, the etcd revision of the put gives the exact order
+	if s.append {
+		s.sequence++
+		key := fmt.Sprintf("%s/%s/log/%020d-%06d", s.prefix, s.firehose, time.Now().UnixNano(), s.sequence%1000000)
+
+		var options []clientv3.OpOption
+		if s.ttl > 0 {
+			lease, err := s.appendLease(ctx)
+			if err != nil {
+				return err
+			}
+			options = append(options, clientv3.WithLease(lease))
+		}
+
+		ops = append(ops, clientv3.OpPut(key, string(data), options...))
+	}
+
+	if _, err := s.client.Txn(ctx).Then(ops...).Commit(); err != nil {
+		return grpcRetryable(fmt.Errorf("Failed to put event: %w", err))
+	}
+
+	return nil
+}
+
+// appendLease return the lease the log keys expire with, a lease is shared by all the keys
+// appended during a tenth of the TTL instead of creating one per key
+func (s *EtcdSink) appendLease(ctx context.Context) (clientv3.LeaseID, error) {
+	if s.lease != clientv3.NoLease && time.Since(s.leasedAt) < s.ttl/10 {
+		return s.lease, nil
+	}
+
+	lease, err := s.client.Grant(ctx, int64(s.ttl.Seconds()))
+	if err != nil {
+		return clientv3.NoLease, fmt.Errorf("Failed to create lease: %s", err)
+	}
+
+	s.lease = lease.ID
+	s.leasedAt = time.Now()
+
+	return s.lease, nil
+}
+
+// latestKey of the event, <prefix>/<firehose>/[<namespace>/]<id> unless set by the key template
+func (s *EtcdSink) latestKey(data []byte) (string, error) {
+	if s.key != nil {
+		key, err := s.key.Render(data)
+		if err != nil {
+			return "", fmt.Errorf("Could not render key: %s", err)
+		}
+		if key == "" {
+			return "", fmt.Errorf("Empty key for event, skipping it")
+		}
+		return s.prefix + "/" + strings.TrimLeft(key, "/"), nil
+	}
+
+	fields := extractEventFields(data)
+	if fields.ID == "" {
+		return "", fmt.Errorf("No id in event, skipping it")
+	}
+
+	parts := []string{s.prefix, s.firehose}
+	if fields.Namespace != "" {
+		parts = append(parts, fields.Namespace)
+	}
+
+	return strings.Join(append(parts, fields.ID), "/"), nil
+}
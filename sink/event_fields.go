@@ -0,0 +1,82 @@
+package sink
+
+import (
+	"encoding/json"
+)
+
+// eventFields are the common fields of an event, extracted for sinks indexing events
+// (SQL tables, documents, ...)
+type eventFields struct {
+	ID          string
+	Namespace   string
+	ModifyIndex uint64
+	Region      string
+}
+
+// id fields of the different firehose payloads, in order of preference
+var eventIDFields = []string{"ID", "AllocationID", "EvalID", "DeploymentID", "LicenseID", "Key"}
+
+// index fields of the different firehose payloads, in order of preference
+var eventIndexFields = []string{"ModifyIndex", "Index", "JobModifyIndex", "CreateIndex"}
+
+// extractEventFields look for the id, namespace, modify index and region at the top level of the
+// event, or in the objects it wraps (example: {"Type": "register", "Service": {"ID": ...}})
+func extractEventFields(data []byte) eventFields {
+	var event map[string]interface{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return eventFields{}
+	}
+
+	fields := fieldsOf(event)
+
+	for _, value := range event {
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		n := fieldsOf(nested)
+		if fields.ID == "" {
+			fields.ID = n.ID
+		}
+		if fields.Namespace == "" {
+			fields.Namespace = n.Namespace
+		}
+		if fields.ModifyIndex == 0 {
+			fields.ModifyIndex = n.ModifyIndex
+		}
+		if fields.Region == "" {
+			fields.Region = n.Region
+		}
+	}
+
+	return fields
+}
+
+func fieldsOf(object map[string]interface{}) eventFields {
+	var fields eventFields
+
+	for _, name := range eventIDFields {
+		if id, ok := object[name].(string); ok && id != "" {
+			fields.ID = id
+			break
+		}
+	}
+
+	if namespace, ok := object["Namespace"].(string); ok {
+		fields.Namespace = namespace
+	}
+
+	if region, ok := object["Region"].(string); ok {
+		fields.Region = region
+	}
+
+	for _, name := range eventIndexFields {
+		if index, ok := object[name].(float64); ok && index > 0 {
+			fields.ModifyIndex = uint64(index)
+			break
+		}
+	}
+
+	return fields
+}
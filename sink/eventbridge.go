@@ -0,0 +1,228 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	log "github.com/sirupsen/logrus"
+)
+
+// maximum number of entries of a PutEvents call
+const eventBridgeMaxBatchSize = 10
+
+// EventBridgeSink ...
+type EventBridgeSink struct {
+	session       *session.Session
+	eventbridge   *eventbridge.EventBridge
+	eventBus      string
+	source        string
+	detailType    *payloadTemplate
+	batchSize     int
+	flushInterval time.Duration
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+// NewEventBridge ...
+func NewEventBridge() (*EventBridgeSink, error) {
+	eventBus := os.Getenv("SINK_EVENTBRIDGE_BUS")
+	if eventBus == "" {
+		eventBus = "default"
+	}
+	log.Infof("[sink/eventbridge] SINK_EVENTBRIDGE_BUS=%s", eventBus)
+
+	source := os.Getenv("SINK_EVENTBRIDGE_SOURCE")
+	if source == "" {
+		source = "nomad-firehose"
+	}
+
+	detailTypeStr := os.Getenv("SINK_EVENTBRIDGE_DETAIL_TYPE")
+	if detailTypeStr == "" {
+		detailTypeStr = "{{ firehose }}"
+	}
+
+	detailType, err := newPayloadTemplate("detail-type", detailTypeStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/eventbridge] Invalid SINK_EVENTBRIDGE_DETAIL_TYPE: %s", err)
+	}
+
+	batchSize, err := getenvInt("SINK_EVENTBRIDGE_BATCH_SIZE", eventBridgeMaxBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/eventbridge] %s", err)
+	}
+	if batchSize < 1 || batchSize > eventBridgeMaxBatchSize {
+		return nil, fmt.Errorf("[sink/eventbridge] Invalid SINK_EVENTBRIDGE_BATCH_SIZE value, must be between 1 and %d", eventBridgeMaxBatchSize)
+	}
+
+	flushInterval, err := getenvDuration("SINK_EVENTBRIDGE_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/eventbridge] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/eventbridge] %s", err)
+	}
+
+	sess := session.Must(session.NewSession())
+	svc := eventbridge.New(sess)
+
+	return &EventBridgeSink{
+		session:       sess,
+		eventbridge:   svc,
+		eventBus:      eventBus,
+		source:        source,
+		detailType:    detailType,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *EventBridgeSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *EventBridgeSink) Stop() {
+	log.Infof("[sink/eventbridge] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/eventbridge] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer sends the last partial batch when stopping
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+// setDeadLetterQueue ...
+func (s *EventBridgeSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *EventBridgeSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *EventBridgeSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *EventBridgeSink) write() {
+	log.Infof("[sink/eventbridge] Starting writer to event bus '%s'", s.eventBus)
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*eventbridge.PutEventsRequestEntry, 0, s.batchSize)
+
+	for {
+		select {
+		case <-s.stopCh:
+			s.send(batch)
+			return
+
+		case <-ticker.C:
+			s.send(batch)
+			batch = batch[:0]
+
+		case data := <-s.putCh:
+			detailType, err := s.detailType.Render(data)
+			if err != nil {
+				log.Errorf("[sink/eventbridge] Could not render detail type: %s", err)
+				s.retry.undeliverable("eventbridge", data, err)
+				continue
+			}
+
+			batch = append(batch, &eventbridge.PutEventsRequestEntry{
+				EventBusName: aws.String(s.eventBus),
+				Source:       aws.String(s.source),
+				DetailType:   aws.String(detailType),
+				Detail:       aws.String(string(data)),
+			})
+
+			if len(batch) >= s.batchSize {
+				s.send(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// send a batch of entries in a single PutEvents call
+func (s *EventBridgeSink) send(batch []*eventbridge.PutEventsRequestEntry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// the entries failing with a throttling or internal error are sent again
+	err := s.retry.Do("eventbridge", func() error {
+		output, err := s.eventbridge.PutEvents(&eventbridge.PutEventsInput{Entries: batch})
+		if err != nil {
+			return awsRetryable(err)
+		}
+
+		log.Debugf("[sink/eventbridge] Put %d events (%d failed)", len(batch), aws.Int64Value(output.FailedEntryCount))
+
+		// entries may fail individually, the results are in the same order as the request
+		var retry []*eventbridge.PutEventsRequestEntry
+		for i, entry := range output.Entries {
+			if entry.ErrorCode == nil {
+				s.retry.delivered(1)
+				continue
+			}
+
+			entryErr := fmt.Errorf("%s: %s", aws.StringValue(entry.ErrorCode), aws.StringValue(entry.ErrorMessage))
+			switch aws.StringValue(entry.ErrorCode) {
+			case "ThrottlingException", "InternalFailure", "InternalException":
+				retry = append(retry, batch[i])
+			default:
+				log.Errorf("[sink/eventbridge] Failed to put %s event: %s", aws.StringValue(batch[i].DetailType), entryErr)
+				s.retry.undeliverable("eventbridge", []byte(aws.StringValue(batch[i].Detail)), entryErr)
+			}
+		}
+
+		batch = retry
+		if len(batch) > 0 {
+			return fmt.Errorf("%d events were throttled or failed internally", len(batch))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("[sink/eventbridge] Failed to put %d events: %s", len(batch), err)
+		for _, entry := range batch {
+			s.retry.undeliverable("eventbridge", []byte(aws.StringValue(entry.Detail)), err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package sink
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ExecSink write newline delimited JSON to the stdin of a command, restarting it when it exits
+type ExecSink struct {
+	command     string
+	stopTimeout time.Duration
+	cmd         *exec.Cmd
+	stdin       io.WriteCloser
+	exitCh      chan error
+	retry       *retryPolicy
+	stopCh      chan interface{}
+	doneCh      chan interface{}
+	putCh       chan []byte
+}
+
+// NewExec ...
+func NewExec() (*ExecSink, error) {
+	command := os.Getenv("SINK_EXEC_COMMAND")
+	if command == "" {
+		return nil, fmt.Errorf("[sink/exec] Missing SINK_EXEC_COMMAND (example: jq -c 'select(.Type == \"failed\")' >> /tmp/failed.json)")
+	}
+	log.Infof("[sink/exec] SINK_EXEC_COMMAND=%s", command)
+
+	stopTimeout, err := getenvDuration("SINK_EXEC_STOP_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/exec] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/exec] %s", err)
+	}
+
+	return &ExecSink{
+		command:     command,
+		stopTimeout: stopTimeout,
+		retry:       retry,
+		stopCh:      make(chan interface{}),
+		doneCh:      make(chan interface{}),
+		putCh:       make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *ExecSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *ExecSink) Stop() {
+	log.Infof("[sink/exec] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/exec] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer closes the stdin of the command and waits for it to exit
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+// setDeadLetterQueue ...
+func (s *ExecSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *ExecSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *ExecSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *ExecSink) write() {
+	log.Info("[sink/exec] Starting writer")
+	defer close(s.doneCh)
+	defer s.stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+
+		case err := <-s.exitCh:
+			log.Errorf("[sink/exec] Command exited: %v", err)
+			s.cmd, s.stdin, s.exitCh = nil, nil, nil
+
+		case data := <-s.putCh:
+			line := append(append(make([]byte, 0, len(data)+1), data...), '\n')
+
+			// the command is restarted between attempts, the queue buffers the following events
+			err := s.retry.Do("exec", func() error {
+				return s.send(line)
+			})
+			if err != nil {
+				log.Errorf("[sink/exec] %s (%d messages queued)", err, len(s.putCh))
+				s.retry.undeliverable("exec", data, err)
+			} else {
+				s.retry.delivered(1)
+			}
+		}
+	}
+}
+
+// send a line to the command, starting it first if needed
+func (s *ExecSink) send(line []byte) error {
+	if s.cmd == nil {
+		if err := s.start(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.stdin.Write(line); err != nil {
+		s.stop()
+		return err
+	}
+
+	return nil
+}
+
+// start the command, its output is logged
+func (s *ExecSink) start() error {
+	cmd := exec.Command("/bin/sh", "-c", s.command)
+	cmd.Env = os.Environ()
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Failed to start command: %s", err)
+	}
+	log.Infof("[sink/exec] Started command (pid %d)", cmd.Process.Pid)
+
+	go execLogLines("stdout", stdout, log.Info)
+	go execLogLines("stderr", stderr, log.Warn)
+
+	exitCh := make(chan error, 1)
+	go func() {
+		exitCh <- cmd.Wait()
+	}()
+
+	s.cmd, s.stdin, s.exitCh = cmd, stdin, exitCh
+
+	return nil
+}
+
+// stop the command, closing its stdin so it can exit on its own before being killed
+func (s *ExecSink) stop() {
+	if s.cmd == nil {
+		return
+	}
+
+	s.stdin.Close()
+
+	select {
+	case err := <-s.exitCh:
+		log.Infof("[sink/exec] Command exited: %v", err)
+	case <-time.After(s.stopTimeout):
+		log.Warnf("[sink/exec] Command didn't exit after %s, killing it", s.stopTimeout)
+		s.cmd.Process.Kill()
+		<-s.exitCh
+	}
+
+	s.cmd, s.stdin, s.exitCh = nil, nil, nil
+}
+
+// execLogLines log the lines of the output of the command
+func execLogLines(name string, r io.Reader, logf func(args ...interface{})) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		logf("[sink/exec/" + name + "] " + scanner.Text())
+	}
+}
@@ -0,0 +1,199 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FanoutSink deliver every event to several sinks, each with its own queue so a sink being
+// slow or down doesn't hold back the others
+type FanoutSink struct {
+	targets []*fanoutTarget
+	stopCh  chan interface{}
+}
+
+// errFanoutQueueFull is the dead-letter error of the events dropped for a sink
+var errFanoutQueueFull = errors.New("Fan-out queue is full")
+
+// fanoutTarget is one of the sinks of a FanoutSink
+type fanoutTarget struct {
+	name    string
+	sink    Sink
+	queueCh chan []byte
+	doneCh  chan interface{}
+	dropped uint64
+	lock    sync.Mutex
+
+	deadLetter *deadLetterQueue
+
+	// the events are acknowledged by the sink, otherwise once it got them
+	acks   *ackTracker
+	acking bool
+}
+
+// NewFanout ...
+func NewFanout(sinkTypes []string, region string) (*FanoutSink, error) {
+	buffer, err := getenvInt("SINK_FANOUT_BUFFER", 10000)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/fanout] %s", err)
+	}
+
+	s := &FanoutSink{stopCh: make(chan interface{})}
+	seen := make(map[string]bool)
+
+	for _, sinkType := range sinkTypes {
+		sinkType = strings.TrimSpace(sinkType)
+		if sinkType == "" {
+			continue
+		}
+
+		// each sink type has a single configuration
+		if seen[sinkType] {
+			return nil, fmt.Errorf("[sink/fanout] Duplicate sink %s in SINK_TYPE", sinkType)
+		}
+		seen[sinkType] = true
+
+		target, err := newSink(sinkType, region)
+		if err != nil {
+			return nil, err
+		}
+
+		s.targets = append(s.targets, &fanoutTarget{
+			name:    sinkType,
+			sink:    target,
+			queueCh: make(chan []byte, buffer),
+			doneCh:  make(chan interface{}),
+		})
+	}
+
+	if len(s.targets) == 0 {
+		return nil, fmt.Errorf("[sink/fanout] No sink in SINK_TYPE")
+	}
+	log.Infof("[sink/fanout] Delivering to %d sinks", len(s.targets))
+
+	return s, nil
+}
+
+// Start ...
+func (s *FanoutSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	for _, target := range s.targets {
+		go func(target *fanoutTarget) {
+			if err := target.sink.Start(); err != nil {
+				log.Errorf("[sink/fanout/%s] %s", target.name, err)
+			}
+		}(target)
+
+		go target.forward()
+	}
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop all sinks in parallel, after they got all the events queued for them
+func (s *FanoutSink) Stop() {
+	var wg sync.WaitGroup
+
+	for _, target := range s.targets {
+		wg.Add(1)
+
+		go func(target *fanoutTarget) {
+			defer wg.Done()
+
+			log.Infof("[sink/fanout/%s] ensure queue is empty (%d messages left)", target.name, len(target.queueCh))
+			close(target.queueCh)
+			<-target.doneCh
+
+			target.sink.Stop()
+		}(target)
+	}
+
+	wg.Wait()
+	close(s.stopCh)
+}
+
+// setDeadLetterQueue of the sinks, events dropped for a sink go there too
+func (s *FanoutSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	for _, target := range s.targets {
+		target.deadLetter = queue
+
+		if d, ok := target.sink.(deadLettering); ok {
+			d.setDeadLetterQueue(queue)
+		}
+	}
+}
+
+// setAckTracker of the sinks, an event being acknowledged once every sink acknowledged it
+func (s *FanoutSink) setAckTracker(acks *ackTracker) {
+	acks.perEvent = len(s.targets)
+
+	for _, target := range s.targets {
+		target.acks = acks
+
+		if a, ok := target.sink.(acknowledging); ok {
+			a.setAckTracker(acks)
+			target.acking = true
+		}
+	}
+}
+
+// Put queue the event for every sink, dropping it for sinks whose queue is full
+func (s *FanoutSink) Put(data []byte) error {
+	for _, target := range s.targets {
+		select {
+		case target.queueCh <- data:
+		default:
+			target.drop(data)
+		}
+	}
+
+	return nil
+}
+
+// forward the queued events to the sink, a sink blocking on Put only holds back its own queue
+func (t *fanoutTarget) forward() {
+	defer close(t.doneCh)
+
+	for data := range t.queueCh {
+		if err := t.sink.Put(data); err != nil {
+			log.Errorf("[sink/fanout/%s] %s", t.name, err)
+			t.acks.Lose(1)
+		} else if !t.acking {
+			t.acks.Ack(1)
+		}
+	}
+}
+
+// drop an event, sending it to the dead-letter queue, or losing it without one, and logging the
+// first and then every 1000th dropped event
+func (t *fanoutTarget) drop(data []byte) {
+	if t.deadLetter != nil {
+		t.deadLetter.Put(t.name, data, errFanoutQueueFull)
+		t.acks.Ack(1)
+	} else {
+		t.acks.Lose(1)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.dropped++
+	if t.dropped == 1 || t.dropped%1000 == 0 {
+		log.Errorf("[sink/fanout/%s] Queue is full, %d events dropped so far", t.name, t.dropped)
+	}
+}
@@ -0,0 +1,165 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileSink append newline delimited JSON to a file, rotated by size and time
+type FileSink struct {
+	logger *lumberjack.Logger
+	maxAge time.Duration
+	retry  *retryPolicy
+	stopCh chan interface{}
+	doneCh chan interface{}
+	putCh  chan []byte
+}
+
+// NewFile create a file sink, the region being added to SINK_FILE_PATH
+// (example: events.us-east-1.json) so the firehoses of several regions don't share a file
+func NewFile(region string) (*FileSink, error) {
+	path := os.Getenv("SINK_FILE_PATH")
+	if path == "" {
+		return nil, fmt.Errorf("[sink/file] Missing SINK_FILE_PATH (example: /var/log/nomad-firehose/events.json)")
+	}
+	if region != "" {
+		ext := filepath.Ext(path)
+		path = strings.TrimSuffix(path, ext) + "." + region + ext
+	}
+	log.Infof("[sink/file] SINK_FILE_PATH=%s", path)
+
+	maxSize, err := getenvInt("SINK_FILE_MAX_SIZE", 100)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/file] %s", err)
+	}
+
+	maxAge, err := getenvDuration("SINK_FILE_ROTATE_INTERVAL", 24*time.Hour)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/file] %s", err)
+	}
+
+	retention, err := getenvInt("SINK_FILE_RETENTION", 7)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/file] %s", err)
+	}
+
+	compress, err := getenvBool("SINK_FILE_COMPRESS", true)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/file] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/file] %s", err)
+	}
+
+	return &FileSink{
+		logger: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    maxSize,
+			MaxBackups: retention,
+			Compress:   compress,
+		},
+		maxAge: maxAge,
+		retry:  retry,
+		stopCh: make(chan interface{}),
+		doneCh: make(chan interface{}),
+		putCh:  make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *FileSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *FileSink) Stop() {
+	log.Infof("[sink/file] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/file] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+// setDeadLetterQueue ...
+func (s *FileSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *FileSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *FileSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *FileSink) write() {
+	log.Infof("[sink/file] Starting writer to '%s'", s.logger.Filename)
+	defer close(s.doneCh)
+	defer s.logger.Close()
+
+	// size based rotation is done by the logger, time based rotation here
+	var rotateCh <-chan time.Time
+	if s.maxAge > 0 {
+		ticker := time.NewTicker(s.maxAge)
+		defer ticker.Stop()
+		rotateCh = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+
+		case <-rotateCh:
+			if err := s.logger.Rotate(); err != nil {
+				log.Errorf("[sink/file] Failed to rotate: %s", err)
+			}
+
+		case data := <-s.putCh:
+			line := append(append(make([]byte, 0, len(data)+1), data...), '\n')
+
+			// a full disk may be freed meanwhile
+			err := s.retry.Do("file", func() error {
+				_, err := s.logger.Write(line)
+				return err
+			})
+			if err != nil {
+				log.Errorf("[sink/file] %s", err)
+				s.retry.undeliverable("file", data, err)
+			} else {
+				s.retry.delivered(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fluent/fluent-logger-golang/fluent"
+	log "github.com/sirupsen/logrus"
+)
+
+// FluentdSink forward events to fluentd / fluent-bit with the forward protocol, waiting for
+// the ack of each message
+type FluentdSink struct {
+	logger *fluent.Fluent
+	addr   string
+	tag    *payloadTemplate
+	retry  *retryPolicy
+	stopCh chan interface{}
+	putCh  chan []byte
+}
+
+// NewFluentd ...
+func NewFluentd() (*FluentdSink, error) {
+	addrStr := os.Getenv("SINK_FLUENTD_ADDR")
+	if addrStr == "" {
+		return nil, fmt.Errorf("[sink/fluentd] Missing SINK_FLUENTD_ADDR (example: tcp://127.0.0.1:24224 or unix:///var/run/fluentd.sock)")
+	}
+	log.Infof("[sink/fluentd] SINK_FLUENTD_ADDR=%s", addrStr)
+
+	u, err := url.Parse(addrStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/fluentd] Invalid SINK_FLUENTD_ADDR: %s", err)
+	}
+
+	tagName := os.Getenv("SINK_FLUENTD_TAG")
+	if tagName == "" {
+		tagName = "nomad.{{ firehose }}"
+	}
+	log.Infof("[sink/fluentd] SINK_FLUENTD_TAG=%s", tagName)
+
+	tag, err := newPayloadTemplate("tag", tagName)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/fluentd] Invalid SINK_FLUENTD_TAG: %s", err)
+	}
+
+	requestAck, err := getenvBool("SINK_FLUENTD_REQUIRE_ACK", true)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/fluentd] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/fluentd] %s", err)
+	}
+
+	// a single connection attempt per post, posts are retried by the retry policy
+	config := fluent.Config{
+		Timeout:      10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		RequestAck:   requestAck,
+		MaxRetry:     1,
+	}
+
+	switch u.Scheme {
+	case "tcp":
+		host, port, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/fluentd] Invalid SINK_FLUENTD_ADDR: %s", err)
+		}
+
+		config.FluentNetwork = "tcp"
+		config.FluentHost = host
+		config.FluentPort, err = strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/fluentd] Invalid SINK_FLUENTD_ADDR port: %s", port)
+		}
+	case "unix":
+		config.FluentNetwork = "unix"
+		config.FluentSocketPath = u.Path
+	default:
+		return nil, fmt.Errorf("[sink/fluentd] Invalid SINK_FLUENTD_ADDR scheme: %s, Valid values: tcp or unix", u.Scheme)
+	}
+
+	logger, err := fluent.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/fluentd] Failed to connect: %s", err)
+	}
+
+	return &FluentdSink{
+		logger: logger,
+		addr:   addrStr,
+		tag:    tag,
+		retry:  retry,
+		stopCh: make(chan interface{}),
+		putCh:  make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *FluentdSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *FluentdSink) Stop() {
+	log.Infof("[sink/fluentd] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/fluentd] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+	s.logger.Close()
+}
+
+// setDeadLetterQueue ...
+func (s *FluentdSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *FluentdSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *FluentdSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *FluentdSink) write() {
+	log.Infof("[sink/fluentd] Starting writer to %s", s.addr)
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+
+		case data := <-s.putCh:
+			tag, err := s.tag.Render(data)
+			if err != nil {
+				log.Errorf("[sink/fluentd] Could not render tag: %s", err)
+				s.retry.undeliverable("fluentd", data, err)
+				continue
+			}
+
+			// the record is the event itself, encoded as msgpack by the logger
+			var record map[string]interface{}
+			if err := json.Unmarshal(data, &record); err != nil {
+				log.Errorf("[sink/fluentd] %s", err)
+				s.retry.undeliverable("fluentd", data, err)
+				continue
+			}
+
+			err = s.retry.Do("fluentd", func() error {
+				return s.logger.PostWithTime(tag, time.Now(), record)
+			})
+			if err != nil {
+				log.Errorf("[sink/fluentd] %s", err)
+				s.retry.undeliverable("fluentd", data, err)
+			} else {
+				s.retry.delivered(1)
+			}
+		}
+	}
+}
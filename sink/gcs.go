@@ -0,0 +1,69 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"cloud.google.com/go/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// GCSSink ...
+type GCSSink struct {
+	*objectBatcher
+
+	client *storage.Client
+	bucket *storage.BucketHandle
+}
+
+// NewGCS ...
+func NewGCS() (*GCSSink, error) {
+	bucket := os.Getenv("SINK_GCS_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("[sink/gcs] Missing SINK_GCS_BUCKET (example: my-nomad-archive)")
+	}
+	log.Infof("[sink/gcs] SINK_GCS_BUCKET=%s", bucket)
+
+	// credentials are resolved through Application Default Credentials
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("[sink/gcs] Failed to create storage client: %s", err)
+	}
+
+	s := &GCSSink{
+		client: client,
+		bucket: client.Bucket(bucket),
+	}
+
+	batcher, err := newObjectBatcher("gcs", "GCS", s.upload)
+	if err != nil {
+		return nil, err
+	}
+	s.objectBatcher = batcher
+
+	return s, nil
+}
+
+// Stop ...
+func (s *GCSSink) Stop() {
+	s.objectBatcher.Stop()
+	s.client.Close()
+}
+
+func (s *GCSSink) upload(key string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	w := s.bucket.Object(key).NewWriter(ctx)
+	w.ContentType = s.contentType()
+	w.ContentEncoding = s.contentEncoding()
+
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return googleapiRetryable(err)
+	}
+
+	return googleapiRetryable(w.Close())
+}
@@ -0,0 +1,310 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GELF chunking limits
+const (
+	gelfChunkHeaderSize = 12
+	gelfMaxChunks       = 128
+)
+
+// GELFSink send events to Graylog as GELF messages over UDP or TCP
+type GELFSink struct {
+	protocol     string
+	addr         string
+	compression  string
+	chunkSize    int
+	host         string
+	shortMessage *payloadTemplate
+	conn         net.Conn
+	retry        *retryPolicy
+	stopCh       chan interface{}
+	putCh        chan []byte
+}
+
+// NewGELF ...
+func NewGELF() (*GELFSink, error) {
+	addr := os.Getenv("SINK_GELF_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("[sink/gelf] Missing SINK_GELF_ADDR (example: graylog:12201)")
+	}
+
+	protocol := os.Getenv("SINK_GELF_PROTOCOL")
+	switch protocol {
+	case "":
+		protocol = "udp"
+	case "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("[sink/gelf] Invalid SINK_GELF_PROTOCOL: %s, Valid values: udp or tcp", protocol)
+	}
+	log.Infof("[sink/gelf] Sending to %s://%s", protocol, addr)
+
+	// GELF over TCP doesn't support compression
+	compression := os.Getenv("SINK_GELF_COMPRESSION")
+	switch compression {
+	case "":
+		compression = "gzip"
+	case "gzip", "zlib", "none":
+	default:
+		return nil, fmt.Errorf("[sink/gelf] Invalid SINK_GELF_COMPRESSION: %s, Valid values: gzip, zlib or none", compression)
+	}
+
+	chunkSize, err := getenvInt("SINK_GELF_CHUNK_SIZE", 1420)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/gelf] %s", err)
+	}
+	if chunkSize <= gelfChunkHeaderSize {
+		return nil, fmt.Errorf("[sink/gelf] Invalid SINK_GELF_CHUNK_SIZE value, must be larger than %d", gelfChunkHeaderSize)
+	}
+
+	shortMessageStr := os.Getenv("SINK_GELF_SHORT_MESSAGE")
+	if shortMessageStr == "" {
+		shortMessageStr = "nomad {{ firehose }} {{ .Type }}"
+	}
+
+	shortMessage, err := newPayloadTemplate("short-message", shortMessageStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/gelf] Invalid SINK_GELF_SHORT_MESSAGE: %s", err)
+	}
+
+	host := os.Getenv("SINK_GELF_HOST")
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/gelf] %s", err)
+	}
+
+	s := &GELFSink{
+		protocol:     protocol,
+		addr:         addr,
+		compression:  compression,
+		chunkSize:    chunkSize,
+		host:         host,
+		shortMessage: shortMessage,
+		retry:        retry,
+		stopCh:       make(chan interface{}),
+		putCh:        make(chan []byte, 1000),
+	}
+
+	if err := s.connect(); err != nil {
+		return nil, fmt.Errorf("[sink/gelf] Failed to connect to %s: %s", addr, err)
+	}
+
+	return s, nil
+}
+
+func (s *GELFSink) connect() error {
+	conn, err := net.DialTimeout(s.protocol, s.addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	s.conn = conn
+	return nil
+}
+
+// Start ...
+func (s *GELFSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *GELFSink) Stop() {
+	log.Infof("[sink/gelf] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/gelf] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// setDeadLetterQueue ...
+func (s *GELFSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *GELFSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *GELFSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *GELFSink) write() {
+	log.Info("[sink/gelf] Starting writer")
+
+	for {
+		select {
+		case data := <-s.putCh:
+			message, err := s.message(data)
+			if err != nil {
+				log.Errorf("[sink/gelf] %s", err)
+				s.retry.undeliverable("gelf", data, err)
+				continue
+			}
+
+			err = s.retry.Do("gelf", func() error {
+				if s.protocol == "tcp" {
+					return s.sendTCP(message)
+				}
+				return s.sendUDP(message)
+			})
+			if err != nil {
+				log.Errorf("[sink/gelf] %s", err)
+				s.retry.undeliverable("gelf", data, err)
+			} else {
+				log.Debugf("[sink/gelf] Sent message (%d bytes)", len(message))
+				s.retry.delivered(1)
+			}
+		}
+	}
+}
+
+// message build the GELF message of an event, with its top level scalar fields as additional fields
+func (s *GELFSink) message(data []byte) ([]byte, error) {
+	shortMessage, err := s.shortMessage.Render(data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not render short message: %s", err)
+	}
+
+	message := map[string]interface{}{
+		"version":       "1.1",
+		"host":          s.host,
+		"short_message": shortMessage,
+		"full_message":  string(data),
+		"timestamp":     float64(time.Now().UnixNano()) / float64(time.Second),
+		"level":         6,
+		"_firehose":     os.Getenv("SINK_FIREHOSE"),
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal(data, &event); err == nil {
+		for key, value := range event {
+			switch value.(type) {
+			case string, float64, bool:
+			default:
+				continue
+			}
+
+			// _id is reserved
+			if strings.ToLower(key) == "id" {
+				key = "event_id"
+			}
+			message["_"+key] = value
+		}
+	}
+
+	return json.Marshal(message)
+}
+
+// sendTCP send a null byte delimited, uncompressed, message, reconnecting on failure
+func (s *GELFSink) sendTCP(message []byte) error {
+	message = append(message, 0)
+
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.conn.Write(message); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+// sendUDP send a compressed message, chunked when it doesn't fit in a single datagram
+func (s *GELFSink) sendUDP(message []byte) error {
+	var buf bytes.Buffer
+
+	switch s.compression {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		w.Write(message)
+		w.Close()
+		message = buf.Bytes()
+	case "zlib":
+		w := zlib.NewWriter(&buf)
+		w.Write(message)
+		w.Close()
+		message = buf.Bytes()
+	}
+
+	if len(message) <= s.chunkSize {
+		_, err := s.conn.Write(message)
+		return err
+	}
+
+	size := s.chunkSize - gelfChunkHeaderSize
+	count := (len(message) + size - 1) / size
+	if count > gelfMaxChunks {
+		return permanent(fmt.Errorf("Message too large (%d bytes) for %d chunks", len(message), gelfMaxChunks))
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(message) {
+			end = len(message)
+		}
+
+		chunk := make([]byte, 0, gelfChunkHeaderSize+end-i*size)
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, message[i*size:end]...)
+
+		if _, err := s.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
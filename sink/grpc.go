@@ -0,0 +1,293 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// full name of the client streaming method defined in proto/firehose.proto
+const grpcPublishEventsMethod = "/nomad_firehose.v1.Firehose/PublishEvents"
+
+// grpcRawCodec send already encoded protobuf messages, so the sink doesn't need generated code
+type grpcRawCodec struct{}
+
+func (grpcRawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected message type %T", v)
+	}
+	return b, nil
+}
+
+func (grpcRawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("Unexpected message type %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (grpcRawCodec) Name() string {
+	return "proto"
+}
+
+// GRPCSink stream events to a gRPC service implementing proto/firehose.proto
+type GRPCSink struct {
+	conn     *grpc.ClientConn
+	stream   grpc.ClientStream
+	cancel   context.CancelFunc
+	firehose string
+	retry    *retryPolicy
+	stopCh   chan interface{}
+	doneCh   chan interface{}
+	putCh    chan []byte
+}
+
+// NewGRPC ...
+func NewGRPC() (*GRPCSink, error) {
+	addr := os.Getenv("SINK_GRPC_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("[sink/grpc] Missing SINK_GRPC_ADDR (example: events.service.consul:9090)")
+	}
+	log.Infof("[sink/grpc] SINK_GRPC_ADDR=%s", addr)
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/grpc] %s", err)
+	}
+	if err := retry.maxRetriesFrom("SINK_GRPC_MAX_RETRIES"); err != nil {
+		return nil, fmt.Errorf("[sink/grpc] %s", err)
+	}
+
+	transport, err := grpcTransportCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/grpc] %s", err)
+	}
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(transport))
+	if err != nil {
+		return nil, fmt.Errorf("[sink/grpc] Failed to create client: %s", err)
+	}
+
+	return &GRPCSink{
+		conn:     conn,
+		firehose: os.Getenv("SINK_FIREHOSE"),
+		retry:    retry,
+		stopCh:   make(chan interface{}),
+		doneCh:   make(chan interface{}),
+		putCh:    make(chan []byte, 1000),
+	}, nil
+}
+
+// grpcTransportCredentials configure TLS from SINK_GRPC_TLS, SINK_GRPC_TLS_CA, SINK_GRPC_TLS_CERT,
+// SINK_GRPC_TLS_KEY and SINK_GRPC_TLS_SERVER_NAME
+func grpcTransportCredentials() (credentials.TransportCredentials, error) {
+	config, err := getenvTLSConfig("GRPC", false)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return insecure.NewCredentials(), nil
+	}
+
+	return credentials.NewTLS(config), nil
+}
+
+// Start ...
+func (s *GRPCSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *GRPCSink) Stop() {
+	log.Infof("[sink/grpc] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/grpc] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+	<-s.doneCh
+
+	s.conn.Close()
+}
+
+// setDeadLetterQueue ...
+func (s *GRPCSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *GRPCSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *GRPCSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *GRPCSink) write() {
+	log.Info("[sink/grpc] Starting writer")
+	defer close(s.doneCh)
+
+	for {
+		select {
+		case <-s.stopCh:
+			s.closeStream()
+			return
+
+		case data := <-s.putCh:
+			err := s.retry.Do("grpc", func() error {
+				return s.publish(s.event(data))
+			})
+			if err != nil {
+				log.Errorf("[sink/grpc] Giving up publishing event: %s", err)
+				s.retry.undeliverable("grpc", data, err)
+			} else {
+				log.Debugf("[sink/grpc] Published event")
+				s.retry.delivered(1)
+			}
+		}
+	}
+}
+
+// publish an event on the stream, the broken stream being opened again by the next attempt
+func (s *GRPCSink) publish(event []byte) error {
+	err := s.openStream()
+	if err == nil {
+		err = s.stream.SendMsg(event)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	// the stream is broken, the actual error is returned by RecvMsg
+	if s.stream != nil {
+		var resp []byte
+		if recvErr := s.stream.RecvMsg(&resp); recvErr != nil {
+			err = recvErr
+		}
+		s.cancel()
+		s.stream = nil
+	}
+
+	return grpcRetryable(err)
+}
+
+func (s *GRPCSink) openStream() error {
+	if s.stream != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream, err := s.conn.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true}, grpcPublishEventsMethod, grpc.ForceCodec(grpcRawCodec{}))
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	s.stream = stream
+	s.cancel = cancel
+	return nil
+}
+
+// closeStream half close the stream and wait for the response
+func (s *GRPCSink) closeStream() {
+	if s.stream == nil {
+		return
+	}
+	defer s.cancel()
+
+	if err := s.stream.CloseSend(); err != nil {
+		log.Errorf("[sink/grpc] %s", err)
+		return
+	}
+
+	var resp []byte
+	if err := s.stream.RecvMsg(&resp); err != nil {
+		log.Errorf("[sink/grpc] %s", err)
+		return
+	}
+
+	received, _ := grpcReceived(resp)
+	log.Infof("[sink/grpc] Stream closed, %d events received by the server", received)
+}
+
+// event encode an Event message of proto/firehose.proto
+func (s *GRPCSink) event(data []byte) []byte {
+	fields := extractEventFields(data)
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, s.firehose)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, fields.Region)
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendString(b, fields.ID)
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	b = protowire.AppendString(b, fields.Namespace)
+	b = protowire.AppendTag(b, 5, protowire.VarintType)
+	b = protowire.AppendVarint(b, fields.ModifyIndex)
+	b = protowire.AppendTag(b, 6, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(time.Now().UnixNano()))
+	b = protowire.AppendTag(b, 7, protowire.BytesType)
+	b = protowire.AppendBytes(b, data)
+
+	return b
+}
+
+// grpcReceived decode the received field of a PublishEventsResponse message
+func grpcReceived(b []byte) (uint64, error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if num == 1 && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return 0, protowire.ParseError(n)
+			}
+			return v, nil
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+
+	return 0, nil
+}
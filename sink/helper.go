@@ -3,15 +3,119 @@ package sink
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
-// GetSink ...
-func GetSink() (Sink, error) {
+// GetSink create the sink of a firehose watching region, empty when watching the region of
+// the nomad agent. The events are tagged with the region, or SINK_REGION
+func GetSink(region string) (Sink, error) {
+	sink, err := getSink(region)
+	if err != nil {
+		return nil, err
+	}
+
+	// behind the queue, storing oversized events doesn't hold back the firehose
+	maxBytes, err := getenvInt("SINK_MAX_PAYLOAD_BYTES", 0)
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes > 0 {
+		sink, err = NewClaimCheck(sink, maxBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if deadLetterType := os.Getenv("SINK_DEAD_LETTER_TYPE"); deadLetterType != "" {
+		sink, err = NewDeadLetter(sink, deadLetterType, region)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sink, err = NewQueue(sink)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := getenvFloat("SINK_RATE_LIMIT", 0)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 {
+		sink, err = NewRateLimit(sink, limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tag := region
+	if tag == "" {
+		tag = os.Getenv("SINK_REGION")
+	}
+
+	if os.Getenv("SINK_ENVELOPE") == "true" {
+		sink, err = NewEnvelope(sink, tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if os.Getenv("SINK_DEDUP") == "true" {
+		sink, err = NewDedup(sink)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if tag != "" {
+		return NewRegion(sink, tag)
+	}
+
+	return sink, nil
+}
+
+// ValidateRegions refuse the sinks which can only exist once in a process when watching several
+// regions, every region having a sink of its own: websocket and zeromq listen on an address, and
+// the firehoses of the regions would lock each other out of a sqlite database
+func ValidateRegions() error {
+	sinks := map[string]string{"SINK_TYPE": "SINK_", "SINK_DEAD_LETTER_TYPE": "SINK_DEAD_LETTER_"}
+
+	for name, prefix := range sinks {
+		for _, sinkType := range strings.Split(os.Getenv(name), ",") {
+			switch sinkType = strings.TrimSpace(sinkType); sinkType {
+			case "websocket", "sqlite":
+				return fmt.Errorf("%s %s can't be used with several regions, run a firehose per region", name, sinkType)
+			case "zeromq":
+				connect, err := getenvBool(prefix+"ZEROMQ_CONNECT", false)
+				if err != nil {
+					return err
+				}
+				if !connect {
+					return fmt.Errorf("%s zeromq binds %sZEROMQ_ADDR and can't be used with several regions, set %sZEROMQ_CONNECT=true or run a firehose per region", name, prefix, prefix)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func getSink(region string) (Sink, error) {
 	sinkType := os.Getenv("SINK_TYPE")
 	if sinkType == "" {
-		return nil, fmt.Errorf("Missing SINK_TYPE: amqp, kafka, kinesis, nsq, rabbitmq, redis or stdout")
+		return nil, fmt.Errorf("Missing SINK_TYPE: amqp, amqp1, azblob, bigquery, cassandra, clickhouse, consul-kv, datadog, dynamodb, elasticsearch, etcd, eventbridge, exec, file, fluentd, gcs, gelf, grpc, http, influxdb, kafka, kinesis, kinesis-firehose, loki, mongodb, mqtt, mysql, nats, nomad-dispatch, nsq, null, otlp, pagerduty, plugin, postgres, pubsub, pulsar, rabbitmq, redis, redis-pubsub, s3, servicebus, slack, sns, socket, sqlite, sqs, stdout, websocket or zeromq")
 	}
 
+	// several sinks, example: kafka,s3
+	if strings.Contains(sinkType, ",") {
+		return NewFanout(strings.Split(sinkType, ","), region)
+	}
+
+	return newSink(sinkType, region)
+}
+
+func newSink(sinkType, region string) (Sink, error) {
 	switch sinkType {
 	case "amqp":
 		return NewRabbitmq()
@@ -25,9 +129,95 @@ func GetSink() (Sink, error) {
 		return NewNSQ()
 	case "redis":
 		return NewRedis()
+	case "nats":
+		return NewNATS()
+	case "pubsub":
+		return NewPubSub()
+	case "servicebus":
+		return NewServiceBus()
+	case "redis-pubsub":
+		return NewRedisPubSub()
+	case "pulsar":
+		return NewPulsar()
+	case "mqtt":
+		return NewMQTT()
+	case "sns":
+		return NewSNS()
+	case "eventbridge":
+		return NewEventBridge()
+	case "kinesis-firehose":
+		return NewKinesisFirehose()
+	case "s3":
+		return NewS3()
+	case "gcs":
+		return NewGCS()
+	case "azblob":
+		return NewAzureBlob()
+	case "sqs":
+		return NewSQS()
+	case "postgres":
+		return NewPostgres()
+	case "mysql":
+		return NewMySQL()
+	case "clickhouse":
+		return NewClickHouse()
+	case "elasticsearch":
+		return NewElasticsearch()
+	case "bigquery":
+		return NewBigQuery()
+	case "influxdb":
+		return NewInfluxDB()
+	case "loki":
+		return NewLoki()
+	case "datadog":
+		return NewDatadog()
+	case "gelf":
+		return NewGELF()
+	case "http":
+		return NewHTTP()
+	case "grpc":
+		return NewGRPC()
+	case "websocket":
+		return NewWebSocket()
+	case "socket":
+		return NewSocket()
+	case "file":
+		return NewFile(region)
+	case "mongodb":
+		return NewMongoDB()
+	case "cassandra":
+		return NewCassandra()
+	case "dynamodb":
+		return NewDynamoDB()
+	case "sqlite":
+		return NewSQLite()
+	case "zeromq":
+		return NewZeroMQ()
+	case "amqp1":
+		return NewAMQP1()
+	case "slack":
+		return NewSlack()
+	case "pagerduty":
+		return NewPagerDuty()
+	case "consul-kv":
+		return NewConsulKV()
+	case "etcd":
+		return NewEtcd()
+	case "fluentd":
+		return NewFluentd()
+	case "otlp":
+		return NewOTLP()
+	case "exec":
+		return NewExec()
+	case "nomad-dispatch":
+		return NewNomadDispatch(region)
+	case "plugin":
+		return NewPlugin()
 	case "stdout":
 		return NewStdout()
+	case "null":
+		return NewNull()
 	default:
-		return nil, fmt.Errorf("Invalid SINK_TYPE: %s, Valid values: amqp, kafka, kinesis, nsq, rabbitmq, redis or stdout", sinkType)
+		return nil, fmt.Errorf("Invalid SINK_TYPE: %s, Valid values: amqp, amqp1, azblob, bigquery, cassandra, clickhouse, consul-kv, datadog, dynamodb, elasticsearch, etcd, eventbridge, exec, file, fluentd, gcs, gelf, grpc, http, influxdb, kafka, kinesis, kinesis-firehose, loki, mongodb, mqtt, mysql, nats, nomad-dispatch, nsq, null, otlp, pagerduty, plugin, postgres, pubsub, pulsar, rabbitmq, redis, redis-pubsub, s3, servicebus, slack, sns, socket, sqlite, sqs, stdout, websocket or zeromq", sinkType)
 	}
 }
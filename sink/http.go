@@ -0,0 +1,251 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HTTPSink send events, one by one or batched as a JSON array, to a webhook
+type HTTPSink struct {
+	client      *http.Client
+	url         string
+	method      string
+	headers     map[string]*payloadTemplate
+	secret      []byte
+	metadata    bool
+	batcher     *eventBatcher
+	retry       *retryPolicy
+	concurrency int
+	senders     sync.WaitGroup
+	stopCh      chan interface{}
+	doneCh      chan interface{}
+	putCh       chan []byte
+	sendCh      chan [][]byte
+}
+
+// NewHTTP ...
+func NewHTTP() (*HTTPSink, error) {
+	url := os.Getenv("SINK_HTTP_URL")
+	if url == "" {
+		return nil, fmt.Errorf("[sink/http] Missing SINK_HTTP_URL (example: https://example.com/nomad-events)")
+	}
+	log.Infof("[sink/http] SINK_HTTP_URL=%s", url)
+
+	method := os.Getenv("SINK_HTTP_METHOD")
+	if method == "" {
+		method = "POST"
+	}
+
+	headers, err := newPayloadTemplates(os.Getenv("SINK_HTTP_HEADERS"))
+	if err != nil {
+		return nil, fmt.Errorf("[sink/http] Invalid SINK_HTTP_HEADERS: %s", err)
+	}
+
+	metadata, err := metadataEnabled()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/http] %s", err)
+	}
+
+	batcher, err := newEventBatcher("HTTP", 1, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/http] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/http] %s", err)
+	}
+
+	if err := retry.maxRetriesFrom("SINK_HTTP_MAX_RETRIES"); err != nil {
+		return nil, fmt.Errorf("[sink/http] %s", err)
+	}
+
+	concurrency, err := getenvInt("SINK_HTTP_CONCURRENCY", 4)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/http] %s", err)
+	}
+	if concurrency < 1 {
+		return nil, fmt.Errorf("[sink/http] Invalid SINK_HTTP_CONCURRENCY value, must be positive")
+	}
+
+	timeout, err := getenvDuration("SINK_HTTP_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/http] %s", err)
+	}
+
+	return &HTTPSink{
+		client:      &http.Client{Timeout: timeout},
+		url:         url,
+		method:      method,
+		headers:     headers,
+		secret:      []byte(os.Getenv("SINK_HTTP_HMAC_SECRET")),
+		metadata:    metadata,
+		batcher:     batcher,
+		retry:       retry,
+		concurrency: concurrency,
+		stopCh:      make(chan interface{}),
+		doneCh:      make(chan interface{}),
+		putCh:       make(chan []byte, 1000),
+		sendCh:      make(chan [][]byte),
+	}, nil
+}
+
+// Start ...
+func (s *HTTPSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	// one writer batching events, and at most concurrency requests in flight
+	go s.write()
+
+	for i := 0; i < s.concurrency; i++ {
+		s.senders.Add(1)
+		go s.send(i)
+	}
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *HTTPSink) Stop() {
+	log.Infof("[sink/http] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/http] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer sends the last partial batch, then the senders finish the requests in flight
+	close(s.stopCh)
+	<-s.doneCh
+	s.senders.Wait()
+}
+
+// setDeadLetterQueue ...
+func (s *HTTPSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *HTTPSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *HTTPSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *HTTPSink) write() {
+	log.Info("[sink/http] Starting writer")
+	defer close(s.doneCh)
+	defer close(s.sendCh)
+
+	s.batcher.Run(s.putCh, s.stopCh, func(batch [][]byte) {
+		s.sendCh <- batch
+	})
+}
+
+func (s *HTTPSink) send(id int) {
+	log.Infof("[sink/http/%d] Starting sender", id)
+	defer s.senders.Done()
+
+	for batch := range s.sendCh {
+		// a single event is sent as-is, batches as a JSON array
+		body := batch[0]
+		if s.batcher.maxSize > 1 {
+			body = append([]byte("["), bytes.Join(batch, []byte(","))...)
+			body = append(body, ']')
+		}
+
+		err := s.retry.Do("http", func() error {
+			return s.request(body, batch[0])
+		})
+		if err != nil {
+			log.Errorf("[sink/http/%d] Failed to send %d events: %s", id, len(batch), err)
+			for _, data := range batch {
+				s.retry.undeliverable("http", data, err)
+			}
+			continue
+		}
+
+		log.Debugf("[sink/http/%d] Sent %d events", id, len(batch))
+		s.retry.delivered(len(batch))
+	}
+}
+
+// request send a body, the errors sending it again won't fix being permanent. Header templates are
+// rendered with the first event of the body, and batches only have the metadata the events share
+func (s *HTTPSink) request(body, first []byte) error {
+	req, err := http.NewRequest(s.method, s.url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "nomad-firehose")
+
+	if s.metadata {
+		metadata := newBatchMetadata()
+		if s.batcher.maxSize == 1 {
+			metadata = newEventMetadata(first)
+		}
+		for name, value := range metadata.Values() {
+			req.Header.Set(httpMetadataHeaders[name], value)
+		}
+	}
+
+	headers, err := renderPayloadTemplates(s.headers, first)
+	if err != nil {
+		return permanent(fmt.Errorf("Could not render headers: %s", err))
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	// the signature covers the timestamp, so receivers can reject replayed requests
+	if len(s.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+
+		req.Header.Set("X-Nomad-Firehose-Timestamp", timestamp)
+		req.Header.Set("X-Nomad-Firehose-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return httpRetryable(resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, b))
+	}
+
+	return nil
+}
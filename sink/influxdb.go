@@ -0,0 +1,334 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how deep nested objects of an event are searched for numeric fields
+const influxMaxDepth = 4
+
+// escapers for the line protocol
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// InfluxDBSink write the numeric fields of events as points to InfluxDB v2
+type InfluxDBSink struct {
+	client        *http.Client
+	url           string
+	token         string
+	measurement   *payloadTemplate
+	tags          map[string]*payloadTemplate
+	batchSize     int
+	flushInterval time.Duration
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+// NewInfluxDB ...
+func NewInfluxDB() (*InfluxDBSink, error) {
+	serverURL := os.Getenv("SINK_INFLUXDB_URL")
+	if serverURL == "" {
+		return nil, fmt.Errorf("[sink/influxdb] Missing SINK_INFLUXDB_URL (example: http://127.0.0.1:8086)")
+	}
+	log.Infof("[sink/influxdb] SINK_INFLUXDB_URL=%s", serverURL)
+
+	org := os.Getenv("SINK_INFLUXDB_ORG")
+	if org == "" {
+		return nil, fmt.Errorf("[sink/influxdb] Missing SINK_INFLUXDB_ORG")
+	}
+
+	bucket := os.Getenv("SINK_INFLUXDB_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("[sink/influxdb] Missing SINK_INFLUXDB_BUCKET")
+	}
+
+	measurementStr := os.Getenv("SINK_INFLUXDB_MEASUREMENT")
+	if measurementStr == "" {
+		measurementStr = "nomad_{{ firehose }}"
+	}
+
+	measurement, err := newPayloadTemplate("measurement", measurementStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/influxdb] Invalid SINK_INFLUXDB_MEASUREMENT: %s", err)
+	}
+
+	tagsStr, ok := os.LookupEnv("SINK_INFLUXDB_TAGS")
+	if !ok {
+		tagsStr = "namespace={{ .Namespace }},job_id={{ .JobID }},type={{ .Type }}"
+	}
+
+	tags, err := newPayloadTemplates(tagsStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/influxdb] Invalid SINK_INFLUXDB_TAGS: %s", err)
+	}
+
+	batchSize, err := getenvInt("SINK_INFLUXDB_BATCH_SIZE", 1000)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/influxdb] %s", err)
+	}
+
+	flushInterval, err := getenvDuration("SINK_INFLUXDB_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/influxdb] %s", err)
+	}
+
+	query := url.Values{}
+	query.Set("org", org)
+	query.Set("bucket", bucket)
+	query.Set("precision", "ns")
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/influxdb] %s", err)
+	}
+
+	return &InfluxDBSink{
+		client:        &http.Client{Timeout: 30 * time.Second},
+		url:           strings.TrimRight(serverURL, "/") + "/api/v2/write?" + query.Encode(),
+		token:         os.Getenv("SINK_INFLUXDB_TOKEN"),
+		measurement:   measurement,
+		tags:          tags,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *InfluxDBSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *InfluxDBSink) Stop() {
+	log.Infof("[sink/influxdb] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/influxdb] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer sends the last partial batch when stopping
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+// setDeadLetterQueue ...
+func (s *InfluxDBSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *InfluxDBSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *InfluxDBSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *InfluxDBSink) write() {
+	log.Info("[sink/influxdb] Starting writer")
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	// the events of the points, to dead-letter them
+	var batch bytes.Buffer
+	var events [][]byte
+
+	flush := func() {
+		if len(events) == 0 {
+			return
+		}
+		s.send(batch.Bytes(), events)
+		batch = bytes.Buffer{}
+		events = nil
+	}
+
+	for {
+		select {
+		case <-s.stopCh:
+			flush()
+			return
+
+		case <-ticker.C:
+			flush()
+
+		case data := <-s.putCh:
+			line, err := s.point(data)
+			if err != nil {
+				log.Errorf("[sink/influxdb] %s", err)
+				s.retry.undeliverable("influxdb", data, err)
+				continue
+			}
+
+			// events without any numeric field don't make a point
+			if line == "" {
+				s.retry.delivered(1)
+				continue
+			}
+
+			batch.WriteString(line)
+			batch.WriteByte('\n')
+			events = append(events, data)
+
+			if len(events) >= s.batchSize {
+				flush()
+			}
+		}
+	}
+}
+
+// point convert an event to a line protocol point, with the numeric fields of the event as fields
+func (s *InfluxDBSink) point(data []byte) (string, error) {
+	var event map[string]interface{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return "", nil
+	}
+
+	fields := make(map[string]float64)
+	numericFields(event, "", 0, fields)
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	measurement, err := s.measurement.Render(data)
+	if err != nil {
+		return "", fmt.Errorf("Could not render measurement: %s", err)
+	}
+
+	tags, err := renderPayloadTemplates(s.tags, data)
+	if err != nil {
+		return "", fmt.Errorf("Could not render tags: %s", err)
+	}
+
+	var line strings.Builder
+	line.WriteString(influxMeasurementEscaper.Replace(measurement))
+
+	// tags and fields sorted by key, as recommended for write performance
+	for _, key := range sortedKeys(tags) {
+		line.WriteString("," + influxKeyEscaper.Replace(key) + "=" + influxKeyEscaper.Replace(tags[key]))
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		if i == 0 {
+			line.WriteString(" ")
+		} else {
+			line.WriteString(",")
+		}
+		line.WriteString(influxKeyEscaper.Replace(key) + "=" + strconv.FormatFloat(fields[key], 'f', -1, 64))
+	}
+
+	line.WriteString(" " + strconv.FormatInt(time.Now().UnixNano(), 10))
+
+	return line.String(), nil
+}
+
+// numericFields collect the numeric values of an event, with nested keys joined by "."
+func numericFields(object map[string]interface{}, prefix string, depth int, fields map[string]float64) {
+	for key, value := range object {
+		switch v := value.(type) {
+		case float64:
+			fields[prefix+key] = v
+		case map[string]interface{}:
+			if depth < influxMaxDepth {
+				numericFields(v, prefix+key+".", depth+1, fields)
+			}
+		}
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// send a batch of points, one per event
+func (s *InfluxDBSink) send(body []byte, events [][]byte) {
+	err := s.retry.Do("influxdb", func() error {
+		return s.request(body)
+	})
+	if err != nil {
+		log.Errorf("[sink/influxdb] Failed to write %d points: %s", len(events), err)
+		for _, data := range events {
+			s.retry.undeliverable("influxdb", data, err)
+		}
+		return
+	}
+
+	log.Debugf("[sink/influxdb] Wrote %d points", len(events))
+	s.retry.delivered(len(events))
+}
+
+// request write a body of points
+func (s *InfluxDBSink) request(body []byte) error {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return httpRetryable(resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, b))
+	}
+
+	return nil
+}
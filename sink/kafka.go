@@ -1,13 +1,16 @@
 package sink
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/jmespath/go-jmespath"
 	log "github.com/sirupsen/logrus"
+	"github.com/xdg-go/scram"
 )
 
 // KafkaSink ...
@@ -19,7 +22,25 @@ type KafkaSink struct {
 
 	producer sarama.SyncProducer
 
+	// encode the event as the message value, the JSON event itself when nil
+	encode func(data []byte) ([]byte, error)
+
+	// message key of an event
+	key func(data []byte) (string, error)
+
+	// send the event metadata as message headers
+	metadata bool
+
+	retry   *retryPolicy
+	batcher *eventBatcher
+
+	// commit the open transaction every transactionInterval, when transactional
+	transactional       bool
+	transactionInterval time.Duration
+	transactionSize     int
+
 	stopCh chan interface{}
+	doneCh chan interface{}
 	putCh  chan []byte
 }
 
@@ -39,8 +60,44 @@ func NewKafka() (*KafkaSink, error) {
 	}
 	log.Debugf("[sink/kafka] Kafka topic: %s", topic)
 
-	config := sarama.NewConfig()
-	config.Producer.Return.Successes = true
+	encode, err := kafkaEncoder(topic)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kafka] %s", err)
+	}
+
+	key, err := kafkaKey()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kafka] %s", err)
+	}
+
+	config, err := kafkaConfig()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kafka] %s", err)
+	}
+
+	metadata, err := metadataEnabled()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kafka] %s", err)
+	}
+	if metadata && !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+		log.Warnf("[sink/kafka] Message headers require SINK_KAFKA_VERSION 0.11.0 or newer, not sending the event metadata")
+		metadata = false
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kafka] %s", err)
+	}
+
+	batcher, err := newEventBatcher("KAFKA", 1, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kafka] %s", err)
+	}
+
+	transactionInterval, err := getenvDuration("SINK_KAFKA_TRANSACTION_INTERVAL", 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kafka] %s", err)
+	}
 
 	producer, err := sarama.NewSyncProducer(brokerList, config)
 	if err != nil {
@@ -52,8 +109,245 @@ func NewKafka() (*KafkaSink, error) {
 		Brokers:  brokerList,
 		Topic:    topic,
 		producer: producer,
-		stopCh:   make(chan interface{}),
-		putCh:    make(chan []byte, 1000),
+		encode:   encode,
+		key:      key,
+		metadata: metadata,
+		retry:    retry,
+		batcher:  batcher,
+
+		transactional:       config.Producer.Transaction.ID != "",
+		transactionInterval: transactionInterval,
+
+		stopCh: make(chan interface{}),
+		doneCh: make(chan interface{}),
+		putCh:  make(chan []byte, 1000),
+	}, nil
+}
+
+// kafkaKey return the message key of SINK_KAFKA_KEY (template), SINK_KAFKA_KEY_JMESPATH or by
+// default the id of the allocation, job, node, ... of the event, so events of the same entity
+// go to the same partition in order, and compacted topics keep the latest one
+func kafkaKey() (func(data []byte) (string, error), error) {
+	if spec := os.Getenv("SINK_KAFKA_KEY"); spec != "" {
+		log.Infof("[sink/kafka] SINK_KAFKA_KEY=%s", spec)
+
+		if spec == "none" {
+			return func(data []byte) (string, error) { return "", nil }, nil
+		}
+
+		tmpl, err := newPayloadTemplate("key", spec)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid SINK_KAFKA_KEY: %s", err)
+		}
+		return tmpl.Render, nil
+	}
+
+	if expression := os.Getenv("SINK_KAFKA_KEY_JMESPATH"); expression != "" {
+		log.Infof("[sink/kafka] SINK_KAFKA_KEY_JMESPATH=%s", expression)
+
+		query, err := jmespath.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid SINK_KAFKA_KEY_JMESPATH: %s", err)
+		}
+
+		return func(data []byte) (string, error) {
+			var event interface{}
+			if err := json.Unmarshal(data, &event); err != nil {
+				return "", err
+			}
+
+			result, err := query.Search(event)
+			if err != nil || result == nil {
+				return "", err
+			}
+			if str, ok := result.(string); ok {
+				return str, nil
+			}
+
+			b, err := json.Marshal(result)
+			return string(b), err
+		}, nil
+	}
+
+	return func(data []byte) (string, error) {
+		return extractEventFields(data).ID, nil
+	}, nil
+}
+
+// kafkaConfig read the producer configuration, SINK_KAFKA_VERSION, SINK_KAFKA_COMPRESSION, the idempotent and
+// transactional producer from SINK_KAFKA_IDEMPOTENT and SINK_KAFKA_TRANSACTIONAL_ID, SASL from
+// SINK_KAFKA_SASL_MECHANISM, SINK_KAFKA_SASL_USERNAME and SINK_KAFKA_SASL_PASSWORD, and TLS from
+// SINK_KAFKA_TLS, SINK_KAFKA_TLS_CA, SINK_KAFKA_TLS_CERT, SINK_KAFKA_TLS_KEY and SINK_KAFKA_TLS_SERVER_NAME
+func kafkaConfig() (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+
+	if version := os.Getenv("SINK_KAFKA_VERSION"); version != "" {
+		v, err := sarama.ParseKafkaVersion(version)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid SINK_KAFKA_VERSION: %s", err)
+		}
+		config.Version = v
+	}
+
+	// compression of the record batches by the producer, consumers decompress them transparently
+	switch compression := os.Getenv("SINK_KAFKA_COMPRESSION"); compression {
+	case "", "none":
+	case "gzip":
+		config.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		config.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		config.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		config.Producer.Compression = sarama.CompressionZSTD
+		if !config.Version.IsAtLeast(sarama.V2_1_0_0) {
+			config.Version = sarama.V2_1_0_0
+		}
+	default:
+		return nil, fmt.Errorf("Invalid SINK_KAFKA_COMPRESSION value, must be one of: none, gzip, snappy, lz4, zstd")
+	}
+
+	idempotent, err := getenvBool("SINK_KAFKA_IDEMPOTENT", false)
+	if err != nil {
+		return nil, err
+	}
+
+	// transactions are only possible with the idempotent producer
+	if transactionalID := os.Getenv("SINK_KAFKA_TRANSACTIONAL_ID"); transactionalID != "" {
+		log.Infof("[sink/kafka] SINK_KAFKA_TRANSACTIONAL_ID=%s", transactionalID)
+		config.Producer.Transaction.ID = transactionalID
+		idempotent = true
+	}
+
+	if idempotent {
+		config.Producer.Idempotent = true
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Producer.Retry.Max = 10
+		config.Net.MaxOpenRequests = 1
+		if !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+			config.Version = sarama.V0_11_0_0
+		}
+	}
+
+	tlsConfig, err := getenvTLSConfig("KAFKA", false)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	mechanism := os.Getenv("SINK_KAFKA_SASL_MECHANISM")
+	if mechanism == "" {
+		return config, nil
+	}
+
+	config.Net.SASL.Enable = true
+	config.Net.SASL.User = os.Getenv("SINK_KAFKA_SASL_USERNAME")
+	config.Net.SASL.Password = os.Getenv("SINK_KAFKA_SASL_PASSWORD")
+	if config.Net.SASL.User == "" {
+		return nil, fmt.Errorf("Missing SINK_KAFKA_SASL_USERNAME")
+	}
+
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case "SCRAM-SHA-256":
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &kafkaSCRAMClient{HashGeneratorFcn: scram.SHA256}
+		}
+	case "SCRAM-SHA-512":
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &kafkaSCRAMClient{HashGeneratorFcn: scram.SHA512}
+		}
+	default:
+		return nil, fmt.Errorf("Invalid SINK_KAFKA_SASL_MECHANISM value, must be one of: PLAIN, SCRAM-SHA-256, SCRAM-SHA-512")
+	}
+
+	// SCRAM needs the v1 handshake, and a version of the protocol supporting it
+	if config.Net.SASL.Mechanism != sarama.SASLTypePlaintext {
+		config.Net.SASL.Handshake = true
+		config.Net.SASL.Version = sarama.SASLHandshakeV1
+		if !config.Version.IsAtLeast(sarama.V1_0_0_0) {
+			config.Version = sarama.V1_0_0_0
+		}
+	}
+
+	return config, nil
+}
+
+// kafkaSCRAMClient implements sarama.SCRAMClient
+type kafkaSCRAMClient struct {
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (c *kafkaSCRAMClient) Begin(userName, password, authzID string) error {
+	client, err := c.HashGeneratorFcn.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.ClientConversation = client.NewConversation()
+	return nil
+}
+
+func (c *kafkaSCRAMClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *kafkaSCRAMClient) Done() bool {
+	return c.ClientConversation.Done()
+}
+
+// kafkaEncoder return the encoder of SINK_KAFKA_ENCODING, json (nil), msgpack, protobuf, or avro
+// framed for the schema registry at SINK_KAFKA_SCHEMA_REGISTRY_URL
+func kafkaEncoder(topic string) (func(data []byte) ([]byte, error), error) {
+	switch getenvEncoding("KAFKA") {
+	case "json":
+		return nil, nil
+	case "msgpack", "protobuf":
+		return newEventEncoder("KAFKA")
+	case "avro":
+	default:
+		return nil, fmt.Errorf("Invalid SINK_KAFKA_ENCODING value, must be one of: json, avro, msgpack, protobuf")
+	}
+
+	registryURL := os.Getenv("SINK_KAFKA_SCHEMA_REGISTRY_URL")
+	if registryURL == "" {
+		return nil, fmt.Errorf("Missing SINK_KAFKA_SCHEMA_REGISTRY_URL (example: http://schema-registry:8081)")
+	}
+
+	autoRegister, err := getenvBool("SINK_KAFKA_SCHEMA_REGISTRY_AUTO_REGISTER", true)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := newAvroSchema(os.Getenv("SINK_KAFKA_AVRO_SCHEMA_DIR"), os.Getenv("SINK_FIREHOSE"))
+	if err != nil {
+		return nil, err
+	}
+
+	// topic name strategy, the default of the Confluent serializers
+	subject := topic + "-value"
+
+	registry := newSchemaRegistry(registryURL, os.Getenv("SINK_KAFKA_SCHEMA_REGISTRY_USERNAME"), os.Getenv("SINK_KAFKA_SCHEMA_REGISTRY_PASSWORD"))
+	id, err := registry.Register(subject, schema.Schema(), autoRegister)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("[sink/kafka] Encoding events as Avro with schema id %d of subject %s", id, subject)
+
+	return func(data []byte) ([]byte, error) {
+		message, err := schema.Encode(data)
+		if err != nil {
+			return nil, err
+		}
+
+		return schemaRegistryFrame(id, message), nil
 	}, nil
 }
 
@@ -77,6 +371,21 @@ func (s *KafkaSink) Stop() {
 	}
 
 	close(s.stopCh)
+	<-s.doneCh
+
+	if err := s.producer.Close(); err != nil {
+		log.Errorf("[sink/kafka] Failed to close producer: %s", err)
+	}
+}
+
+// setDeadLetterQueue ...
+func (s *KafkaSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *KafkaSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
 }
 
 // Put ..
@@ -88,18 +397,214 @@ func (s *KafkaSink) Put(data []byte) error {
 
 func (s *KafkaSink) write() {
 	log.Info("[sink/kafka] Starting writer")
+	defer close(s.doneCh)
+
+	// each batch is a transaction of its own
+	if s.batcher.maxSize > 1 {
+		s.batcher.Run(s.putCh, s.stopCh, s.sendBatch)
+		return
+	}
+
+	var commitCh <-chan time.Time
+	if s.transactional {
+		ticker := time.NewTicker(s.transactionInterval)
+		defer ticker.Stop()
+		commitCh = ticker.C
+	}
 
 	for {
 		select {
+		case <-s.stopCh:
+			s.commit()
+			return
+		case <-commitCh:
+			s.commit()
 		case data := <-s.putCh:
-			message := &sarama.ProducerMessage{Topic: s.Topic}
-			message.Value = sarama.StringEncoder(string(data))
-			partition, offset, err := s.producer.SendMessage(message)
-			if err != nil {
-				log.Errorf("Failed to produce message: %s", err)
-			} else {
-				log.Debugf("[sink/kafka] topic=%s\tpartition=%d\toffset=%d\n", s.Topic, partition, offset)
+			s.send(data)
+		}
+	}
+}
+
+// message of an event, nil if it can't be encoded
+func (s *KafkaSink) message(data []byte) *sarama.ProducerMessage {
+	key, err := s.key(data)
+	if err != nil {
+		log.Errorf("[sink/kafka] Failed to compute message key: %s", err)
+	}
+
+	value := data
+	if s.encode != nil {
+		value, err = s.encode(data)
+		if err != nil {
+			log.Errorf("[sink/kafka] Failed to encode message: %s", err)
+			s.retry.undeliverable("kafka", data, err)
+			return nil
+		}
+	}
+
+	message := &sarama.ProducerMessage{Topic: s.Topic}
+	message.Value = sarama.ByteEncoder(value)
+
+	// keyless messages are spread over the partitions
+	if key != "" {
+		message.Key = sarama.StringEncoder(key)
+	}
+
+	if s.metadata {
+		for name, value := range newEventMetadata(data).Values() {
+			message.Headers = append(message.Headers, sarama.RecordHeader{Key: []byte(name), Value: []byte(value)})
+		}
+	}
+
+	return message
+}
+
+// send an event, in the open transaction when transactional
+func (s *KafkaSink) send(data []byte) {
+	message := s.message(data)
+	if message == nil {
+		return
+	}
+
+	if s.transactional && s.producer.TxnStatus()&sarama.ProducerTxnFlagInTransaction == 0 {
+		if err := s.producer.BeginTxn(); err != nil {
+			log.Errorf("[sink/kafka] Failed to begin transaction: %s", err)
+			s.retry.undeliverable("kafka", data, err)
+			return
+		}
+	}
+
+	var partition int32
+	var offset int64
+	err := s.retry.Do("kafka", func() error {
+		var err error
+		partition, offset, err = s.producer.SendMessage(message)
+		return kafkaRetryable(err)
+	})
+	if err != nil {
+		log.Errorf("Failed to produce message: %s", err)
+		s.retry.undeliverable("kafka", data, err)
+		return
+	}
+
+	// acknowledged once the transaction is committed
+	if s.transactional {
+		s.transactionSize++
+	} else {
+		s.retry.delivered(1)
+	}
+	log.Debugf("[sink/kafka] topic=%s\tpartition=%d\toffset=%d\n", s.Topic, partition, offset)
+}
+
+// sendBatch send a batch of events with SendMessages, in a transaction of its own when
+// transactional
+func (s *KafkaSink) sendBatch(batch [][]byte) {
+	events := make(map[*sarama.ProducerMessage][]byte, len(batch))
+	messages := make([]*sarama.ProducerMessage, 0, len(batch))
+	for _, data := range batch {
+		if message := s.message(data); message != nil {
+			events[message] = data
+			messages = append(messages, message)
+		}
+	}
+
+	if len(messages) == 0 {
+		return
+	}
+
+	err := s.retry.Do("kafka", func() error {
+		// the whole transaction is sent again
+		if s.transactional {
+			return s.sendTransaction(messages)
+		}
+
+		err := s.producer.SendMessages(messages)
+
+		// only the messages which failed are sent again
+		if errs, ok := err.(sarama.ProducerErrors); ok && len(errs) > 0 {
+			messages = make([]*sarama.ProducerMessage, 0, len(errs))
+			for _, producerErr := range errs {
+				messages = append(messages, producerErr.Msg)
 			}
+			return kafkaRetryable(errs[0])
+		}
+
+		return err
+	})
+	if err != nil {
+		log.Errorf("[sink/kafka] Failed to produce %d messages: %s", len(messages), err)
+		s.retry.delivered(len(events) - len(messages))
+		for _, message := range messages {
+			s.retry.undeliverable("kafka", events[message], err)
+		}
+		return
+	}
+
+	s.retry.delivered(len(events))
+	log.Debugf("[sink/kafka] Produced %d messages to topic %s", len(batch), s.Topic)
+}
+
+// sendTransaction send messages in a transaction, aborting it on failure
+func (s *KafkaSink) sendTransaction(messages []*sarama.ProducerMessage) error {
+	if err := s.producer.BeginTxn(); err != nil {
+		return err
+	}
+
+	err := s.producer.SendMessages(messages)
+	if err == nil {
+		err = s.producer.CommitTxn()
+	}
+
+	if err != nil && s.producer.TxnStatus()&sarama.ProducerTxnFlagInTransaction != 0 {
+		if abortErr := s.producer.AbortTxn(); abortErr != nil {
+			log.Errorf("[sink/kafka] Failed to abort transaction: %s", abortErr)
+		}
+	}
+
+	return err
+}
+
+// kafkaRetryable mark the errors producing again won't fix as permanent
+func kafkaRetryable(err error) error {
+	switch err {
+	case sarama.ErrMessageSizeTooLarge, sarama.ErrInvalidMessage, sarama.ErrUnknownTopicOrPartition,
+		sarama.ErrTopicAuthorizationFailed, sarama.ErrInvalidTopic:
+		return permanent(err)
+	}
+
+	// the producer error of a message wraps the broker error
+	if producerErr, ok := err.(*sarama.ProducerError); ok {
+		if kafkaRetryable(producerErr.Err) != producerErr.Err {
+			return permanent(err)
+		}
+	}
+
+	return err
+}
+
+// commit the open transaction, so read_committed consumers see its messages, or abort it when
+// it can't be committed
+func (s *KafkaSink) commit() {
+	if !s.transactional || s.producer.TxnStatus()&sarama.ProducerTxnFlagInTransaction == 0 {
+		return
+	}
+
+	size := s.transactionSize
+	s.transactionSize = 0
+
+	err := s.producer.CommitTxn()
+	if err == nil {
+		log.Debugf("[sink/kafka] Committed transaction of %d messages", size)
+		s.retry.delivered(size)
+		return
+	}
+
+	// the messages of the transaction are gone with it
+	log.Errorf("[sink/kafka] Failed to commit transaction of %d messages: %s", size, err)
+	s.retry.acks.Lose(size)
+	if s.producer.TxnStatus()&sarama.ProducerTxnFlagAbortableError != 0 {
+		if err := s.producer.AbortTxn(); err != nil {
+			log.Errorf("[sink/kafka] Failed to abort transaction: %s", err)
 		}
 	}
 }
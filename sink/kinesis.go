@@ -1,6 +1,8 @@
 package sink
 
 import (
+	"hash/fnv"
+	"sync"
 	"time"
 
 	"os"
@@ -13,14 +15,25 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// number of writers, each with its own queue
+const kinesisWriters = 3
+
 // KinesisSink ...
 type KinesisSink struct {
-	session      *session.Session
-	kinesis      *kinesis.Kinesis
-	streamName   string
-	partitionKey string
-	stopCh       chan interface{}
-	putCh        chan []byte
+	session         *session.Session
+	kinesis         *kinesis.Kinesis
+	streamName      string
+	partitionKey    *payloadTemplate
+	explicitHashKey *payloadTemplate
+	retry           *retryPolicy
+	batcher         *eventBatcher
+	compressor      *payloadCompressor
+	encode          func(data []byte) ([]byte, error)
+	writers         sync.WaitGroup
+	stopCh          chan interface{}
+
+	// the events of a partition key always go to the same writer, so they're put in order
+	putChs []chan []byte
 }
 
 // NewKinesis ...
@@ -30,21 +43,65 @@ func NewKinesis() (*KinesisSink, error) {
 		return nil, fmt.Errorf("[sink/kinesis] Missing SINK_KINESIS_STREAM_NAME")
 	}
 
-	partitionKey := os.Getenv("SINK_KINESIS_PARTITION_KEY")
-	if partitionKey == "" {
-		return nil, fmt.Errorf("[sink/kinesis] Missing SINK_KINESIS_PARTITION_KEY")
+	partitionKeyStr := os.Getenv("SINK_KINESIS_PARTITION_KEY")
+	if partitionKeyStr == "" {
+		return nil, fmt.Errorf("[sink/kinesis] Missing SINK_KINESIS_PARTITION_KEY (example: {{ .AllocationID }})")
+	}
+
+	partitionKey, err := newPayloadTemplate("partition-key", partitionKeyStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kinesis] Invalid SINK_KINESIS_PARTITION_KEY: %s", err)
+	}
+
+	var explicitHashKey *payloadTemplate
+	if explicitHashKeyStr := os.Getenv("SINK_KINESIS_EXPLICIT_HASH_KEY"); explicitHashKeyStr != "" {
+		explicitHashKey, err = newPayloadTemplate("explicit-hash-key", explicitHashKeyStr)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/kinesis] Invalid SINK_KINESIS_EXPLICIT_HASH_KEY: %s", err)
+		}
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kinesis] %s", err)
+	}
+
+	// at most 500 records and 5 MiB per PutRecords request
+	batcher, err := newEventBatcher("KINESIS", 1, 500, 5*1024*1024)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kinesis] %s", err)
+	}
+
+	compressor, err := newPayloadCompressor("KINESIS")
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kinesis] %s", err)
+	}
+
+	encode, err := newEventEncoder("KINESIS")
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kinesis] %s", err)
 	}
 
 	sess := session.Must(session.NewSession())
 	svc := kinesis.New(sess)
 
+	putChs := make([]chan []byte, kinesisWriters)
+	for i := range putChs {
+		putChs[i] = make(chan []byte, 1000)
+	}
+
 	return &KinesisSink{
-		session:      sess,
-		kinesis:      svc,
-		streamName:   streamName,
-		partitionKey: partitionKey,
-		stopCh:       make(chan interface{}),
-		putCh:        make(chan []byte, 1000),
+		session:         sess,
+		kinesis:         svc,
+		streamName:      streamName,
+		partitionKey:    partitionKey,
+		explicitHashKey: explicitHashKey,
+		retry:           retry,
+		batcher:         batcher,
+		compressor:      compressor,
+		encode:          encode,
+		stopCh:          make(chan interface{}),
+		putChs:          putChs,
 	}, nil
 }
 
@@ -53,10 +110,10 @@ func (s *KinesisSink) Start() error {
 	// Stop chan for all tasks to depend on
 	s.stopCh = make(chan interface{})
 
-	// have 3 writers to kinesis
-	go s.write(1)
-	go s.write(2)
-	go s.write(3)
+	for i, putCh := range s.putChs {
+		s.writers.Add(1)
+		go s.write(i+1, putCh)
+	}
 
 	// wait forever for a stop signal to happen
 	for {
@@ -72,43 +129,226 @@ func (s *KinesisSink) Start() error {
 
 // Stop ...
 func (s *KinesisSink) Stop() {
-	log.Infof("[sink/kinesis] ensure writer queue is empty (%d messages left)", len(s.putCh))
+	log.Infof("[sink/kinesis] ensure writer queues are empty (%d messages left)", s.queued())
 
-	for len(s.putCh) > 0 {
-		log.Info("[sink/kinesis] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+	for s.queued() > 0 {
+		log.Infof("[sink/kinesis] Waiting for queues to drain - (%d messages left)", s.queued())
 		time.Sleep(1 * time.Second)
 	}
 
+	// the writers send their last partial batch
 	close(s.stopCh)
+	s.writers.Wait()
+}
+
+// setDeadLetterQueue ...
+func (s *KinesisSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *KinesisSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
 }
 
-// Put ..
+// Put queue the event for the writer of its partition key
 func (s *KinesisSink) Put(data []byte) error {
-	s.putCh <- data
+	// events whose key can't be rendered fail in the writer
+	key, _ := s.partition(data)
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	s.putChs[h.Sum32()%uint32(len(s.putChs))] <- data
 
 	return nil
 }
 
-func (s *KinesisSink) write(id int) {
+// queued count the events waiting for the writers
+func (s *KinesisSink) queued() int {
+	n := 0
+	for _, putCh := range s.putChs {
+		n += len(putCh)
+	}
+	return n
+}
+
+func (s *KinesisSink) write(id int, putCh chan []byte) {
 	log.Infof("[sink/kinesis/%d] Starting writer", id)
+	defer s.writers.Done()
 
 	streamName := aws.String(s.streamName)
-	partitionKey := aws.String(s.partitionKey)
+
+	if s.batcher.maxSize > 1 {
+		s.batcher.Run(putCh, s.stopCh, func(batch [][]byte) {
+			s.sendBatch(id, batch)
+		})
+		return
+	}
 
 	for {
 		select {
-		case data := <-s.putCh:
-			putOutput, err := s.kinesis.PutRecord(&kinesis.PutRecordInput{
-				Data:         data,
-				StreamName:   streamName,
-				PartitionKey: partitionKey,
+		case <-s.stopCh:
+			return
+		case data := <-putCh:
+			record, err := s.record(data)
+			if err != nil {
+				log.Errorf("[sink/kinesis/%d] Failed to encode record: %s", id, err)
+				s.retry.undeliverable("kinesis", data, err)
+				continue
+			}
+
+			partitionKey, explicitHashKey, err := s.keys(data)
+			if err != nil {
+				log.Errorf("[sink/kinesis/%d] %s", id, err)
+				s.retry.undeliverable("kinesis", data, err)
+				continue
+			}
+
+			var putOutput *kinesis.PutRecordOutput
+			err = s.retry.Do("kinesis", func() error {
+				var err error
+				putOutput, err = s.kinesis.PutRecord(&kinesis.PutRecordInput{
+					Data:            record,
+					StreamName:      streamName,
+					PartitionKey:    partitionKey,
+					ExplicitHashKey: explicitHashKey,
+				})
+				return awsRetryable(err)
 			})
 
 			if err != nil {
 				log.Errorf("[sink/kinesis/%d] %s", id, err)
+				s.retry.undeliverable("kinesis", data, err)
 			} else {
 				log.Infof("[sink/kinesis/%d] %v", id, putOutput)
+				s.retry.delivered(1)
 			}
 		}
 	}
 }
+
+// record encode and compress an event
+func (s *KinesisSink) record(data []byte) ([]byte, error) {
+	if s.encode != nil {
+		var err error
+		if data, err = s.encode(data); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.compressor.Compress(data)
+}
+
+// partition render the partition key of an event, falling back to the firehose type for events
+// without the field
+func (s *KinesisSink) partition(data []byte) (string, error) {
+	partitionKey, err := s.partitionKey.Render(data)
+	if err != nil {
+		return "", fmt.Errorf("Could not render partition key: %s", err)
+	}
+	// events without the field still need a partition key
+	if partitionKey == "" {
+		partitionKey = os.Getenv("SINK_FIREHOSE")
+	}
+
+	// at most 256 bytes, without splitting a character
+	return string(truncateUTF8([]byte(partitionKey), 256)), nil
+}
+
+// keys return the partition key and the explicit hash key (nil when not set) of the record of an
+// event
+func (s *KinesisSink) keys(data []byte) (*string, *string, error) {
+	partitionKey, err := s.partition(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.explicitHashKey == nil {
+		return aws.String(partitionKey), nil, nil
+	}
+
+	explicitHashKey, err := s.explicitHashKey.Render(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not render explicit hash key: %s", err)
+	}
+	if explicitHashKey == "" {
+		return aws.String(partitionKey), nil, nil
+	}
+
+	return aws.String(partitionKey), aws.String(explicitHashKey), nil
+}
+
+// sendBatch send a batch of records with PutRecords, sending the records that failed
+// individually again
+func (s *KinesisSink) sendBatch(id int, batch [][]byte) {
+	// record to event, for the dead-letter queue
+	events := make(map[*kinesis.PutRecordsRequestEntry][]byte, len(batch))
+	records := make([]*kinesis.PutRecordsRequestEntry, 0, len(batch))
+	for _, data := range batch {
+		record, err := s.record(data)
+		if err != nil {
+			log.Errorf("[sink/kinesis/%d] Failed to encode record: %s", id, err)
+			s.retry.undeliverable("kinesis", data, err)
+			continue
+		}
+
+		partitionKey, explicitHashKey, err := s.keys(data)
+		if err != nil {
+			log.Errorf("[sink/kinesis/%d] %s", id, err)
+			s.retry.undeliverable("kinesis", data, err)
+			continue
+		}
+
+		entry := &kinesis.PutRecordsRequestEntry{
+			Data:            record,
+			PartitionKey:    partitionKey,
+			ExplicitHashKey: explicitHashKey,
+		}
+		events[entry] = data
+		records = append(records, entry)
+	}
+
+	if len(records) == 0 {
+		return
+	}
+
+	err := s.retry.Do("kinesis", func() error {
+		output, err := s.kinesis.PutRecords(&kinesis.PutRecordsInput{
+			StreamName: aws.String(s.streamName),
+			Records:    records,
+		})
+		if err != nil {
+			return awsRetryable(err)
+		}
+
+		if aws.Int64Value(output.FailedRecordCount) == 0 {
+			return nil
+		}
+
+		// the results are in the same order as the records
+		var failed error
+		retry := make([]*kinesis.PutRecordsRequestEntry, 0, aws.Int64Value(output.FailedRecordCount))
+		for i, result := range output.Records {
+			if result.ErrorCode == nil {
+				continue
+			}
+			failed = fmt.Errorf("%s: %s", aws.StringValue(result.ErrorCode), aws.StringValue(result.ErrorMessage))
+			retry = append(retry, records[i])
+		}
+		records = retry
+
+		return failed
+	})
+	if err != nil {
+		log.Errorf("[sink/kinesis/%d] Failed to put %d records: %s", id, len(records), err)
+		s.retry.delivered(len(events) - len(records))
+		for _, record := range records {
+			s.retry.undeliverable("kinesis", events[record], err)
+		}
+		return
+	}
+
+	s.retry.delivered(len(events))
+	log.Debugf("[sink/kinesis/%d] Put %d records", id, len(batch))
+}
@@ -0,0 +1,218 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	log "github.com/sirupsen/logrus"
+)
+
+// maximum number of records of a PutRecordBatch call
+const kinesisFirehoseMaxBatchSize = 500
+
+// KinesisFirehoseSink ...
+type KinesisFirehoseSink struct {
+	session       *session.Session
+	firehose      *firehose.Firehose
+	streamName    string
+	newline       bool
+	batchSize     int
+	flushInterval time.Duration
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+// NewKinesisFirehose ...
+func NewKinesisFirehose() (*KinesisFirehoseSink, error) {
+	streamName := os.Getenv("SINK_KINESIS_FIREHOSE_STREAM_NAME")
+	if streamName == "" {
+		return nil, fmt.Errorf("[sink/kinesis-firehose] Missing SINK_KINESIS_FIREHOSE_STREAM_NAME")
+	}
+	log.Infof("[sink/kinesis-firehose] SINK_KINESIS_FIREHOSE_STREAM_NAME=%s", streamName)
+
+	newline, err := getenvBool("SINK_KINESIS_FIREHOSE_NEWLINE", true)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kinesis-firehose] %s", err)
+	}
+
+	batchSize, err := getenvInt("SINK_KINESIS_FIREHOSE_BATCH_SIZE", kinesisFirehoseMaxBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kinesis-firehose] %s", err)
+	}
+	if batchSize < 1 || batchSize > kinesisFirehoseMaxBatchSize {
+		return nil, fmt.Errorf("[sink/kinesis-firehose] Invalid SINK_KINESIS_FIREHOSE_BATCH_SIZE value, must be between 1 and %d", kinesisFirehoseMaxBatchSize)
+	}
+
+	flushInterval, err := getenvDuration("SINK_KINESIS_FIREHOSE_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kinesis-firehose] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/kinesis-firehose] %s", err)
+	}
+
+	sess := session.Must(session.NewSession())
+	svc := firehose.New(sess)
+
+	return &KinesisFirehoseSink{
+		session:       sess,
+		firehose:      svc,
+		streamName:    streamName,
+		newline:       newline,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *KinesisFirehoseSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *KinesisFirehoseSink) Stop() {
+	log.Infof("[sink/kinesis-firehose] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/kinesis-firehose] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer sends the last partial batch when stopping
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+// setDeadLetterQueue ...
+func (s *KinesisFirehoseSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *KinesisFirehoseSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *KinesisFirehoseSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *KinesisFirehoseSink) write() {
+	log.Infof("[sink/kinesis-firehose] Starting writer to delivery stream '%s'", s.streamName)
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*firehose.Record, 0, s.batchSize)
+
+	for {
+		select {
+		case <-s.stopCh:
+			s.send(batch)
+			return
+
+		case <-ticker.C:
+			s.send(batch)
+			batch = make([]*firehose.Record, 0, s.batchSize)
+
+		case data := <-s.putCh:
+			// newline delimited records, so objects delivered to S3 are valid NDJSON
+			if s.newline {
+				data = append(append(make([]byte, 0, len(data)+1), data...), '\n')
+			}
+
+			batch = append(batch, &firehose.Record{Data: data})
+
+			if len(batch) >= s.batchSize {
+				s.send(batch)
+				batch = make([]*firehose.Record, 0, s.batchSize)
+			}
+		}
+	}
+}
+
+// send a batch of records with PutRecordBatch, sending records that failed individually again
+func (s *KinesisFirehoseSink) send(batch []*firehose.Record) {
+	if len(batch) == 0 {
+		return
+	}
+
+	streamName := aws.String(s.streamName)
+
+	err := s.retry.Do("kinesis-firehose", func() error {
+		output, err := s.firehose.PutRecordBatch(&firehose.PutRecordBatchInput{
+			DeliveryStreamName: streamName,
+			Records:            batch,
+		})
+		if err != nil {
+			return awsRetryable(err)
+		}
+
+		failed := aws.Int64Value(output.FailedPutCount)
+		log.Debugf("[sink/kinesis-firehose] Put %d records (%d failed)", len(batch), failed)
+		s.retry.delivered(len(batch) - int(failed))
+
+		if failed == 0 {
+			return nil
+		}
+
+		// the responses are in the same order as the records
+		retry := make([]*firehose.Record, 0, failed)
+		var lastErr error
+		for i, response := range output.RequestResponses {
+			if response.ErrorCode == nil {
+				continue
+			}
+			lastErr = fmt.Errorf("%s: %s", aws.StringValue(response.ErrorCode), aws.StringValue(response.ErrorMessage))
+			retry = append(retry, batch[i])
+		}
+
+		batch = retry
+		return fmt.Errorf("Failed to put %d records: %s", len(batch), lastErr)
+	})
+	if err != nil {
+		log.Errorf("[sink/kinesis-firehose] %s", err)
+		for _, record := range batch {
+			s.retry.undeliverable("kinesis-firehose", s.event(record), err)
+		}
+	}
+}
+
+// event of a record, without the newline delimiter
+func (s *KinesisFirehoseSink) event(record *firehose.Record) []byte {
+	if s.newline {
+		return bytes.TrimSuffix(record.Data, []byte("\n"))
+	}
+	return record.Data
+}
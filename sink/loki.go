@@ -0,0 +1,257 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LokiSink push events as log lines to Grafana Loki
+type LokiSink struct {
+	client        *http.Client
+	url           string
+	username      string
+	password      string
+	tenantID      string
+	labels        map[string]*payloadTemplate
+	batchSize     int
+	flushInterval time.Duration
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+// lokiStream is a stream of the push API, the log lines sharing the same labels
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// NewLoki ...
+func NewLoki() (*LokiSink, error) {
+	url := os.Getenv("SINK_LOKI_URL")
+	if url == "" {
+		return nil, fmt.Errorf("[sink/loki] Missing SINK_LOKI_URL (example: http://127.0.0.1:3100)")
+	}
+	log.Infof("[sink/loki] SINK_LOKI_URL=%s", url)
+
+	labelsStr, ok := os.LookupEnv("SINK_LOKI_LABELS")
+	if !ok {
+		labelsStr = "firehose={{ firehose }},namespace={{ .Namespace }},job_id={{ .JobID }}"
+	}
+
+	labels, err := newPayloadTemplates(labelsStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/loki] Invalid SINK_LOKI_LABELS: %s", err)
+	}
+
+	batchSize, err := getenvInt("SINK_LOKI_BATCH_SIZE", 500)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/loki] %s", err)
+	}
+
+	flushInterval, err := getenvDuration("SINK_LOKI_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/loki] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/loki] %s", err)
+	}
+
+	return &LokiSink{
+		client:        &http.Client{Timeout: 30 * time.Second},
+		url:           strings.TrimRight(url, "/") + "/loki/api/v1/push",
+		username:      os.Getenv("SINK_LOKI_USERNAME"),
+		password:      os.Getenv("SINK_LOKI_PASSWORD"),
+		tenantID:      os.Getenv("SINK_LOKI_TENANT_ID"),
+		labels:        labels,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *LokiSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *LokiSink) Stop() {
+	log.Infof("[sink/loki] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/loki] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer pushes the last partial batch when stopping
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+// setDeadLetterQueue ...
+func (s *LokiSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *LokiSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *LokiSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *LokiSink) write() {
+	log.Info("[sink/loki] Starting writer")
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	// streams by their labels
+	streams := make(map[string]*lokiStream)
+	lines := 0
+
+	flush := func() {
+		if lines == 0 {
+			return
+		}
+		s.push(streams, lines)
+		streams = make(map[string]*lokiStream)
+		lines = 0
+	}
+
+	for {
+		select {
+		case <-s.stopCh:
+			flush()
+			return
+
+		case <-ticker.C:
+			flush()
+
+		case data := <-s.putCh:
+			labels, err := renderPayloadTemplates(s.labels, data)
+			if err != nil {
+				log.Errorf("[sink/loki] Could not render labels: %s", err)
+				s.retry.undeliverable("loki", data, err)
+				continue
+			}
+
+			key := lokiStreamKey(labels)
+			stream, ok := streams[key]
+			if !ok {
+				stream = &lokiStream{Stream: labels}
+				streams[key] = stream
+			}
+
+			stream.Values = append(stream.Values, [2]string{strconv.FormatInt(time.Now().UnixNano(), 10), string(data)})
+			lines++
+
+			if lines >= s.batchSize {
+				flush()
+			}
+		}
+	}
+}
+
+// lokiStreamKey is a stable key for a label set
+func lokiStreamKey(labels map[string]string) string {
+	keys := sortedKeys(labels)
+	for i, key := range keys {
+		keys[i] = key + "=" + labels[key]
+	}
+	return strings.Join(keys, ",")
+}
+
+// push the streams, trying again on 429 and 5xx responses
+func (s *LokiSink) push(streams map[string]*lokiStream, lines int) {
+	payload := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, stream := range streams {
+		payload.Streams = append(payload.Streams, stream)
+	}
+
+	body, err := json.Marshal(payload)
+	if err == nil {
+		err = s.retry.Do("loki", func() error {
+			return s.send(body)
+		})
+	}
+	if err != nil {
+		log.Errorf("[sink/loki] Failed to push %d lines: %s", lines, err)
+		for _, stream := range streams {
+			for _, value := range stream.Values {
+				s.retry.undeliverable("loki", []byte(value[1]), err)
+			}
+		}
+		return
+	}
+
+	log.Debugf("[sink/loki] Pushed %d lines in %d streams", lines, len(streams))
+	s.retry.delivered(lines)
+}
+
+// send a push request, the errors sending it again won't fix being permanent
+func (s *LokiSink) send(body []byte) error {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	if s.tenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.tenantID)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return httpRetryable(resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, b))
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package sink
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// HTTP headers of the event metadata
+var httpMetadataHeaders = map[string]string{
+	"firehose":     "X-Nomad-Firehose-Type",
+	"event_id":     "X-Nomad-Firehose-Event-Id",
+	"namespace":    "X-Nomad-Firehose-Namespace",
+	"modify_index": "X-Nomad-Firehose-Modify-Index",
+	"emitted_at":   "X-Nomad-Firehose-Emitted-At",
+}
+
+// eventMetadata is sent along with an event as transport metadata (kafka headers, AMQP headers,
+// SQS and SNS message attributes, HTTP headers), so consumers can route and filter events
+// without parsing them
+type eventMetadata struct {
+	Firehose    string
+	ID          string
+	Namespace   string
+	ModifyIndex uint64
+	EmittedAt   time.Time
+}
+
+// metadataEnabled tell if events are sent with their metadata, unless SINK_METADATA_HEADERS=false
+func metadataEnabled() (bool, error) {
+	return getenvBool("SINK_METADATA_HEADERS", true)
+}
+
+// newEventMetadata ...
+func newEventMetadata(data []byte) *eventMetadata {
+	fields := extractEventFields(data)
+
+	return &eventMetadata{
+		Firehose:    os.Getenv("SINK_FIREHOSE"),
+		ID:          fields.ID,
+		Namespace:   fields.Namespace,
+		ModifyIndex: fields.ModifyIndex,
+		EmittedAt:   time.Now().UTC(),
+	}
+}
+
+// newBatchMetadata is the metadata shared by the events of a batch
+func newBatchMetadata() *eventMetadata {
+	return &eventMetadata{
+		Firehose:  os.Getenv("SINK_FIREHOSE"),
+		EmittedAt: time.Now().UTC(),
+	}
+}
+
+// Values of the metadata by name (firehose, event_id, namespace, modify_index and emitted_at),
+// without the ones missing from the event
+func (m *eventMetadata) Values() map[string]string {
+	values := map[string]string{
+		"emitted_at": m.EmittedAt.Format(time.RFC3339Nano),
+	}
+
+	if m.Firehose != "" {
+		values["firehose"] = m.Firehose
+	}
+	if m.ID != "" {
+		values["event_id"] = m.ID
+	}
+	if m.Namespace != "" {
+		values["namespace"] = m.Namespace
+	}
+	if m.ModifyIndex > 0 {
+		values["modify_index"] = strconv.FormatUint(m.ModifyIndex, 10)
+	}
+
+	return values
+}
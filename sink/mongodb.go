@@ -0,0 +1,301 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoDBSink write events as documents into a collection, either appending every event
+// (history) or upserting a document per event id (latest state)
+type MongoDBSink struct {
+	client        *mongo.Client
+	collection    *mongo.Collection
+	firehose      string
+	upsert        bool
+	key           *payloadTemplate
+	batchSize     int
+	flushInterval time.Duration
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+// NewMongoDB ...
+func NewMongoDB() (*MongoDBSink, error) {
+	uri := os.Getenv("SINK_MONGODB_URI")
+	if uri == "" {
+		return nil, fmt.Errorf("[sink/mongodb] Missing SINK_MONGODB_URI (example: mongodb://127.0.0.1:27017)")
+	}
+
+	database := os.Getenv("SINK_MONGODB_DATABASE")
+	if database == "" {
+		database = "nomad_firehose"
+	}
+
+	collection := os.Getenv("SINK_MONGODB_COLLECTION")
+	if collection == "" {
+		collection = "events"
+	}
+	log.Infof("[sink/mongodb] SINK_MONGODB_DATABASE=%s SINK_MONGODB_COLLECTION=%s", database, collection)
+
+	mode := os.Getenv("SINK_MONGODB_MODE")
+	if mode == "" {
+		mode = "append"
+	}
+	if mode != "append" && mode != "upsert" {
+		return nil, fmt.Errorf("[sink/mongodb] Invalid SINK_MONGODB_MODE value, must be one of: append, upsert")
+	}
+
+	var key *payloadTemplate
+	if spec := os.Getenv("SINK_MONGODB_KEY"); spec != "" {
+		var err error
+		key, err = newPayloadTemplate("key", spec)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/mongodb] Invalid SINK_MONGODB_KEY: %s", err)
+		}
+	}
+
+	batchSize, err := getenvInt("SINK_MONGODB_BATCH_SIZE", 100)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/mongodb] %s", err)
+	}
+	if batchSize < 1 {
+		return nil, fmt.Errorf("[sink/mongodb] Invalid SINK_MONGODB_BATCH_SIZE value, must be positive")
+	}
+
+	flushInterval, err := getenvDuration("SINK_MONGODB_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/mongodb] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/mongodb] %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, fmt.Errorf("[sink/mongodb] %s", err)
+	}
+
+	if err := client.Ping(ctx, nil); err != nil {
+		return nil, fmt.Errorf("[sink/mongodb] Failed to connect: %s", err)
+	}
+
+	s := &MongoDBSink{
+		client:        client,
+		collection:    client.Database(database).Collection(collection),
+		firehose:      os.Getenv("SINK_FIREHOSE"),
+		upsert:        mode == "upsert",
+		key:           key,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}
+
+	// the latest state documents are unique per firehose and key, this is also what makes
+	// stale (lower modify index) upserts fail instead of overwriting newer documents
+	if s.upsert {
+		_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "firehose", Value: 1}, {Key: "event_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("[sink/mongodb] Failed to create index: %s", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Start ...
+func (s *MongoDBSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *MongoDBSink) Stop() {
+	log.Infof("[sink/mongodb] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/mongodb] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer writes the last partial batch when stopping
+	close(s.stopCh)
+	<-s.doneCh
+
+	s.client.Disconnect(context.Background())
+}
+
+// setDeadLetterQueue ...
+func (s *MongoDBSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *MongoDBSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *MongoDBSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *MongoDBSink) write() {
+	log.Infof("[sink/mongodb] Starting writer to collection '%s'", s.collection.Name())
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	// the events of the models, to dead-letter them
+	batch := make([]mongo.WriteModel, 0, s.batchSize)
+	events := make([][]byte, 0, s.batchSize)
+
+	for {
+		select {
+		case <-s.stopCh:
+			s.flush(batch, events)
+			return
+
+		case <-ticker.C:
+			s.flush(batch, events)
+			batch, events = batch[:0], events[:0]
+
+		case data := <-s.putCh:
+			model, err := s.model(data)
+			if err != nil {
+				log.Errorf("[sink/mongodb] %s", err)
+				s.retry.undeliverable("mongodb", data, err)
+				continue
+			}
+			batch = append(batch, model)
+			events = append(events, data)
+
+			if len(batch) >= s.batchSize {
+				s.flush(batch, events)
+				batch, events = batch[:0], events[:0]
+			}
+		}
+	}
+}
+
+// model build the insert or upsert of an event
+func (s *MongoDBSink) model(data []byte) (mongo.WriteModel, error) {
+	var payload bson.M
+	if err := bson.UnmarshalExtJSON(data, false, &payload); err != nil {
+		return nil, fmt.Errorf("Failed to decode event: %s", err)
+	}
+
+	fields := extractEventFields(data)
+	if s.key != nil {
+		key, err := s.key.Render(data)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to render key: %s", err)
+		}
+		fields.ID = key
+	}
+
+	document := bson.D{
+		{Key: "firehose", Value: s.firehose},
+		{Key: "event_id", Value: fields.ID},
+		{Key: "namespace", Value: fields.Namespace},
+		{Key: "modify_index", Value: int64(fields.ModifyIndex)},
+		{Key: "created_at", Value: time.Now().UTC()},
+		{Key: "payload", Value: payload},
+	}
+
+	if !s.upsert || fields.ID == "" {
+		return mongo.NewInsertOneModel().SetDocument(document), nil
+	}
+
+	filter := bson.D{
+		{Key: "firehose", Value: s.firehose},
+		{Key: "event_id", Value: fields.ID},
+		{Key: "modify_index", Value: bson.D{{Key: "$lte", Value: int64(fields.ModifyIndex)}}},
+	}
+
+	return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(document).SetUpsert(true), nil
+}
+
+// flush write a batch, unordered so a single failing document doesn't fail the others
+func (s *MongoDBSink) flush(batch []mongo.WriteModel, events [][]byte) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// documents failing individually are invalid, only the errors of the whole batch are retried
+	var bulkErr mongo.BulkWriteException
+	err := s.retry.Do("mongodb", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		bulkErr = mongo.BulkWriteException{}
+		_, err := s.collection.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(false))
+		if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		log.Errorf("[sink/mongodb] Failed to write %d events: %s", len(batch), err)
+		for _, data := range events {
+			s.retry.undeliverable("mongodb", data, err)
+		}
+		return
+	}
+
+	if len(bulkErr.WriteErrors) == 0 {
+		log.Debugf("[sink/mongodb] Wrote %d events", len(batch))
+		s.retry.delivered(len(batch))
+		return
+	}
+
+	// duplicate keys are upserts of events older than the stored document
+	failed := 0
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code == 11000 {
+			continue
+		}
+		failed++
+		log.Errorf("[sink/mongodb] Failed to write event: %s", writeErr.Message)
+		s.retry.undeliverable("mongodb", events[writeErr.Index], fmt.Errorf("%s", writeErr.Message))
+	}
+
+	log.Debugf("[sink/mongodb] Wrote %d events (%d failed, %d stale)", len(batch)-len(bulkErr.WriteErrors), failed, len(bulkErr.WriteErrors)-failed)
+	s.retry.delivered(len(batch) - failed)
+}
@@ -0,0 +1,256 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// MQTTSink ...
+type MQTTSink struct {
+	topic  *payloadTemplate
+	qos    byte
+	retain bool
+
+	// publish and disconnect for the protocol version in use
+	publish    func(topic string, data []byte) error
+	disconnect func()
+
+	retry *retryPolicy
+
+	stopCh chan interface{}
+	putCh  chan []byte
+}
+
+// NewMQTT ...
+func NewMQTT() (*MQTTSink, error) {
+	broker := os.Getenv("SINK_MQTT_BROKER")
+	if broker == "" {
+		return nil, fmt.Errorf("[sink/mqtt] Missing SINK_MQTT_BROKER (example: tcp://127.0.0.1:1883 or ssl://127.0.0.1:8883)")
+	}
+	log.Infof("[sink/mqtt] SINK_MQTT_BROKER=%s", broker)
+
+	topicStr := os.Getenv("SINK_MQTT_TOPIC")
+	if topicStr == "" {
+		return nil, fmt.Errorf("[sink/mqtt] Missing SINK_MQTT_TOPIC (example: nomad/{{ firehose }})")
+	}
+	log.Infof("[sink/mqtt] SINK_MQTT_TOPIC=%s", topicStr)
+
+	topic, err := newPayloadTemplate("topic", topicStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/mqtt] Invalid SINK_MQTT_TOPIC: %s", err)
+	}
+
+	qos, err := getenvInt("SINK_MQTT_QOS", 1)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/mqtt] %s", err)
+	}
+	if qos < 0 || qos > 2 {
+		return nil, fmt.Errorf("[sink/mqtt] Invalid SINK_MQTT_QOS value, must be 0, 1 or 2")
+	}
+
+	retain, err := getenvBool("SINK_MQTT_RETAIN", false)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/mqtt] %s", err)
+	}
+
+	clientID := os.Getenv("SINK_MQTT_CLIENT_ID")
+	if clientID == "" {
+		hostname, _ := os.Hostname()
+		clientID = fmt.Sprintf("nomad-firehose-%s-%d", hostname, os.Getpid())
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/mqtt] %s", err)
+	}
+
+	s := &MQTTSink{
+		topic:  topic,
+		qos:    byte(qos),
+		retain: retain,
+		retry:  retry,
+		stopCh: make(chan interface{}),
+		putCh:  make(chan []byte, 1000),
+	}
+
+	version := os.Getenv("SINK_MQTT_VERSION")
+	switch version {
+	case "", "3", "3.1.1":
+		err = s.connectV3(broker, clientID)
+	case "5":
+		err = s.connectV5(broker, clientID)
+	default:
+		return nil, fmt.Errorf("[sink/mqtt] Invalid SINK_MQTT_VERSION: %s, Valid values: 3 or 5", version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[sink/mqtt] Failed to connect to MQTT: %s", err)
+	}
+
+	return s, nil
+}
+
+// connectV3 connect using MQTT 3.1.1
+func (s *MQTTSink) connectV3(broker, clientID string) error {
+	options := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(os.Getenv("SINK_MQTT_USERNAME")).
+		SetPassword(os.Getenv("SINK_MQTT_PASSWORD")).
+		SetAutoReconnect(true).
+		SetConnectRetry(true)
+
+	client := mqtt.NewClient(options)
+	if token := client.Connect(); token.WaitTimeout(30*time.Second) && token.Error() != nil {
+		return token.Error()
+	}
+
+	s.publish = func(topic string, data []byte) error {
+		token := client.Publish(topic, s.qos, s.retain, data)
+		if !token.WaitTimeout(30 * time.Second) {
+			return fmt.Errorf("Timed out publishing to '%s'", topic)
+		}
+		return token.Error()
+	}
+	s.disconnect = func() {
+		client.Disconnect(1000)
+	}
+
+	return nil
+}
+
+// connectV5 connect using MQTT 5
+func (s *MQTTSink) connectV5(broker, clientID string) error {
+	serverURL, err := url.Parse(broker)
+	if err != nil {
+		return err
+	}
+
+	config := autopaho.ClientConfig{
+		ServerUrls:      []*url.URL{serverURL},
+		KeepAlive:       30,
+		ConnectUsername: os.Getenv("SINK_MQTT_USERNAME"),
+		ConnectPassword: []byte(os.Getenv("SINK_MQTT_PASSWORD")),
+		OnConnectError: func(err error) {
+			log.Errorf("[sink/mqtt] %s", err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: clientID,
+		},
+	}
+
+	manager, err := autopaho.NewConnection(context.Background(), config)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := manager.AwaitConnection(ctx); err != nil {
+		return err
+	}
+
+	s.publish = func(topic string, data []byte) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		_, err := manager.Publish(ctx, &paho.Publish{
+			Topic:      topic,
+			QoS:        s.qos,
+			Retain:     s.retain,
+			Payload:    data,
+			Properties: &paho.PublishProperties{ContentType: "application/json"},
+		})
+		return err
+	}
+	s.disconnect = func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		manager.Disconnect(ctx)
+	}
+
+	return nil
+}
+
+// Start ...
+func (s *MQTTSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *MQTTSink) Stop() {
+	log.Infof("[sink/mqtt] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/mqtt] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+	s.disconnect()
+}
+
+// setDeadLetterQueue ...
+func (s *MQTTSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *MQTTSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *MQTTSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *MQTTSink) write() {
+	log.Info("[sink/mqtt] Starting writer")
+
+	for {
+		select {
+		case data := <-s.putCh:
+			topic, err := s.topic.Render(data)
+			if err != nil {
+				log.Errorf("[sink/mqtt] Could not render topic: %s", err)
+				s.retry.undeliverable("mqtt", data, err)
+				continue
+			}
+
+			err = s.retry.Do("mqtt", func() error {
+				return s.publish(topic, data)
+			})
+			if err != nil {
+				log.Errorf("[sink/mqtt] %s", err)
+				s.retry.undeliverable("mqtt", data, err)
+			} else {
+				log.Debugf("[sink/mqtt] Published to '%s'", topic)
+				s.retry.delivered(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpackEncode convert a JSON event to MessagePack, with numbers in as few bytes as they fit
+func msgpackEncode(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var event interface{}
+	if err := decoder.Decode(&event); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.UseCompactInts(true)
+	encoder.UseCompactFloats(true)
+	if err := encoder.Encode(msgpackValue(event)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// msgpackValue replace the json.Number of a decoded JSON value by the smallest fitting number
+func msgpackValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = msgpackValue(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = msgpackValue(item)
+		}
+		return v
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return n
+		}
+		n, _ := v.Float64()
+		return n
+	default:
+		return v
+	}
+}
@@ -0,0 +1,48 @@
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	// mysql database/sql driver
+	_ "github.com/go-sql-driver/mysql"
+)
+
+var mysqlDialect = sqlDialect{
+	name: "mysql",
+	schema: []string{
+		`CREATE TABLE IF NOT EXISTS %s (
+			id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+			firehose VARCHAR(64) NOT NULL,
+			event_id VARCHAR(255) NOT NULL,
+			namespace VARCHAR(255) NOT NULL,
+			modify_index BIGINT UNSIGNED NOT NULL,
+			created_at DATETIME(6) NOT NULL,
+			payload JSON NOT NULL,
+			INDEX %s_event_idx (firehose, event_id, modify_index),
+			INDEX %s_created_at_idx (created_at)
+		)`,
+	},
+	placeholder: func(n int) string {
+		return "?"
+	},
+	payload: func(placeholder string) string {
+		return placeholder
+	},
+}
+
+// NewMySQL ...
+func NewMySQL() (*SQLSink, error) {
+	dsn := os.Getenv("SINK_MYSQL_DSN")
+	if dsn == "" {
+		return nil, fmt.Errorf("[sink/mysql] Missing SINK_MYSQL_DSN (example: user:password@tcp(127.0.0.1:3306)/nomad)")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/mysql] %s", err)
+	}
+
+	return newSQL(mysqlDialect, "MYSQL", db)
+}
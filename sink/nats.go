@@ -0,0 +1,233 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+)
+
+// NATSSink ...
+type NATSSink struct {
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+	subject *payloadTemplate
+	encode  func(data []byte) ([]byte, error)
+	retry   *retryPolicy
+	stopCh  chan interface{}
+	putCh   chan []byte
+}
+
+// NewNATS ...
+func NewNATS() (*NATSSink, error) {
+	url := os.Getenv("SINK_NATS_URL")
+	if url == "" {
+		return nil, fmt.Errorf("[sink/nats] Missing SINK_NATS_URL (example: nats://127.0.0.1:4222)")
+	}
+	log.Infof("[sink/nats] SINK_NATS_URL=%s", url)
+
+	subjectStr := os.Getenv("SINK_NATS_SUBJECT")
+	if subjectStr == "" {
+		return nil, fmt.Errorf("[sink/nats] Missing SINK_NATS_SUBJECT (example: nomad.firehose or nomad.{{ .Type }})")
+	}
+	log.Infof("[sink/nats] SINK_NATS_SUBJECT=%s", subjectStr)
+
+	subject, err := newPayloadTemplate("subject", subjectStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nats] Invalid SINK_NATS_SUBJECT: %s", err)
+	}
+
+	encode, err := newEventEncoder("NATS")
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nats] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nats] %s", err)
+	}
+
+	options := []nats.Option{
+		nats.Name("nomad-firehose"),
+		nats.MaxReconnects(-1),
+	}
+	if creds := os.Getenv("SINK_NATS_CREDENTIALS"); creds != "" {
+		options = append(options, nats.UserCredentials(creds))
+	}
+
+	conn, err := nats.Connect(url, options...)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nats] Failed to connect to NATS: %s", err)
+	}
+
+	s := &NATSSink{
+		conn:    conn,
+		subject: subject,
+		encode:  encode,
+		retry:   retry,
+		stopCh:  make(chan interface{}),
+		putCh:   make(chan []byte, 1000),
+	}
+
+	if os.Getenv("SINK_NATS_JETSTREAM") == "true" {
+		maxPendingStr := os.Getenv("SINK_NATS_MAX_PENDING")
+		if maxPendingStr == "" {
+			maxPendingStr = "256"
+		}
+		maxPending, err := strconv.Atoi(maxPendingStr)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/nats] Invalid SINK_NATS_MAX_PENDING value, must be an integer")
+		}
+
+		js, err := conn.JetStream(
+			nats.PublishAsyncMaxPending(maxPending),
+			nats.PublishAsyncErrHandler(func(_ nats.JetStream, msg *nats.Msg, err error) {
+				log.Errorf("[sink/nats] JetStream publish to '%s' failed: %s", msg.Subject, err)
+			}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/nats] Failed to create JetStream context: %s", err)
+		}
+
+		log.Infof("[sink/nats] Publishing with JetStream (max %d pending acks)", maxPending)
+		s.js = js
+	}
+
+	return s, nil
+}
+
+// Start ...
+func (s *NATSSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *NATSSink) Stop() {
+	log.Infof("[sink/nats] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/nats] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// wait for the outstanding JetStream acks
+	if s.js != nil {
+		select {
+		case <-s.js.PublishAsyncComplete():
+		case <-time.After(10 * time.Second):
+			log.Warnf("[sink/nats] Timed out waiting for %d JetStream acks", s.js.PublishAsyncPending())
+		}
+	}
+
+	close(s.stopCh)
+	defer s.conn.Close()
+}
+
+// setDeadLetterQueue ...
+func (s *NATSSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *NATSSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *NATSSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *NATSSink) write() {
+	log.Info("[sink/nats] Starting writer")
+
+	for {
+		select {
+		case data := <-s.putCh:
+			subject, err := s.subject.Render(data)
+			if err != nil {
+				log.Errorf("[sink/nats] Could not render subject: %s", err)
+				s.retry.undeliverable("nats", data, err)
+				continue
+			}
+
+			message := data
+			if s.encode != nil {
+				message, err = s.encode(data)
+				if err != nil {
+					log.Errorf("[sink/nats] Failed to encode message: %s", err)
+					s.retry.undeliverable("nats", data, err)
+					continue
+				}
+			}
+
+			if s.js != nil {
+				// acks are received asynchronously, failures are logged by the error handler
+				var future nats.PubAckFuture
+				err := s.retry.Do("nats", func() error {
+					var err error
+					future, err = s.js.PublishAsync(subject, message)
+					return natsRetryable(err)
+				})
+				if err != nil {
+					log.Errorf("[sink/nats] %s", err)
+					s.retry.undeliverable("nats", data, err)
+					continue
+				}
+
+				log.Debugf("[sink/nats] Published to '%s'", subject)
+				go s.acknowledge(future, data)
+				continue
+			}
+
+			err = s.retry.Do("nats", func() error {
+				return natsRetryable(s.conn.Publish(subject, message))
+			})
+			if err != nil {
+				log.Errorf("[sink/nats] %s", err)
+				s.retry.undeliverable("nats", data, err)
+			} else {
+				log.Debugf("[sink/nats] Published to '%s'", subject)
+				s.retry.delivered(1)
+			}
+		}
+	}
+}
+
+// acknowledge an event once the stream stored it
+func (s *NATSSink) acknowledge(future nats.PubAckFuture, data []byte) {
+	select {
+	case <-future.Ok():
+		s.retry.delivered(1)
+	case err := <-future.Err():
+		s.retry.undeliverable("nats", data, err)
+	}
+}
+
+// natsRetryable mark the errors publishing again won't fix as permanent
+func natsRetryable(err error) error {
+	switch err {
+	case nats.ErrMaxPayload, nats.ErrBadSubject:
+		return permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,305 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	nomad "github.com/hashicorp/nomad/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// maximum size of a dispatch payload accepted by Nomad
+const nomadDispatchMaxPayload = 16 * 1024
+
+// NomadDispatchSink dispatch a parameterized Nomad job per event, or per batch of events, with
+// the events as the dispatch payload
+type NomadDispatchSink struct {
+	client        *nomad.Client
+	namespace     string
+	job           *payloadTemplate
+	filter        *payloadTemplate
+	idPrefix      string
+	meta          map[string]*payloadTemplate
+	batchSize     int
+	flushInterval time.Duration
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+// nomadDispatchBatch is the events dispatching the same job with the same meta
+type nomadDispatchBatch struct {
+	job     string
+	meta    map[string]string
+	payload bytes.Buffer
+	events  [][]byte
+}
+
+// NewNomadDispatch ...
+func NewNomadDispatch(region string) (*NomadDispatchSink, error) {
+	jobID := os.Getenv("SINK_NOMAD_DISPATCH_JOB")
+	if jobID == "" {
+		return nil, fmt.Errorf("[sink/nomad-dispatch] Missing SINK_NOMAD_DISPATCH_JOB (id of a parameterized job, example: on-deployment-failed)")
+	}
+	log.Infof("[sink/nomad-dispatch] SINK_NOMAD_DISPATCH_JOB=%s", jobID)
+
+	job, err := newPayloadTemplate("job", jobID)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nomad-dispatch] Invalid SINK_NOMAD_DISPATCH_JOB: %s", err)
+	}
+
+	filterStr := os.Getenv("SINK_NOMAD_DISPATCH_FILTER")
+	if filterStr == "" {
+		filterStr = "true"
+	}
+
+	filter, err := newPayloadTemplate("filter", filterStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nomad-dispatch] Invalid SINK_NOMAD_DISPATCH_FILTER: %s", err)
+	}
+
+	meta, err := newPayloadTemplates(os.Getenv("SINK_NOMAD_DISPATCH_META"))
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nomad-dispatch] Invalid SINK_NOMAD_DISPATCH_META: %s", err)
+	}
+
+	batchSize, err := getenvInt("SINK_NOMAD_DISPATCH_BATCH_SIZE", 1)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nomad-dispatch] %s", err)
+	}
+	if batchSize < 1 {
+		return nil, fmt.Errorf("[sink/nomad-dispatch] Invalid SINK_NOMAD_DISPATCH_BATCH_SIZE value, must be positive")
+	}
+
+	flushInterval, err := getenvDuration("SINK_NOMAD_DISPATCH_FLUSH_INTERVAL", 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nomad-dispatch] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nomad-dispatch] %s", err)
+	}
+
+	// same NOMAD_* configuration and region as the firehose
+	config := nomad.DefaultConfig()
+	if region != "" {
+		config.Region = region
+	}
+	client, err := nomad.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nomad-dispatch] %s", err)
+	}
+
+	return &NomadDispatchSink{
+		client:        client,
+		namespace:     os.Getenv("SINK_NOMAD_DISPATCH_NAMESPACE"),
+		job:           job,
+		filter:        filter,
+		idPrefix:      os.Getenv("SINK_NOMAD_DISPATCH_ID_PREFIX"),
+		meta:          meta,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *NomadDispatchSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *NomadDispatchSink) Stop() {
+	log.Infof("[sink/nomad-dispatch] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/nomad-dispatch] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer dispatches the last partial batches when stopping
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+// setDeadLetterQueue ...
+func (s *NomadDispatchSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *NomadDispatchSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *NomadDispatchSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *NomadDispatchSink) write() {
+	log.Info("[sink/nomad-dispatch] Starting writer")
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batches := make(map[string]*nomadDispatchBatch)
+
+	flushAll := func() {
+		for key, batch := range batches {
+			s.dispatch(batch)
+			delete(batches, key)
+		}
+	}
+
+	for {
+		select {
+		case <-s.stopCh:
+			flushAll()
+			return
+
+		case <-ticker.C:
+			flushAll()
+
+		case data := <-s.putCh:
+			job, meta, err := s.render(data)
+			if err != nil {
+				log.Errorf("[sink/nomad-dispatch] %s", err)
+				s.retry.undeliverable("nomad-dispatch", data, err)
+				continue
+			}
+
+			// not selected by the filter
+			if job == "" {
+				s.retry.delivered(1)
+				continue
+			}
+
+			if len(data)+1 > nomadDispatchMaxPayload {
+				log.Errorf("[sink/nomad-dispatch] Event is too large for a dispatch payload (%d bytes), skipping it", len(data))
+				s.retry.undeliverable("nomad-dispatch", data, fmt.Errorf("event is too large for a dispatch payload (%d bytes)", len(data)))
+				continue
+			}
+
+			key := job
+			for _, name := range sortedKeys(meta) {
+				key += "\x00" + name + "=" + meta[name]
+			}
+
+			batch, ok := batches[key]
+
+			// the payload can't grow past the Nomad limit
+			if ok && batch.payload.Len()+len(data)+1 > nomadDispatchMaxPayload {
+				s.dispatch(batch)
+				ok = false
+			}
+
+			if !ok {
+				batch = &nomadDispatchBatch{job: job, meta: meta}
+				batches[key] = batch
+			}
+
+			// batches are newline delimited JSON, single events plain JSON
+			batch.payload.Write(data)
+			if s.batchSize > 1 {
+				batch.payload.WriteByte('\n')
+			}
+			batch.events = append(batch.events, data)
+
+			if len(batch.events) >= s.batchSize {
+				s.dispatch(batch)
+				delete(batches, key)
+			}
+		}
+	}
+}
+
+// render the job and meta of an event, the job is empty when the event isn't selected
+func (s *NomadDispatchSink) render(data []byte) (string, map[string]string, error) {
+	selected, err := s.filter.Render(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not render filter: %s", err)
+	}
+	if selected != "true" {
+		return "", nil, nil
+	}
+
+	job, err := s.job.Render(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not render job: %s", err)
+	}
+	if job == "" {
+		return "", nil, fmt.Errorf("Empty job for event, skipping it")
+	}
+
+	meta, err := renderPayloadTemplates(s.meta, data)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not render meta: %s", err)
+	}
+
+	return strings.TrimSpace(job), meta, nil
+}
+
+// dispatch the job with the batch as payload, retrying failed dispatches
+func (s *NomadDispatchSink) dispatch(batch *nomadDispatchBatch) {
+	if len(batch.events) == 0 {
+		return
+	}
+
+	q := &nomad.WriteOptions{Namespace: s.namespace}
+
+	var dispatchedJobID string
+	err := s.retry.Do("nomad-dispatch", func() error {
+		resp, _, err := s.client.Jobs().Dispatch(batch.job, batch.meta, batch.payload.Bytes(), s.idPrefix, q)
+		if err != nil {
+			return nomadRetryable(err)
+		}
+		dispatchedJobID = resp.DispatchedJobID
+		return nil
+	})
+	if err != nil {
+		log.Errorf("[sink/nomad-dispatch] Failed to dispatch %s with %d events: %s", batch.job, len(batch.events), err)
+		for _, data := range batch.events {
+			s.retry.undeliverable("nomad-dispatch", data, err)
+		}
+		return
+	}
+
+	log.Infof("[sink/nomad-dispatch] Dispatched %s with %d events", dispatchedJobID, len(batch.events))
+	s.retry.delivered(len(batch.events))
+}
+
+// nomadRetryable tell apart the errors of the Nomad API dispatching again won't fix, the client
+// reporting the status code in the error message (unknown job, not parameterized, missing meta, ...)
+func nomadRetryable(err error) error {
+	var code int
+	if _, scanErr := fmt.Sscanf(err.Error(), "Unexpected response code: %d", &code); scanErr == nil {
+		return httpRetryable(code, err)
+	}
+	return err
+}
@@ -1,8 +1,15 @@
 package sink
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/nsqio/go-nsq"
@@ -10,38 +17,83 @@ import (
 )
 
 type NSQSink struct {
-	producer  *nsq.Producer
-	topicName string
-	stopCh    chan interface{}
-	putCh     chan []byte
+	config         *nsq.Config
+	lookupdAddrs   []string
+	lookupdClient  *http.Client
+	pollInterval   time.Duration
+	topicName      *payloadTemplate
+	producers      map[string]*nsq.Producer
+	producersOrder []string
+	next           int
+	lock           sync.Mutex
+	retry          *retryPolicy
+	stopCh         chan interface{}
+	putCh          chan []byte
 }
 
-func NewNSQ() (*NSQSink, error) {
+// nsqLookupdNodes is the response of the nsqlookupd /nodes endpoint
+type nsqLookupdNodes struct {
+	Producers []struct {
+		BroadcastAddress string `json:"broadcast_address"`
+		TCPPort          int    `json:"tcp_port"`
+	} `json:"producers"`
+}
 
+func NewNSQ() (*NSQSink, error) {
 	addrNSQ := os.Getenv("SINK_NSQ_ADDR")
-	if addrNSQ == "" {
-		return nil, fmt.Errorf("[sink/nsq] Missing SINK_NSQ_ADDR (example: 127.0.0.1:4150)")
+	lookupdAddr := os.Getenv("SINK_NSQ_LOOKUPD_ADDR")
+	if addrNSQ == "" && lookupdAddr == "" {
+		return nil, fmt.Errorf("[sink/nsq] Missing SINK_NSQ_ADDR (example: 127.0.0.1:4150) or SINK_NSQ_LOOKUPD_ADDR (example: 127.0.0.1:4161)")
 	}
-	log.Infof("[sink/nsq] SINK_NSQ_ADDR=%s", addrNSQ)
 
 	topicName := os.Getenv("SINK_NSQ_TOPIC_NAME")
 	if topicName == "" {
-		return nil, fmt.Errorf("[sink/nsq] Missing SINK_NSQ_TOPIC_NAME (example: nomad-firehose)")
+		topicName = "nomad-firehose-{{ firehose }}"
 	}
 	log.Infof("[sink/nsq] SINK_NSQ_TOPIC_NAME=%s", topicName)
 
-	conf := nsq.NewConfig()
-	producer, err := nsq.NewProducer(addrNSQ, conf)
+	topic, err := newPayloadTemplate("topic", topicName)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nsq] Invalid SINK_NSQ_TOPIC_NAME: %s", err)
+	}
+
+	pollInterval, err := getenvDuration("SINK_NSQ_LOOKUPD_POLL_INTERVAL", time.Minute)
 	if err != nil {
-		return nil, fmt.Errorf("[sink/nsq] Failed to connect to NSQ: %v", err)
+		return nil, fmt.Errorf("[sink/nsq] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/nsq] %s", err)
+	}
+
+	s := &NSQSink{
+		config:        nsq.NewConfig(),
+		lookupdClient: &http.Client{Timeout: 10 * time.Second},
+		pollInterval:  pollInterval,
+		topicName:     topic,
+		producers:     make(map[string]*nsq.Producer),
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}
+
+	if addrNSQ != "" {
+		log.Infof("[sink/nsq] SINK_NSQ_ADDR=%s", addrNSQ)
+		if err := s.setProducers(strings.Split(addrNSQ, ",")); err != nil {
+			return nil, fmt.Errorf("[sink/nsq] Failed to connect to NSQ: %v", err)
+		}
+		return s, nil
+	}
+
+	log.Infof("[sink/nsq] SINK_NSQ_LOOKUPD_ADDR=%s", lookupdAddr)
+	s.lookupdAddrs = strings.Split(lookupdAddr, ",")
+
+	if err := s.discover(); err != nil {
+		return nil, fmt.Errorf("[sink/nsq] %s", err)
 	}
 
-	return &NSQSink{
-		producer:  producer,
-		topicName: topicName,
-		stopCh:    make(chan interface{}),
-		putCh:     make(chan []byte, 1000),
-	}, nil
+	return s, nil
 }
 
 func (s *NSQSink) Start() error {
@@ -51,6 +103,11 @@ func (s *NSQSink) Start() error {
 	// have 1 writer to NSQ
 	go s.write(1)
 
+	// keep the list of nsqd up to date
+	if len(s.lookupdAddrs) > 0 {
+		go s.poll()
+	}
+
 	// wait forever for a stop signal to happen
 	for {
 		select {
@@ -72,6 +129,23 @@ func (s *NSQSink) Stop() {
 	}
 
 	close(s.stopCh)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, producer := range s.producers {
+		producer.Stop()
+	}
+}
+
+// setDeadLetterQueue ...
+func (s *NSQSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *NSQSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
 }
 
 func (s *NSQSink) Put(data []byte) error {
@@ -86,11 +160,177 @@ func (s *NSQSink) write(id int) {
 	for {
 		select {
 		case data := <-s.putCh:
-			if err := s.producer.Publish(s.topicName, data); err != nil {
-				log.Infof("[sink/nsq/%d] %s", id, err)
+			topic, err := s.topicName.Render(data)
+			if err != nil {
+				log.Errorf("[sink/nsq/%d] Could not render topic: %s", id, err)
+				s.retry.undeliverable("nsq", data, err)
+				continue
+			}
+
+			err = s.retry.Do("nsq", func() error {
+				return s.publish(topic, data)
+			})
+			if err != nil {
+				log.Errorf("[sink/nsq/%d] %s", id, err)
+				s.retry.undeliverable("nsq", data, err)
 			} else {
-				log.Infof("[sink/nsq/%d] Publish OK", id)
+				log.Debugf("[sink/nsq/%d] Publish OK to %s", id, topic)
+				s.retry.delivered(1)
 			}
 		}
 	}
 }
+
+// publish to the nsqd in round robin, trying the next ones if it fails
+func (s *NSQSink) publish(topic string, data []byte) error {
+	s.lock.Lock()
+	addrs := s.producersOrder
+	start := s.next
+	s.next++
+	s.lock.Unlock()
+
+	if len(addrs) == 0 {
+		return fmt.Errorf("No nsqd to publish to")
+	}
+
+	var err error
+	for i := range addrs {
+		addr := addrs[(start+i)%len(addrs)]
+
+		s.lock.Lock()
+		producer, ok := s.producers[addr]
+		s.lock.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		if err = producer.Publish(topic, data); err == nil {
+			return nil
+		}
+		log.Warnf("[sink/nsq] Failed to publish to %s: %s", addr, err)
+	}
+
+	return err
+}
+
+// poll nsqlookupd for nsqd joining or leaving
+func (s *NSQSink) poll() {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if err := s.discover(); err != nil {
+				log.Errorf("[sink/nsq] %s", err)
+			}
+		}
+	}
+}
+
+// discover the nsqd registered in any of the nsqlookupd
+func (s *NSQSink) discover() error {
+	found := make(map[string]bool)
+	var lastErr error
+
+	for _, lookupdAddr := range s.lookupdAddrs {
+		nodes, err := s.lookupNodes(lookupdAddr)
+		if err != nil {
+			log.Warnf("[sink/nsq] Failed to query nsqlookupd %s: %s", lookupdAddr, err)
+			lastErr = err
+			continue
+		}
+
+		for _, producer := range nodes.Producers {
+			found[net.JoinHostPort(producer.BroadcastAddress, strconv.Itoa(producer.TCPPort))] = true
+		}
+	}
+
+	if len(found) == 0 {
+		if lastErr != nil {
+			return fmt.Errorf("Failed to discover nsqd: %s", lastErr)
+		}
+		return fmt.Errorf("No nsqd registered in nsqlookupd %s", strings.Join(s.lookupdAddrs, ", "))
+	}
+
+	addrs := make([]string, 0, len(found))
+	for addr := range found {
+		addrs = append(addrs, addr)
+	}
+
+	return s.setProducers(addrs)
+}
+
+func (s *NSQSink) lookupNodes(lookupdAddr string) (*nsqLookupdNodes, error) {
+	if !strings.HasPrefix(lookupdAddr, "http://") && !strings.HasPrefix(lookupdAddr, "https://") {
+		lookupdAddr = "http://" + lookupdAddr
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(lookupdAddr, "/")+"/nodes", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.nsq; version=1.0")
+
+	resp, err := s.lookupdClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+
+	nodes := &nsqLookupdNodes{}
+	if err := json.NewDecoder(resp.Body).Decode(nodes); err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// setProducers create producers for new nsqd and stop those for the nsqd which are gone
+func (s *NSQSink) setProducers(addrs []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current := make(map[string]*nsq.Producer, len(addrs))
+
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+
+		if producer, ok := s.producers[addr]; ok {
+			current[addr] = producer
+			continue
+		}
+
+		producer, err := nsq.NewProducer(addr, s.config)
+		if err != nil {
+			return err
+		}
+		log.Infof("[sink/nsq] Publishing to nsqd %s", addr)
+		current[addr] = producer
+	}
+
+	for addr, producer := range s.producers {
+		if _, ok := current[addr]; !ok {
+			log.Infof("[sink/nsq] nsqd %s is gone", addr)
+			producer.Stop()
+		}
+	}
+
+	order := make([]string, 0, len(current))
+	for addr := range current {
+		order = append(order, addr)
+	}
+	sort.Strings(order)
+
+	s.producers = current
+	s.producersOrder = order
+
+	return nil
+}
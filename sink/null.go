@@ -0,0 +1,116 @@
+package sink
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NullSink check the events are valid JSON and count them by type without sending them anywhere,
+// logging a summary every SINK_NULL_REPORT_INTERVAL and when stopping, to try out filters and
+// firehose settings safely
+type NullSink struct {
+	firehose string
+	interval time.Duration
+	lock     sync.Mutex
+	counts   map[string]uint64
+	bytes    uint64
+	invalid  uint64
+	stopCh   chan interface{}
+}
+
+// NewNull ...
+func NewNull() (*NullSink, error) {
+	interval, err := getenvDuration("SINK_NULL_REPORT_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	firehose := os.Getenv("SINK_FIREHOSE")
+	if firehose == "" {
+		firehose = "unknown"
+	}
+
+	return &NullSink{
+		firehose: firehose,
+		interval: interval,
+		counts:   make(map[string]uint64),
+		stopCh:   make(chan interface{}),
+	}, nil
+}
+
+// Start ...
+func (s *NullSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return nil
+		case <-ticker.C:
+			s.report()
+		}
+	}
+}
+
+// Stop ...
+func (s *NullSink) Stop() {
+	close(s.stopCh)
+	s.report()
+}
+
+// Put ..
+func (s *NullSink) Put(data []byte) error {
+	// also the type of the envelope with --envelope
+	var event struct {
+		Type string
+	}
+	err := json.Unmarshal(data, &event)
+
+	// events without a type are counted under the firehose
+	eventType := event.Type
+	if eventType == "" {
+		eventType = s.firehose
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err != nil {
+		s.invalid++
+		log.Warnf("[sink/null] Invalid event: %s", err)
+		return nil
+	}
+
+	s.counts[eventType]++
+	s.bytes += uint64(len(data))
+
+	return nil
+}
+
+// report log the events counted so far
+func (s *NullSink) report() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	types := make([]string, 0, len(s.counts))
+	var total uint64
+	for eventType, count := range s.counts {
+		types = append(types, eventType)
+		total += count
+	}
+	sort.Strings(types)
+
+	log.Infof("[sink/null] %d %s events (%d bytes), %d invalid", total, s.firehose, s.bytes, s.invalid)
+	for _, eventType := range types {
+		log.Infof("[sink/null]   %s: %d", eventType, s.counts[eventType])
+	}
+}
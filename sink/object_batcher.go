@@ -0,0 +1,289 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// objectBatcher buffers events into gzipped newline delimited JSON objects, or Avro object
+// container files, partitioned by firehose type and hour (example:
+// jobs/dt=2024-05-01/hour=13/1714568400000000000-host.json.gz) or day, and hands them to an
+// object store specific upload function. It implements the Start, Stop and Put methods of the
+// object store sinks
+type objectBatcher struct {
+	name      string
+	prefix    string
+	partition time.Duration
+	firehose  string
+	hostname  string
+	maxBytes  int
+	interval  time.Duration
+	upload    func(key string, body []byte) error
+	retry     *retryPolicy
+
+	// avro schema of the events, nil when they're written as JSON
+	avro *avroSchema
+
+	// every flush of a partition is appended to the same object
+	appending bool
+
+	stopCh chan interface{}
+	doneCh chan interface{}
+	putCh  chan []byte
+}
+
+// newObjectBatcher read the common batching configuration from SINK_<env>_PREFIX,
+// SINK_<env>_PARTITION, SINK_<env>_BATCH_BYTES, SINK_<env>_FLUSH_INTERVAL and
+// SINK_<env>_ENCODING (json or avro, with the schemas of SINK_<env>_AVRO_SCHEMA_DIR)
+func newObjectBatcher(name, env string, upload func(key string, body []byte) error) (*objectBatcher, error) {
+	var partition time.Duration
+	switch value := os.Getenv("SINK_" + env + "_PARTITION"); value {
+	case "", "hour":
+		partition = time.Hour
+	case "day":
+		partition = 24 * time.Hour
+	default:
+		return nil, fmt.Errorf("[sink/%s] Invalid SINK_%s_PARTITION: %s, Valid values: hour or day", name, env, value)
+	}
+
+	maxBytes, err := getenvInt("SINK_"+env+"_BATCH_BYTES", 16*1024*1024)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/%s] %s", name, err)
+	}
+
+	interval, err := getenvDuration("SINK_"+env+"_FLUSH_INTERVAL", 5*time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/%s] %s", name, err)
+	}
+
+	firehose := os.Getenv("SINK_FIREHOSE")
+	if firehose == "" {
+		firehose = "unknown"
+	}
+
+	var schema *avroSchema
+	switch encoding := getenvEncoding(env); encoding {
+	case "json":
+	case "avro":
+		schema, err = newAvroSchema(os.Getenv("SINK_"+env+"_AVRO_SCHEMA_DIR"), firehose)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/%s] %s", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("[sink/%s] Invalid SINK_%s_ENCODING value, must be one of: json, avro", name, env)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/%s] %s", name, err)
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &objectBatcher{
+		name:      name,
+		prefix:    os.Getenv("SINK_" + env + "_PREFIX"),
+		partition: partition,
+		firehose:  firehose,
+		hostname:  hostname,
+		maxBytes:  maxBytes,
+		interval:  interval,
+		upload:    upload,
+		retry:     retry,
+		avro:      schema,
+		stopCh:    make(chan interface{}),
+		doneCh:    make(chan interface{}),
+		putCh:     make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (b *objectBatcher) Start() error {
+	// Stop chan for all tasks to depend on
+	b.stopCh = make(chan interface{})
+
+	go b.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-b.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (b *objectBatcher) Stop() {
+	log.Infof("[sink/%s] ensure writer queue is empty (%d messages left)", b.name, len(b.putCh))
+
+	for len(b.putCh) > 0 {
+		log.Infof("[sink/%s] Waiting for queue to drain - (%d messages left)", b.name, len(b.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer uploads the last partial object when stopping
+	close(b.stopCh)
+	<-b.doneCh
+}
+
+// setDeadLetterQueue ...
+func (b *objectBatcher) setDeadLetterQueue(queue *deadLetterQueue) {
+	b.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (b *objectBatcher) setAckTracker(acks *ackTracker) {
+	b.retry.acks = acks
+}
+
+// Put ..
+func (b *objectBatcher) Put(data []byte) error {
+	b.putCh <- data
+
+	return nil
+}
+
+func (b *objectBatcher) write() {
+	log.Infof("[sink/%s] Starting writer", b.name)
+	defer close(b.doneCh)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	var buf bytes.Buffer
+	var period time.Time
+	var natives []interface{}
+	var events [][]byte
+	gz := gzip.NewWriter(&buf)
+	size := 0
+
+	flush := func() {
+		if size == 0 {
+			return
+		}
+
+		if b.avro != nil {
+			// the container file embeds the schema and compresses its blocks itself
+			body, err := b.avro.Container(natives, "deflate")
+			if err != nil {
+				b.fail(events, err)
+			} else {
+				b.send(b.key(period), body, events)
+			}
+		} else if err := gz.Close(); err != nil {
+			b.fail(events, err)
+		} else {
+			b.send(b.key(period), buf.Bytes(), events)
+		}
+
+		buf = bytes.Buffer{}
+		gz.Reset(&buf)
+		natives = nil
+		events = nil
+		size = 0
+	}
+
+	for {
+		select {
+		case <-b.stopCh:
+			flush()
+			return
+
+		case <-ticker.C:
+			flush()
+
+		case data := <-b.putCh:
+			// an object only holds the events of a single partition
+			now := time.Now().UTC().Truncate(b.partition)
+			if !now.Equal(period) {
+				flush()
+				period = now
+			}
+
+			if b.avro != nil {
+				native, err := b.avro.Native(data)
+				if err != nil {
+					log.Errorf("[sink/%s] Could not encode event as avro: %s", b.name, err)
+					b.retry.undeliverable(b.name, data, err)
+					continue
+				}
+				natives = append(natives, native)
+			} else {
+				gz.Write(data)
+				gz.Write([]byte{'\n'})
+			}
+			events = append(events, data)
+			size += len(data) + 1
+
+			if size >= b.maxBytes {
+				flush()
+			}
+		}
+	}
+}
+
+// key of the object for events of the given partition
+func (b *objectBatcher) key(period time.Time) string {
+	extension := ".json.gz"
+	if b.avro != nil {
+		extension = ".avro"
+	}
+
+	name := fmt.Sprintf("%d-%s%s", time.Now().UnixNano(), b.hostname, extension)
+	if b.appending {
+		name = b.hostname + extension
+	}
+
+	if b.partition < 24*time.Hour {
+		return path.Join(b.prefix, b.firehose, "dt="+period.Format("2006-01-02"), "hour="+period.Format("15"), name)
+	}
+	return path.Join(b.prefix, b.firehose, "dt="+period.Format("2006-01-02"), name)
+}
+
+// contentType of the objects
+func (b *objectBatcher) contentType() string {
+	if b.avro != nil {
+		return "avro/binary"
+	}
+	return "application/x-ndjson"
+}
+
+// contentEncoding of the objects, empty when they're not compressed as a whole
+func (b *objectBatcher) contentEncoding() string {
+	if b.avro != nil {
+		return ""
+	}
+	return "gzip"
+}
+
+// send an object of events, trying again on failure
+func (b *objectBatcher) send(key string, body []byte, events [][]byte) {
+	err := b.retry.Do(b.name, func() error {
+		return b.upload(key, body)
+	})
+	if err != nil {
+		b.fail(events, fmt.Errorf("Failed to upload %s: %s", key, err))
+		return
+	}
+
+	log.Debugf("[sink/%s] Uploaded %s (%d bytes)", b.name, key, len(body))
+	b.retry.delivered(len(events))
+}
+
+// fail the events of an object that couldn't be built or uploaded
+func (b *objectBatcher) fail(events [][]byte, err error) {
+	log.Errorf("[sink/%s] Dropping %d events: %s", b.name, len(events), err)
+	for _, data := range events {
+		b.retry.undeliverable(b.name, data, err)
+	}
+}
@@ -0,0 +1,441 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLPSink export events as OpenTelemetry log records over OTLP gRPC or HTTP, for example to an
+// OpenTelemetry Collector
+type OTLPSink struct {
+	protocol      string
+	endpoint      string
+	headers       map[string]string
+	httpClient    *http.Client
+	grpcConn      *grpc.ClientConn
+	grpcClient    collogspb.LogsServiceClient
+	resource      *resourcepb.Resource
+	firehose      string
+	severity      *payloadTemplate
+	attributes    map[string]*payloadTemplate
+	batchSize     int
+	flushInterval time.Duration
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+// NewOTLP ...
+func NewOTLP() (*OTLPSink, error) {
+	protocol := os.Getenv("SINK_OTLP_PROTOCOL")
+	if protocol == "" {
+		protocol = "grpc"
+	}
+	if protocol != "grpc" && protocol != "http" {
+		return nil, fmt.Errorf("[sink/otlp] Invalid SINK_OTLP_PROTOCOL value, must be one of: grpc, http")
+	}
+
+	endpoint := os.Getenv("SINK_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return nil, fmt.Errorf("[sink/otlp] Missing SINK_OTLP_ENDPOINT (example: 127.0.0.1:4317 for grpc, http://127.0.0.1:4318 for http)")
+	}
+	log.Infof("[sink/otlp] SINK_OTLP_ENDPOINT=%s SINK_OTLP_PROTOCOL=%s", endpoint, protocol)
+
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("SINK_OTLP_HEADERS"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("[sink/otlp] Invalid SINK_OTLP_HEADERS pair '%s', expected 'name=value'", pair)
+		}
+		headers[pair[:i]] = pair[i+1:]
+	}
+
+	severityText := os.Getenv("SINK_OTLP_SEVERITY")
+	if severityText == "" {
+		severityText = "INFO"
+	}
+
+	severity, err := newPayloadTemplate("severity", severityText)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/otlp] Invalid SINK_OTLP_SEVERITY: %s", err)
+	}
+
+	attributesStr, ok := os.LookupEnv("SINK_OTLP_ATTRIBUTES")
+	if !ok {
+		attributesStr = "nomad.event.type={{ .Type }},nomad.job.id={{ .JobID }}"
+	}
+
+	attributes, err := newPayloadTemplates(attributesStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/otlp] Invalid SINK_OTLP_ATTRIBUTES: %s", err)
+	}
+
+	batchSize, err := getenvInt("SINK_OTLP_BATCH_SIZE", 512)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/otlp] %s", err)
+	}
+	if batchSize < 1 {
+		return nil, fmt.Errorf("[sink/otlp] Invalid SINK_OTLP_BATCH_SIZE value, must be positive")
+	}
+
+	flushInterval, err := getenvDuration("SINK_OTLP_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/otlp] %s", err)
+	}
+
+	tlsConfig, err := getenvTLSConfig("OTLP", false)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/otlp] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/otlp] %s", err)
+	}
+
+	serviceName := os.Getenv("SINK_OTLP_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "nomad-firehose"
+	}
+
+	resource := &resourcepb.Resource{
+		Attributes: []*commonpb.KeyValue{otlpString("service.name", serviceName)},
+	}
+
+	s := &OTLPSink{
+		protocol:      protocol,
+		endpoint:      endpoint,
+		headers:       headers,
+		resource:      resource,
+		firehose:      os.Getenv("SINK_FIREHOSE"),
+		severity:      severity,
+		attributes:    attributes,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}
+
+	if protocol == "http" {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+
+		s.httpClient = &http.Client{Timeout: 30 * time.Second, Transport: transport}
+		s.endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/logs"
+		return s, nil
+	}
+
+	transport := insecure.NewCredentials()
+	if tlsConfig != nil {
+		transport = credentials.NewTLS(tlsConfig)
+	}
+
+	s.grpcConn, err = grpc.NewClient(endpoint, grpc.WithTransportCredentials(transport))
+	if err != nil {
+		return nil, fmt.Errorf("[sink/otlp] Failed to create client: %s", err)
+	}
+	s.grpcClient = collogspb.NewLogsServiceClient(s.grpcConn)
+
+	return s, nil
+}
+
+// Start ...
+func (s *OTLPSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *OTLPSink) Stop() {
+	log.Infof("[sink/otlp] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/otlp] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer exports the last partial batch when stopping
+	close(s.stopCh)
+	<-s.doneCh
+
+	if s.grpcConn != nil {
+		s.grpcConn.Close()
+	}
+}
+
+// setDeadLetterQueue ...
+func (s *OTLPSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *OTLPSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *OTLPSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *OTLPSink) write() {
+	log.Infof("[sink/otlp] Starting writer to %s", s.endpoint)
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*logspb.LogRecord, 0, s.batchSize)
+	events := make([][]byte, 0, s.batchSize)
+
+	for {
+		select {
+		case <-s.stopCh:
+			s.flush(batch, events)
+			return
+
+		case <-ticker.C:
+			s.flush(batch, events)
+			batch, events = batch[:0], events[:0]
+
+		case data := <-s.putCh:
+			record, err := s.record(data)
+			if err != nil {
+				log.Errorf("[sink/otlp] %s", err)
+				s.retry.undeliverable("otlp", data, err)
+				continue
+			}
+			batch = append(batch, record)
+			events = append(events, data)
+
+			if len(batch) >= s.batchSize {
+				s.flush(batch, events)
+				batch, events = batch[:0], events[:0]
+			}
+		}
+	}
+}
+
+// record convert an event to a log record, with the event as a structured body
+func (s *OTLPSink) record(data []byte) (*logspb.LogRecord, error) {
+	var event interface{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+
+	severityText, err := s.severity.Render(data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not render severity: %s", err)
+	}
+	severityText = strings.ToUpper(severityText)
+
+	attributes, err := renderPayloadTemplates(s.attributes, data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not render attributes: %s", err)
+	}
+
+	fields := extractEventFields(data)
+	if fields.ID != "" {
+		attributes["nomad.event.id"] = fields.ID
+	}
+	if fields.Namespace != "" {
+		attributes["nomad.namespace"] = fields.Namespace
+	}
+	if fields.Region != "" {
+		attributes["nomad.region"] = fields.Region
+	}
+	attributes["nomad.firehose"] = s.firehose
+
+	now := uint64(time.Now().UnixNano())
+
+	record := &logspb.LogRecord{
+		TimeUnixNano:         now,
+		ObservedTimeUnixNano: now,
+		SeverityText:         severityText,
+		SeverityNumber:       otlpSeverityNumber(severityText),
+		Body:                 otlpValue(event),
+	}
+
+	for _, name := range sortedKeys(attributes) {
+		record.Attributes = append(record.Attributes, otlpString(name, attributes[name]))
+	}
+
+	return record, nil
+}
+
+// flush export a batch, retrying failed exports, events being the events of the records
+func (s *OTLPSink) flush(batch []*logspb.LogRecord, events [][]byte) {
+	if len(batch) == 0 {
+		return
+	}
+
+	request := &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{
+			{
+				Resource: s.resource,
+				ScopeLogs: []*logspb.ScopeLogs{
+					{
+						Scope:      &commonpb.InstrumentationScope{Name: "nomad-firehose/" + s.firehose},
+						LogRecords: batch,
+					},
+				},
+			},
+		},
+	}
+
+	err := s.retry.Do("otlp", func() error {
+		return s.export(request)
+	})
+	if err != nil {
+		log.Errorf("[sink/otlp] Failed to export %d log records: %s", len(batch), err)
+		for _, data := range events {
+			s.retry.undeliverable("otlp", data, err)
+		}
+		return
+	}
+
+	log.Debugf("[sink/otlp] Exported %d log records", len(batch))
+	s.retry.delivered(len(events))
+}
+
+// export a request, the errors exporting it again won't fix being permanent
+func (s *OTLPSink) export(request *collogspb.ExportLogsServiceRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if s.grpcClient != nil {
+		_, err := s.grpcClient.Export(metadata.NewOutgoingContext(ctx, metadata.New(s.headers)), request)
+		return grpcRetryable(err)
+	}
+
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return permanent(err)
+	}
+
+	req, err := http.NewRequest("POST", s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return httpRetryable(resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, b))
+	}
+
+	return nil
+}
+
+// otlpSeverityNumber of the standard severity texts
+func otlpSeverityNumber(text string) logspb.SeverityNumber {
+	switch text {
+	case "TRACE":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_TRACE
+	case "DEBUG":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG
+	case "INFO":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_INFO
+	case "WARN", "WARNING":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_WARN
+	case "ERROR":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_ERROR
+	case "FATAL":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_FATAL
+	}
+	return logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED
+}
+
+func otlpString(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+// otlpValue convert a decoded JSON value to an OTLP value
+func otlpValue(value interface{}) *commonpb.AnyValue {
+	switch v := value.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case float64:
+		if v == float64(int64(v)) {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v)}}
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+	case []interface{}:
+		values := make([]*commonpb.AnyValue, 0, len(v))
+		for _, item := range v {
+			values = append(values, otlpValue(item))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		values := make([]*commonpb.KeyValue, 0, len(v))
+		for _, key := range keys {
+			values = append(values, &commonpb.KeyValue{Key: key, Value: otlpValue(v[key])})
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: values}}}
+	}
+
+	// null
+	return &commonpb.AnyValue{}
+}
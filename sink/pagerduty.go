@@ -0,0 +1,367 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// limits of the PagerDuty Events API v2
+const (
+	pagerDutyMaxSummary  = 1024
+	pagerDutyMaxDedupKey = 255
+)
+
+// PagerDutySink trigger and resolve PagerDuty incidents from selected events
+type PagerDutySink struct {
+	client     *http.Client
+	url        string
+	routingKey string
+	source     string
+	firehose   string
+	action     *payloadTemplate
+	dedupKey   *payloadTemplate
+	summary    *payloadTemplate
+	severity   *payloadTemplate
+	retry      *retryPolicy
+	stopCh     chan interface{}
+	putCh      chan []byte
+}
+
+// pagerDutyEvent is the body of the Events API v2
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key,omitempty"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string          `json:"summary"`
+	Source        string          `json:"source"`
+	Severity      string          `json:"severity"`
+	Component     string          `json:"component,omitempty"`
+	Group         string          `json:"group,omitempty"`
+	Class         string          `json:"class,omitempty"`
+	CustomDetails json.RawMessage `json:"custom_details,omitempty"`
+}
+
+// NewPagerDuty ...
+func NewPagerDuty() (*PagerDutySink, error) {
+	routingKey := os.Getenv("SINK_PAGERDUTY_ROUTING_KEY")
+	if routingKey == "" {
+		return nil, fmt.Errorf("[sink/pagerduty] Missing SINK_PAGERDUTY_ROUTING_KEY (integration key of an Events API v2 integration)")
+	}
+
+	url := os.Getenv("SINK_PAGERDUTY_URL")
+	if url == "" {
+		url = "https://events.pagerduty.com/v2/enqueue"
+	}
+
+	// by default nomad-<region> of the event
+	source := os.Getenv("SINK_PAGERDUTY_SOURCE")
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/pagerduty] %s", err)
+	}
+
+	s := &PagerDutySink{
+		client:     &http.Client{Timeout: 30 * time.Second},
+		url:        url,
+		routingKey: routingKey,
+		source:     source,
+		firehose:   os.Getenv("SINK_FIREHOSE"),
+		retry:      retry,
+		stopCh:     make(chan interface{}),
+		putCh:      make(chan []byte, 1000),
+	}
+
+	// unset templates use the built-in mapping
+	templates := []struct {
+		env  string
+		dest **payloadTemplate
+	}{
+		{"SINK_PAGERDUTY_ACTION", &s.action},
+		{"SINK_PAGERDUTY_DEDUP_KEY", &s.dedupKey},
+		{"SINK_PAGERDUTY_SUMMARY", &s.summary},
+		{"SINK_PAGERDUTY_SEVERITY", &s.severity},
+	}
+
+	for _, t := range templates {
+		value := os.Getenv(t.env)
+		if value == "" {
+			continue
+		}
+
+		tmpl, err := newPayloadTemplate(t.env, value)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/pagerduty] Invalid %s: %s", t.env, err)
+		}
+		*t.dest = tmpl
+	}
+
+	return s, nil
+}
+
+// Start ...
+func (s *PagerDutySink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *PagerDutySink) Stop() {
+	log.Infof("[sink/pagerduty] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/pagerduty] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+}
+
+// setDeadLetterQueue ...
+func (s *PagerDutySink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *PagerDutySink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *PagerDutySink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *PagerDutySink) write() {
+	log.Info("[sink/pagerduty] Starting writer")
+
+	for {
+		select {
+		case data := <-s.putCh:
+			event, err := s.event(data)
+			if err != nil {
+				log.Errorf("[sink/pagerduty] %s", err)
+				s.retry.undeliverable("pagerduty", data, err)
+				continue
+			}
+
+			// not an event to page for
+			if event == nil {
+				s.retry.delivered(1)
+				continue
+			}
+
+			err = s.retry.Do("pagerduty", func() error {
+				return s.post(event)
+			})
+			if err != nil {
+				log.Errorf("[sink/pagerduty] Failed to send %s for '%s': %s", event.EventAction, event.DedupKey, err)
+				s.retry.undeliverable("pagerduty", data, err)
+				continue
+			}
+
+			log.Infof("[sink/pagerduty] Sent %s for '%s'", event.EventAction, event.DedupKey)
+			s.retry.delivered(1)
+		}
+	}
+}
+
+// event build the PagerDuty event for an event, or nil if there is no action to take
+func (s *PagerDutySink) event(data []byte) (*pagerDutyEvent, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	d := defaultPagerDutyAlert(s.firehose, fields)
+
+	overrides := []struct {
+		name string
+		tmpl *payloadTemplate
+		dest *string
+	}{
+		{"action", s.action, &d.action},
+		{"dedup key", s.dedupKey, &d.dedupKey},
+		{"summary", s.summary, &d.summary},
+		{"severity", s.severity, &d.severity},
+	}
+
+	for _, o := range overrides {
+		if o.tmpl == nil {
+			continue
+		}
+
+		value, err := o.tmpl.Render(data)
+		if err != nil {
+			return nil, fmt.Errorf("Could not render %s: %s", o.name, err)
+		}
+		*o.dest = value
+	}
+
+	switch d.action {
+	case "":
+		return nil, nil
+	case "trigger", "acknowledge", "resolve":
+	default:
+		return nil, fmt.Errorf("Invalid action '%s', must be one of: trigger, acknowledge, resolve", d.action)
+	}
+
+	if d.dedupKey == "" {
+		return nil, fmt.Errorf("Empty dedup key for %s, skipping it", d.action)
+	}
+
+	event := &pagerDutyEvent{
+		RoutingKey:  s.routingKey,
+		EventAction: d.action,
+		DedupKey:    truncate(d.dedupKey, pagerDutyMaxDedupKey),
+	}
+
+	// only triggers have a payload
+	if d.action == "trigger" {
+		if d.severity == "" {
+			d.severity = "error"
+		}
+		if d.summary == "" {
+			d.summary = "Nomad " + s.firehose + " " + d.dedupKey
+		}
+
+		source := s.source
+		if source == "" {
+			source = "nomad"
+			if region := stringField(fields, "Region"); region != "" {
+				source = "nomad-" + region
+			}
+		}
+
+		event.Payload = &pagerDutyPayload{
+			Summary:       truncate(d.summary, pagerDutyMaxSummary),
+			Source:        source,
+			Severity:      d.severity,
+			Component:     d.component,
+			Group:         stringField(fields, "Namespace"),
+			Class:         s.firehose,
+			CustomDetails: data,
+		}
+	}
+
+	return event, nil
+}
+
+// post an event, the errors posting it again won't fix being permanent
+func (s *PagerDutySink) post(event *pagerDutyEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return permanent(err)
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return httpRetryable(resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, b))
+	}
+
+	return nil
+}
+
+// pagerDutyAlert is what to do in PagerDuty for an event
+type pagerDutyAlert struct {
+	action    string
+	dedupKey  string
+	summary   string
+	severity  string
+	component string
+}
+
+// defaultPagerDutyAlert page for nodes going down, failed deployments and OOM killed tasks, and
+// resolve them when the node is back or a later deployment of the job is successful
+func defaultPagerDutyAlert(firehose string, event map[string]interface{}) pagerDutyAlert {
+	switch firehose {
+	case "nodes":
+		a := pagerDutyAlert{
+			dedupKey:  "nomad/node/" + stringField(event, "ID"),
+			summary:   fmt.Sprintf("Nomad node %s (%s) is down", stringField(event, "Name"), stringField(event, "ID")),
+			severity:  "critical",
+			component: stringField(event, "Name"),
+		}
+
+		switch stringField(event, "Status") {
+		case "down":
+			a.action = "trigger"
+		case "ready":
+			a.action = "resolve"
+		}
+		return a
+
+	case "deployment-events":
+		a := pagerDutyAlert{
+			dedupKey:  "nomad/deployment/" + stringField(event, "Namespace") + "/" + stringField(event, "JobID"),
+			summary:   fmt.Sprintf("Nomad deployment of job %s failed: %s", stringField(event, "JobID"), stringField(event, "StatusDescription")),
+			severity:  "error",
+			component: stringField(event, "JobID"),
+		}
+
+		switch stringField(event, "Type") {
+		case "failed":
+			a.action = "trigger"
+		case "successful":
+			a.action = "resolve"
+		}
+		return a
+
+	case "allocations":
+		taskEvent, _ := event["TaskEvent"].(map[string]interface{})
+		details, _ := taskEvent["Details"].(map[string]interface{})
+
+		if stringField(taskEvent, "Type") != "Terminated" || stringField(details, "oom_killed") != "true" {
+			return pagerDutyAlert{}
+		}
+
+		return pagerDutyAlert{
+			action:    "trigger",
+			dedupKey:  "nomad/oom/" + stringField(event, "AllocationID") + "/" + stringField(event, "TaskName"),
+			summary:   fmt.Sprintf("Nomad task %s of job %s was OOM killed (allocation %s)", stringField(event, "TaskName"), stringField(event, "JobID"), stringField(event, "AllocationID")),
+			severity:  "warning",
+			component: stringField(event, "JobID"),
+		}
+	}
+
+	return pagerDutyAlert{}
+}
+
+// stringField return a string field of a decoded JSON object, or an empty string
+func stringField(object map[string]interface{}, name string) string {
+	value, _ := object[name].(string)
+	return value
+}
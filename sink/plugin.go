@@ -0,0 +1,166 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/seatgeek/nomad-firehose/sink/plugin"
+	log "github.com/sirupsen/logrus"
+)
+
+// PluginSink send events to a sink implemented by an external plugin binary, see sink/plugin
+type PluginSink struct {
+	client *goplugin.Client
+	remote plugin.Sink
+	path   string
+	retry  *retryPolicy
+	stopCh chan interface{}
+	putCh  chan []byte
+}
+
+// NewPlugin ...
+func NewPlugin() (*PluginSink, error) {
+	path := os.Getenv("SINK_PLUGIN_PATH")
+
+	// plugins named nomad-firehose-sink-<name> in the plugin directory
+	if path == "" {
+		name := os.Getenv("SINK_PLUGIN_NAME")
+		if name == "" {
+			return nil, fmt.Errorf("[sink/plugin] Missing SINK_PLUGIN_PATH (example: /usr/local/bin/nomad-firehose-sink-foo) or SINK_PLUGIN_NAME (example: foo)")
+		}
+
+		dir := os.Getenv("SINK_PLUGIN_DIR")
+		if dir == "" {
+			dir = "/usr/local/lib/nomad-firehose/plugins"
+		}
+
+		path = filepath.Join(dir, "nomad-firehose-sink-"+name)
+	}
+	log.Infof("[sink/plugin] Using plugin %s", path)
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("[sink/plugin] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/plugin] %s", err)
+	}
+
+	client := goplugin.NewClient(&goplugin.ClientConfig{
+		HandshakeConfig:  plugin.Handshake,
+		Plugins:          plugin.PluginMap,
+		Cmd:              exec.Command(path),
+		AllowedProtocols: []goplugin.Protocol{goplugin.ProtocolGRPC},
+		Logger: hclog.New(&hclog.LoggerOptions{
+			Name:   "sink/plugin",
+			Level:  hclog.Info,
+			Output: os.Stderr,
+		}),
+	})
+
+	rpcClient, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("[sink/plugin] Failed to start plugin %s: %s", path, err)
+	}
+
+	raw, err := rpcClient.Dispense("sink")
+	if err != nil {
+		client.Kill()
+		return nil, fmt.Errorf("[sink/plugin] Failed to start plugin %s: %s", path, err)
+	}
+
+	return &PluginSink{
+		client: client,
+		remote: raw.(plugin.Sink),
+		path:   path,
+		retry:  retry,
+		stopCh: make(chan interface{}),
+		putCh:  make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *PluginSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	if err := s.remote.Start(); err != nil {
+		return fmt.Errorf("[sink/plugin] Failed to start sink: %s", err)
+	}
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *PluginSink) Stop() {
+	log.Infof("[sink/plugin] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/plugin] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+
+	// the plugin delivers the events it got before stopping
+	s.remote.Stop()
+	s.client.Kill()
+}
+
+// setDeadLetterQueue ...
+func (s *PluginSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *PluginSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *PluginSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *PluginSink) write() {
+	log.Infof("[sink/plugin] Starting writer to %s", s.path)
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+
+		case data := <-s.putCh:
+			// the plugin reports invalid events with the gRPC status codes
+			err := s.retry.Do("plugin", func() error {
+				return grpcRetryable(s.remote.Put(data))
+			})
+			if err != nil {
+				log.Errorf("[sink/plugin] %s", err)
+				s.retry.undeliverable("plugin", data, err)
+				continue
+			}
+			s.retry.delivered(1)
+		}
+	}
+}
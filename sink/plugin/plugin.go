@@ -0,0 +1,171 @@
+// Package plugin serves sinks implemented as separate binaries, and is used by nomad-firehose
+// to run them, over HashiCorp go-plugin and gRPC (see proto/sink_plugin.proto)
+//
+// A plugin is a binary calling Serve with its sink:
+//
+//	func main() {
+//		s, err := NewMySink()
+//		if err != nil {
+//			log.Fatal(err)
+//		}
+//		plugin.Serve(s)
+//	}
+//
+// Plugins are started with the environment of nomad-firehose, so they can be configured with
+// their own SINK_* environment variables like the built-in sinks.
+package plugin
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Handshake is shared by nomad-firehose and the plugins, to only run binaries built as plugins
+var Handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "NOMAD_FIREHOSE_PLUGIN",
+	MagicCookieValue: "sink",
+}
+
+// Sink is implemented by plugins, it's the same as sink.Sink
+type Sink interface {
+	Start() error
+	Stop()
+	Put(data []byte) error
+}
+
+// PluginMap is the plugins served by a plugin binary
+var PluginMap = map[string]plugin.Plugin{
+	"sink": &GRPCSinkPlugin{},
+}
+
+// Serve the sink as a plugin, it never returns
+func Serve(s Sink) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins: map[string]plugin.Plugin{
+			"sink": &GRPCSinkPlugin{Impl: s},
+		},
+		GRPCServer: plugin.DefaultGRPCServer,
+	})
+}
+
+// GRPCSinkPlugin is the go-plugin definition of a sink
+type GRPCSinkPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	Impl Sink
+}
+
+// GRPCServer register the sink service, in the plugin
+func (p *GRPCSinkPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
+	s.RegisterService(&sinkServiceDesc, &grpcServer{impl: p.Impl})
+	return nil
+}
+
+// GRPCClient return a Sink calling the plugin, in nomad-firehose
+func (p *GRPCSinkPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return &grpcClient{conn: c}, nil
+}
+
+const sinkServiceName = "nomad_firehose.plugin.v1.Sink"
+
+// sinkServer is the service implemented by grpcServer, checked by RegisterService
+type sinkServer interface {
+	start() error
+	stop()
+	put(data []byte) error
+}
+
+// sinkServiceDesc is what protoc would generate for proto/sink_plugin.proto, the messages are
+// well known types so no generated code is needed
+var sinkServiceDesc = grpc.ServiceDesc{
+	ServiceName: sinkServiceName,
+	HandlerType: (*sinkServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Start",
+			Handler: unaryHandler("Start", func() proto.Message { return &emptypb.Empty{} }, func(s sinkServer, in proto.Message) error {
+				return s.start()
+			}),
+		},
+		{
+			MethodName: "Stop",
+			Handler: unaryHandler("Stop", func() proto.Message { return &emptypb.Empty{} }, func(s sinkServer, in proto.Message) error {
+				s.stop()
+				return nil
+			}),
+		},
+		{
+			MethodName: "Put",
+			Handler: unaryHandler("Put", func() proto.Message { return &wrapperspb.BytesValue{} }, func(s sinkServer, in proto.Message) error {
+				return s.put(in.(*wrapperspb.BytesValue).GetValue())
+			}),
+		},
+	},
+	Metadata: "proto/sink_plugin.proto",
+}
+
+// unaryHandler of a method returning google.protobuf.Empty
+func unaryHandler(method string, newIn func() proto.Message, call func(sinkServer, proto.Message) error) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := newIn()
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			if err := call(srv.(sinkServer), req.(proto.Message)); err != nil {
+				return nil, err
+			}
+			return &emptypb.Empty{}, nil
+		}
+
+		if interceptor == nil {
+			return handler(ctx, in)
+		}
+
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + sinkServiceName + "/" + method}
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+// grpcServer serve the sink of a plugin
+type grpcServer struct {
+	impl Sink
+}
+
+// start the sink in the background, Sink.Start only returns once the sink is stopped
+func (s *grpcServer) start() error {
+	go s.impl.Start()
+	return nil
+}
+
+func (s *grpcServer) stop() {
+	s.impl.Stop()
+}
+
+func (s *grpcServer) put(data []byte) error {
+	return s.impl.Put(data)
+}
+
+// grpcClient call the sink of a plugin
+type grpcClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *grpcClient) Start() error {
+	return c.conn.Invoke(context.Background(), "/"+sinkServiceName+"/Start", &emptypb.Empty{}, &emptypb.Empty{})
+}
+
+func (c *grpcClient) Stop() {
+	c.conn.Invoke(context.Background(), "/"+sinkServiceName+"/Stop", &emptypb.Empty{}, &emptypb.Empty{})
+}
+
+func (c *grpcClient) Put(data []byte) error {
+	return c.conn.Invoke(context.Background(), "/"+sinkServiceName+"/Put", wrapperspb.Bytes(data), &emptypb.Empty{})
+}
@@ -0,0 +1,215 @@
+package sink
+
+//go:generate protoc -I.. --go_out=.. --go_opt=module=github.com/seatgeek/nomad-firehose ../proto/events.proto
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/seatgeek/nomad-firehose/proto/eventsv1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// protobufEventField is the number of the field holding the whole event, in the messages of the
+// events which are a Nomad API object themselves
+const protobufEventField = 101
+
+// protobufMessages are the messages of proto/events.proto of every firehose
+var protobufMessages = map[string]func() proto.Message{
+	"allocations":         func() proto.Message { return &eventsv1.AllocationUpdate{} },
+	"nodes":               func() proto.Message { return &eventsv1.Node{} },
+	"evaluations":         func() proto.Message { return &eventsv1.Evaluation{} },
+	"jobs":                func() proto.Message { return &eventsv1.Job{} },
+	"deployments":         func() proto.Message { return &eventsv1.Deployment{} },
+	"events":              func() proto.Message { return &eventsv1.Event{} },
+	"services":            func() proto.Message { return &eventsv1.ServiceUpdate{} },
+	"csi-volumes":         func() proto.Message { return &eventsv1.CSIVolume{} },
+	"csi-plugins":         func() proto.Message { return &eventsv1.CSIPlugin{} },
+	"namespaces":          func() proto.Message { return &eventsv1.NamespaceUpdate{} },
+	"quotas":              func() proto.Message { return &eventsv1.QuotaUpdate{} },
+	"acl":                 func() proto.Message { return &eventsv1.ACLUpdate{} },
+	"variables":           func() proto.Message { return &eventsv1.VariableUpdate{} },
+	"scaling":             func() proto.Message { return &eventsv1.ScalingUpdate{} },
+	"job-diffs":           func() proto.Message { return &eventsv1.JobDiffUpdate{} },
+	"periodic-launches":   func() proto.Message { return &eventsv1.PeriodicLaunch{} },
+	"dispatches":          func() proto.Message { return &eventsv1.Dispatch{} },
+	"node-pools":          func() proto.Message { return &eventsv1.NodePoolUpdate{} },
+	"node-events":         func() proto.Message { return &eventsv1.NodeEvent{} },
+	"taskstates":          func() proto.Message { return &eventsv1.TaskStateUpdate{} },
+	"allocation-stats":    func() proto.Message { return &eventsv1.AllocationStats{} },
+	"job-summaries":       func() proto.Message { return &eventsv1.JobSummary{} },
+	"logs":                func() proto.Message { return &eventsv1.LogLine{} },
+	"members":             func() proto.Message { return &eventsv1.MemberUpdate{} },
+	"operator":            func() proto.Message { return &eventsv1.OperatorUpdate{} },
+	"host-volumes":        func() proto.Message { return &eventsv1.HostVolumeUpdate{} },
+	"recommendations":     func() proto.Message { return &eventsv1.RecommendationUpdate{} },
+	"sentinel-policies":   func() proto.Message { return &eventsv1.SentinelPolicyUpdate{} },
+	"deployment-events":   func() proto.Message { return &eventsv1.DeploymentEvent{} },
+	"blocked-evaluations": func() proto.Message { return &eventsv1.PlacementStarved{} },
+	"license":             func() proto.Message { return &eventsv1.LicenseUpdate{} },
+}
+
+// protobufEncoder encode JSON events as the protobuf message of their firehose in
+// proto/events.proto, the fields of the message being the fields of the event with the same
+// name, ignoring case and underscores (node_id for NodeID)
+type protobufEncoder struct {
+	message func() proto.Message
+}
+
+// newProtobufEncoder ...
+func newProtobufEncoder(firehose string) (*protobufEncoder, error) {
+	message, ok := protobufMessages[firehose]
+	if !ok {
+		return nil, fmt.Errorf("No protobuf message for the %s firehose", firehose)
+	}
+
+	return &protobufEncoder{message: message}, nil
+}
+
+// Encode a JSON event, fields missing from the event or null are left unset
+func (e *protobufEncoder) Encode(data []byte) ([]byte, error) {
+	var event map[string]interface{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{}, len(event))
+	for name, value := range event {
+		values[protobufName(name)] = value
+	}
+
+	message := e.message()
+	m := message.ProtoReflect()
+
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+
+		var value interface{} = event
+		if field.Number() != protobufEventField {
+			value = values[protobufName(string(field.Name()))]
+		}
+		if value == nil {
+			continue
+		}
+
+		if err := setProtobufField(m, field, value); err != nil {
+			return nil, fmt.Errorf("Invalid field %s of the message: %s", field.Name(), err)
+		}
+	}
+
+	return proto.MarshalOptions{Deterministic: true}.Marshal(message)
+}
+
+// protobufName is the name of a field of the event or of the message, lower case without
+// underscores
+func protobufName(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "", -1))
+}
+
+func setProtobufField(m protoreflect.Message, field protoreflect.FieldDescriptor, value interface{}) error {
+	switch {
+	case field.IsList():
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected a list")
+		}
+
+		list := m.Mutable(field).List()
+		for _, item := range items {
+			v, err := protobufValue(field, item)
+			if err != nil {
+				return err
+			}
+			list.Append(v)
+		}
+		return nil
+
+	case field.IsMap():
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected an object")
+		}
+
+		entries := m.Mutable(field).Map()
+		for key, item := range object {
+			v, err := protobufValue(field.MapValue(), item)
+			if err != nil {
+				return err
+			}
+			entries.Set(protoreflect.ValueOfString(key).MapKey(), v)
+		}
+		return nil
+	}
+
+	v, err := protobufValue(field, value)
+	if err != nil {
+		return err
+	}
+	m.Set(field, v)
+	return nil
+}
+
+// protobufValue convert a JSON value to the type of a field, or of the items of a repeated field
+func protobufValue(field protoreflect.FieldDescriptor, value interface{}) (protoreflect.Value, error) {
+	switch field.Kind() {
+	case protoreflect.StringKind:
+		s, ok := value.(string)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("expected a string")
+		}
+		return protoreflect.ValueOfString(s), nil
+
+	case protoreflect.BoolKind:
+		v, ok := value.(bool)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("expected a boolean")
+		}
+		return protoreflect.ValueOfBool(v), nil
+
+	case protoreflect.Int64Kind, protoreflect.Uint64Kind, protoreflect.DoubleKind:
+		n, ok := value.(float64)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("expected a number")
+		}
+		switch field.Kind() {
+		case protoreflect.Int64Kind:
+			return protoreflect.ValueOfInt64(int64(n)), nil
+		case protoreflect.Uint64Kind:
+			return protoreflect.ValueOfUint64(uint64(n)), nil
+		}
+		return protoreflect.ValueOfFloat64(n), nil
+
+	case protoreflect.MessageKind:
+		switch field.Message().FullName() {
+		case "google.protobuf.Timestamp":
+			s, ok := value.(string)
+			if !ok {
+				return protoreflect.Value{}, fmt.Errorf("expected a time")
+			}
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			return protoreflect.ValueOfMessage(timestamppb.New(t).ProtoReflect()), nil
+
+		case "google.protobuf.Struct":
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return protoreflect.Value{}, fmt.Errorf("expected an object")
+			}
+			s, err := structpb.NewStruct(object)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			return protoreflect.ValueOfMessage(s.ProtoReflect()), nil
+		}
+	}
+
+	return protoreflect.Value{}, fmt.Errorf("unsupported field type %s", field.Kind())
+}
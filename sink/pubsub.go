@@ -0,0 +1,213 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	log "github.com/sirupsen/logrus"
+)
+
+// PubSubSink ...
+type PubSubSink struct {
+	client      *pubsub.Client
+	topic       *pubsub.Topic
+	orderingKey *payloadTemplate
+	attributes  map[string]*payloadTemplate
+	encode      func(data []byte) ([]byte, error)
+	retry       *retryPolicy
+	inflight    sync.WaitGroup
+	stopCh      chan interface{}
+	putCh       chan []byte
+}
+
+// NewPubSub ...
+func NewPubSub() (*PubSubSink, error) {
+	project := os.Getenv("SINK_PUBSUB_PROJECT")
+	if project == "" {
+		return nil, fmt.Errorf("[sink/pubsub] Missing SINK_PUBSUB_PROJECT (example: my-gcp-project)")
+	}
+	log.Infof("[sink/pubsub] SINK_PUBSUB_PROJECT=%s", project)
+
+	topicName := os.Getenv("SINK_PUBSUB_TOPIC")
+	if topicName == "" {
+		return nil, fmt.Errorf("[sink/pubsub] Missing SINK_PUBSUB_TOPIC (example: nomad-firehose)")
+	}
+	log.Infof("[sink/pubsub] SINK_PUBSUB_TOPIC=%s", topicName)
+
+	attributes, err := newPayloadTemplates(os.Getenv("SINK_PUBSUB_ATTRIBUTES"))
+	if err != nil {
+		return nil, fmt.Errorf("[sink/pubsub] Invalid SINK_PUBSUB_ATTRIBUTES: %s", err)
+	}
+
+	encode, err := newEventEncoder("PUBSUB")
+	if err != nil {
+		return nil, fmt.Errorf("[sink/pubsub] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/pubsub] %s", err)
+	}
+
+	// credentials are resolved through Application Default Credentials
+	client, err := pubsub.NewClient(context.Background(), project)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/pubsub] Failed to create Pub/Sub client: %s", err)
+	}
+
+	topic := client.Topic(topicName)
+
+	s := &PubSubSink{
+		client:     client,
+		topic:      topic,
+		attributes: attributes,
+		encode:     encode,
+		retry:      retry,
+		stopCh:     make(chan interface{}),
+		putCh:      make(chan []byte, 1000),
+	}
+
+	if orderingKey := os.Getenv("SINK_PUBSUB_ORDERING_KEY"); orderingKey != "" {
+		log.Infof("[sink/pubsub] SINK_PUBSUB_ORDERING_KEY=%s", orderingKey)
+
+		s.orderingKey, err = newPayloadTemplate("ordering-key", orderingKey)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/pubsub] Invalid SINK_PUBSUB_ORDERING_KEY: %s", err)
+		}
+		topic.EnableMessageOrdering = true
+	}
+
+	return s, nil
+}
+
+// Start ...
+func (s *PubSubSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *PubSubSink) Stop() {
+	log.Infof("[sink/pubsub] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/pubsub] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// wait for the results of the batched messages, published again when failing, then flush
+	// what's left
+	s.inflight.Wait()
+	s.topic.Stop()
+
+	close(s.stopCh)
+	defer s.client.Close()
+}
+
+// setDeadLetterQueue ...
+func (s *PubSubSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *PubSubSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *PubSubSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *PubSubSink) write() {
+	log.Info("[sink/pubsub] Starting writer")
+
+	for {
+		select {
+		case data := <-s.putCh:
+			message := &pubsub.Message{Data: data}
+
+			attributes, err := renderPayloadTemplates(s.attributes, data)
+			if err != nil {
+				log.Errorf("[sink/pubsub] Could not render attributes: %s", err)
+				s.retry.undeliverable("pubsub", data, err)
+				continue
+			}
+			if len(attributes) > 0 {
+				message.Attributes = attributes
+			}
+
+			if s.orderingKey != nil {
+				message.OrderingKey, err = s.orderingKey.Render(data)
+				if err != nil {
+					log.Errorf("[sink/pubsub] Could not render ordering key: %s", err)
+					s.retry.undeliverable("pubsub", data, err)
+					continue
+				}
+			}
+
+			// attributes and ordering key are rendered from the JSON event
+			if s.encode != nil {
+				message.Data, err = s.encode(data)
+				if err != nil {
+					log.Errorf("[sink/pubsub] Failed to encode message: %s", err)
+					s.retry.undeliverable("pubsub", data, err)
+					continue
+				}
+			}
+
+			result := s.topic.Publish(context.Background(), message)
+
+			// messages are batched by the client, wait for the result without blocking the writer,
+			// publishing the message again when it failed
+			s.inflight.Add(1)
+			go func(data []byte, message *pubsub.Message, result *pubsub.PublishResult) {
+				defer s.inflight.Done()
+
+				var id string
+				err := s.retry.Do("pubsub", func() error {
+					if result == nil {
+						result = s.topic.Publish(context.Background(), message)
+					}
+
+					var err error
+					id, err = result.Get(context.Background())
+					result = nil
+
+					// publishing for an ordering key is paused after a failure until resumed
+					if err != nil && message.OrderingKey != "" {
+						s.topic.ResumePublish(message.OrderingKey)
+					}
+					return grpcRetryable(err)
+				})
+				if err != nil {
+					log.Errorf("[sink/pubsub] %s", err)
+					s.retry.undeliverable("pubsub", data, err)
+					return
+				}
+
+				log.Debugf("[sink/pubsub] Published message %s", id)
+				s.retry.delivered(1)
+			}(data, message, result)
+		}
+	}
+}
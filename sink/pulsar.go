@@ -0,0 +1,230 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	log "github.com/sirupsen/logrus"
+)
+
+// PulsarSink ...
+type PulsarSink struct {
+	client   pulsar.Client
+	producer pulsar.Producer
+	topic    string
+	key      *payloadTemplate
+	retry    *retryPolicy
+	inflight sync.WaitGroup
+	stopCh   chan interface{}
+	putCh    chan []byte
+}
+
+// NewPulsar ...
+func NewPulsar() (*PulsarSink, error) {
+	url := os.Getenv("SINK_PULSAR_URL")
+	if url == "" {
+		return nil, fmt.Errorf("[sink/pulsar] Missing SINK_PULSAR_URL (example: pulsar://127.0.0.1:6650 or pulsar+ssl://127.0.0.1:6651)")
+	}
+	log.Infof("[sink/pulsar] SINK_PULSAR_URL=%s", url)
+
+	topic := os.Getenv("SINK_PULSAR_TOPIC")
+	if topic == "" {
+		return nil, fmt.Errorf("[sink/pulsar] Missing SINK_PULSAR_TOPIC (example: persistent://public/default/nomad-firehose)")
+	}
+	log.Infof("[sink/pulsar] SINK_PULSAR_TOPIC=%s", topic)
+
+	clientOptions := pulsar.ClientOptions{
+		URL:                   url,
+		TLSTrustCertsFilePath: os.Getenv("SINK_PULSAR_TLS_TRUST_CERTS"),
+	}
+
+	allowInsecure, err := getenvBool("SINK_PULSAR_TLS_ALLOW_INSECURE", false)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/pulsar] %s", err)
+	}
+	clientOptions.TLSAllowInsecureConnection = allowInsecure
+
+	switch {
+	case os.Getenv("SINK_PULSAR_TOKEN") != "":
+		clientOptions.Authentication = pulsar.NewAuthenticationToken(os.Getenv("SINK_PULSAR_TOKEN"))
+	case os.Getenv("SINK_PULSAR_TOKEN_FILE") != "":
+		clientOptions.Authentication = pulsar.NewAuthenticationTokenFromFile(os.Getenv("SINK_PULSAR_TOKEN_FILE"))
+	case os.Getenv("SINK_PULSAR_TLS_CERT") != "":
+		clientOptions.Authentication = pulsar.NewAuthenticationTLS(os.Getenv("SINK_PULSAR_TLS_CERT"), os.Getenv("SINK_PULSAR_TLS_KEY"))
+	}
+
+	batching, err := getenvBool("SINK_PULSAR_BATCHING", true)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/pulsar] %s", err)
+	}
+
+	batchingMaxDelay, err := getenvDuration("SINK_PULSAR_BATCHING_MAX_DELAY", 10*time.Millisecond)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/pulsar] %s", err)
+	}
+
+	batchingMaxMessages, err := getenvInt("SINK_PULSAR_BATCHING_MAX_MESSAGES", 1000)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/pulsar] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/pulsar] %s", err)
+	}
+
+	s := &PulsarSink{
+		topic:  topic,
+		retry:  retry,
+		stopCh: make(chan interface{}),
+		putCh:  make(chan []byte, 1000),
+	}
+
+	if key := os.Getenv("SINK_PULSAR_KEY"); key != "" {
+		s.key, err = newPayloadTemplate("key", key)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/pulsar] Invalid SINK_PULSAR_KEY: %s", err)
+		}
+	}
+
+	client, err := pulsar.NewClient(clientOptions)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/pulsar] Failed to create Pulsar client: %s", err)
+	}
+
+	producer, err := client.CreateProducer(pulsar.ProducerOptions{
+		Topic:                   topic,
+		DisableBatching:         !batching,
+		BatchingMaxPublishDelay: batchingMaxDelay,
+		BatchingMaxMessages:     uint(batchingMaxMessages),
+	})
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("[sink/pulsar] Failed to create producer: %s", err)
+	}
+
+	s.client = client
+	s.producer = producer
+
+	return s, nil
+}
+
+// Start ...
+func (s *PulsarSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *PulsarSink) Stop() {
+	log.Infof("[sink/pulsar] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/pulsar] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// send the pending batch before closing, and wait for the failed messages to be sent again
+	if err := s.producer.Flush(); err != nil {
+		log.Errorf("[sink/pulsar] Failed to flush producer: %s", err)
+	}
+	s.inflight.Wait()
+
+	close(s.stopCh)
+	s.producer.Close()
+	s.client.Close()
+}
+
+// setDeadLetterQueue ...
+func (s *PulsarSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *PulsarSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *PulsarSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *PulsarSink) write() {
+	log.Infof("[sink/pulsar] Starting writer to '%s'", s.topic)
+
+	for {
+		select {
+		case data := <-s.putCh:
+			message := &pulsar.ProducerMessage{Payload: data}
+
+			if s.key != nil {
+				key, err := s.key.Render(data)
+				if err != nil {
+					log.Errorf("[sink/pulsar] Could not render key: %s", err)
+					s.retry.undeliverable("pulsar", data, err)
+					continue
+				}
+				message.Key = key
+			}
+
+			s.inflight.Add(1)
+			s.producer.SendAsync(context.Background(), message, func(id pulsar.MessageID, message *pulsar.ProducerMessage, err error) {
+				if err != nil {
+					// the callback runs on the producer, which can't send while blocked in it
+					go s.resend(data, message, err)
+					return
+				}
+
+				log.Debugf("[sink/pulsar] Published message %s", id)
+				s.retry.delivered(1)
+				s.inflight.Done()
+			})
+		}
+	}
+}
+
+// resend a message whose asynchronous send failed with err, the failed send being its first attempt
+func (s *PulsarSink) resend(data []byte, message *pulsar.ProducerMessage, err error) {
+	defer s.inflight.Done()
+
+	failed := err
+	err = s.retry.Do("pulsar", func() error {
+		if failed != nil {
+			err, failed = failed, nil
+			return err
+		}
+
+		id, err := s.producer.Send(context.Background(), message)
+		if err == nil {
+			log.Debugf("[sink/pulsar] Published message %s", id)
+		}
+		return err
+	})
+	if err != nil {
+		log.Errorf("[sink/pulsar] %s", err)
+		s.retry.undeliverable("pulsar", data, err)
+		return
+	}
+
+	s.retry.delivered(1)
+}
@@ -0,0 +1,152 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// QueueSink put the events of the firehose in a bounded queue in front of the sink, configured by
+// SINK_QUEUE_SIZE. When the queue is full, SINK_QUEUE_POLICY either blocks the firehose until the
+// sink catches up (block), or drops the oldest queued event (drop-oldest). It also tracks the
+// events not acknowledged yet, for the firehoses to only persist the index of delivered events
+type QueueSink struct {
+	Sink
+	dropOldest bool
+	queueCh    chan []byte
+	stopCh     chan interface{}
+	doneCh     chan interface{}
+	dropped    uint64
+	lock       sync.Mutex
+
+	acks *ackTracker
+	// the sink acknowledges the events it delivered, otherwise they're acknowledged once handed
+	// to it
+	acking bool
+}
+
+// NewQueue ...
+func NewQueue(s Sink) (*QueueSink, error) {
+	size, err := getenvInt("SINK_QUEUE_SIZE", 10000)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/queue] %s", err)
+	}
+	if size < 1 {
+		return nil, fmt.Errorf("[sink/queue] Invalid SINK_QUEUE_SIZE value, must be positive")
+	}
+
+	var dropOldest bool
+	switch policy := os.Getenv("SINK_QUEUE_POLICY"); policy {
+	case "", "block":
+	case "drop-oldest":
+		dropOldest = true
+	default:
+		return nil, fmt.Errorf("[sink/queue] Invalid SINK_QUEUE_POLICY value, must be one of: block, drop-oldest")
+	}
+
+	acks := newAckTracker()
+
+	// the dead-letter sink acknowledges the events the sink gave up on
+	inner := s
+	if d, ok := s.(*DeadLetterSink); ok {
+		inner = d.Sink
+	}
+	a, acking := inner.(acknowledging)
+	if acking {
+		a.setAckTracker(acks)
+	}
+
+	return &QueueSink{
+		Sink:       s,
+		dropOldest: dropOldest,
+		queueCh:    make(chan []byte, size),
+		stopCh:     make(chan interface{}),
+		doneCh:     make(chan interface{}),
+		acks:       acks,
+		acking:     acking,
+	}, nil
+}
+
+// Start ...
+func (s *QueueSink) Start() error {
+	go s.forward()
+
+	return s.Sink.Start()
+}
+
+// Stop the sink once it got all the queued events
+func (s *QueueSink) Stop() {
+	log.Infof("[sink/queue] ensure queue is empty (%d messages left)", len(s.queueCh))
+
+	for len(s.queueCh) > 0 {
+		log.Infof("[sink/queue] Waiting for queue to drain - (%d messages left)", len(s.queueCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the forwarder hands the event it's on to the sink
+	close(s.stopCh)
+	<-s.doneCh
+
+	s.Sink.Stop()
+}
+
+// WaitForAcks wait for the events put so far to be acknowledged, while new events are still put,
+// and tell if no event was lost since the last wait
+func (s *QueueSink) WaitForAcks() bool {
+	return s.acks.wait()
+}
+
+// Put queue an event, blocking or dropping the oldest event when the queue is full
+func (s *QueueSink) Put(data []byte) error {
+	s.acks.add(1)
+
+	if !s.dropOldest {
+		s.queueCh <- data
+		return nil
+	}
+
+	// several firehose watchers may put at the same time
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for {
+		select {
+		case s.queueCh <- data:
+			return nil
+		default:
+		}
+
+		select {
+		case <-s.queueCh:
+			// dropping events is what the policy is for, they don't hold back the index
+			s.acks.Ack(s.acks.forEvents(1))
+			s.dropped++
+			if s.dropped == 1 || s.dropped%1000 == 0 {
+				log.Errorf("[sink/queue] Queue is full, %d oldest events dropped so far", s.dropped)
+			}
+		default:
+		}
+	}
+}
+
+// forward the queued events to the sink
+func (s *QueueSink) forward() {
+	defer close(s.doneCh)
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case data := <-s.queueCh:
+			if err := s.Sink.Put(data); err != nil {
+				log.Errorf("[sink/queue] %s", err)
+				s.acks.Lose(s.acks.forEvents(1))
+			} else if !s.acking {
+				s.acks.Ack(1)
+			}
+		}
+	}
+}
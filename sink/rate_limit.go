@@ -0,0 +1,124 @@
+package sink
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// how often the rate limiter logs how many events it delayed and dropped
+const rateLimitReportInterval = time.Minute
+
+// RateLimitSink limit the events put to the sink to SINK_RATE_LIMIT per second, with bursts of
+// SINK_RATE_LIMIT_BURST events, so a cold start or an index rewind doesn't flood the destination.
+// Events over the limit are either delayed, holding back the firehose (delay), or dropped (drop)
+type RateLimitSink struct {
+	Sink
+	limiter *rate.Limiter
+	drop    bool
+	delayed uint64
+	dropped uint64
+	stopCh  chan interface{}
+}
+
+// NewRateLimit ...
+func NewRateLimit(s Sink, limit float64) (*RateLimitSink, error) {
+	burst, err := getenvInt("SINK_RATE_LIMIT_BURST", int(math.Max(1, math.Ceil(limit))))
+	if err != nil {
+		return nil, fmt.Errorf("[sink/rate-limit] %s", err)
+	}
+	if burst < 1 {
+		return nil, fmt.Errorf("[sink/rate-limit] Invalid SINK_RATE_LIMIT_BURST value, must be positive")
+	}
+
+	var drop bool
+	switch policy := os.Getenv("SINK_RATE_LIMIT_POLICY"); policy {
+	case "", "delay":
+	case "drop":
+		drop = true
+	default:
+		return nil, fmt.Errorf("[sink/rate-limit] Invalid SINK_RATE_LIMIT_POLICY value, must be one of: delay, drop")
+	}
+
+	log.Infof("[sink/rate-limit] Limiting events to %g per second, bursts of %d", limit, burst)
+
+	return &RateLimitSink{
+		Sink:    s,
+		limiter: rate.NewLimiter(rate.Limit(limit), burst),
+		drop:    drop,
+		stopCh:  make(chan interface{}),
+	}, nil
+}
+
+// Start ...
+func (s *RateLimitSink) Start() error {
+	go s.report()
+
+	return s.Sink.Start()
+}
+
+// Stop ...
+func (s *RateLimitSink) Stop() {
+	close(s.stopCh)
+
+	s.Sink.Stop()
+}
+
+// WaitForAcks ...
+func (s *RateLimitSink) WaitForAcks() bool {
+	return Acknowledged(s.Sink)
+}
+
+// Put wait until the event is within the limit, or drop it
+func (s *RateLimitSink) Put(data []byte) error {
+	if s.drop {
+		if !s.limiter.Allow() {
+			atomic.AddUint64(&s.dropped, 1)
+			return nil
+		}
+		return s.Sink.Put(data)
+	}
+
+	if delay := s.limiter.Reserve().Delay(); delay > 0 {
+		atomic.AddUint64(&s.delayed, 1)
+		time.Sleep(delay)
+	}
+
+	return s.Sink.Put(data)
+}
+
+// Delayed is the number of events delayed by the limit so far
+func (s *RateLimitSink) Delayed() uint64 {
+	return atomic.LoadUint64(&s.delayed)
+}
+
+// Dropped is the number of events dropped by the limit so far
+func (s *RateLimitSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// report log the events delayed and dropped, when it changed
+func (s *RateLimitSink) report() {
+	ticker := time.NewTicker(rateLimitReportInterval)
+	defer ticker.Stop()
+
+	var delayed, dropped uint64
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			if s.Delayed() == delayed && s.Dropped() == dropped {
+				continue
+			}
+
+			delayed, dropped = s.Delayed(), s.Dropped()
+			log.Warnf("[sink/rate-limit] %d events delayed and %d events dropped so far", delayed, dropped)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 type RedisSink struct {
 	pool   *redis.Pool
 	key    string
+	retry  *retryPolicy
 	stopCh chan interface{}
 	putCh  chan []byte
 }
@@ -29,6 +30,11 @@ func NewRedis() (*RedisSink, error) {
 		return nil, fmt.Errorf("[sink/redis] Missing SINK_REDIS_KEY (example: my-key")
 	}
 
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/redis] %s", err)
+	}
+
 	redisPool := redis.Pool{
 		MaxIdle:     2,
 		MaxActive:   2,
@@ -43,6 +49,7 @@ func NewRedis() (*RedisSink, error) {
 	return &RedisSink{
 		pool:   &redisPool,
 		key:    redisKey,
+		retry:  retry,
 		stopCh: make(chan interface{}),
 		putCh:  make(chan []byte, 1000),
 	}, nil
@@ -80,6 +87,16 @@ func (s *RedisSink) Stop() {
 	defer s.pool.Close()
 }
 
+// setDeadLetterQueue ...
+func (s *RedisSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *RedisSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
 // Put ..
 func (s *RedisSink) Put(data []byte) error {
 	s.putCh <- data
@@ -92,13 +109,20 @@ func (s *RedisSink) write() {
 	for {
 		select {
 		case data := <-s.putCh:
-			conn := s.pool.Get()
-			if _, err := conn.Do("RPUSH", s.key, data); err != nil {
+			err := s.retry.Do("redis", func() error {
+				conn := s.pool.Get()
+				defer conn.Close()
+
+				_, err := conn.Do("RPUSH", s.key, data)
+				return err
+			})
+			if err != nil {
 				log.Infof("[sink/redis] %s", err)
+				s.retry.undeliverable("redis", data, err)
 			} else {
 				log.Infof("[sink/redis] Published to key '%s'", s.key)
+				s.retry.delivered(1)
 			}
-			conn.Close()
 		}
 	}
 }
@@ -0,0 +1,49 @@
+package sink
+
+import (
+	"encoding/json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RegionSink tag every event with the nomad region it came from
+type RegionSink struct {
+	Sink
+	region json.RawMessage
+}
+
+// NewRegion ...
+func NewRegion(s Sink, region string) (*RegionSink, error) {
+	b, err := json.Marshal(region)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegionSink{Sink: s, region: b}, nil
+}
+
+// WaitForAcks ...
+func (s *RegionSink) WaitForAcks() bool {
+	return Acknowledged(s.Sink)
+}
+
+// Put ..
+func (s *RegionSink) Put(data []byte) error {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.Debugf("[sink/region] not tagging non object event: %s", err)
+		return s.Sink.Put(data)
+	}
+
+	// some nomad objects already carry their region
+	if _, ok := event["Region"]; !ok {
+		event["Region"] = s.region
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return s.Sink.Put(b)
+}
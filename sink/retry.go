@@ -0,0 +1,225 @@
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryPolicy publish an event again on transient errors, waiting for an exponential backoff
+// with jitter between attempts, configured by SINK_RETRY_MAX_ATTEMPTS, SINK_RETRY_INITIAL_BACKOFF,
+// SINK_RETRY_MAX_BACKOFF and SINK_RETRY_JITTER
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	jitter         float64
+
+	// stops publishing to a failing destination, when set
+	breaker *circuitBreaker
+
+	// events still failing after the last attempt go there, when set
+	deadLetter *deadLetterQueue
+	// delivered and dead-lettered events are acknowledged there, when set
+	acks *ackTracker
+}
+
+// permanentError is an error that publishing again won't fix (invalid or too large event, ...)
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanent mark an error as not retryable
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// isRetryable tell if publishing again may succeed after the error
+func isRetryable(err error) bool {
+	var p *permanentError
+	return !errors.As(err, &p)
+}
+
+// awsRetryable mark the client errors of the AWS APIs (invalid request, missing permission or
+// resource, ...) as permanent, except for throttling
+func awsRetryable(err error) error {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		code := reqErr.StatusCode()
+		if code >= 400 && code < 500 && code != 429 && !request.IsErrorThrottle(err) {
+			return permanent(err)
+		}
+	}
+	return err
+}
+
+// httpRetryable mark the errors of the responses with a status sending the request again won't
+// fix as permanent, only timeouts (408), rate limiting (429) and server errors (5xx) being retried
+func httpRetryable(statusCode int, err error) error {
+	switch {
+	case statusCode == http.StatusRequestTimeout, statusCode == http.StatusTooManyRequests, statusCode >= 500:
+		return err
+	}
+	return permanent(err)
+}
+
+// googleapiRetryable mark the client errors of the Google Cloud JSON APIs as permanent, like
+// httpRetryable
+func googleapiRetryable(err error) error {
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		return httpRetryable(apiErr.Code, err)
+	}
+	return err
+}
+
+// grpcRetryable mark the gRPC errors calling again won't fix (invalid argument, missing
+// permission or resource, ...) as permanent, the gRPC status being looked up in wrapped errors
+func grpcRetryable(err error) error {
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &grpcErr) {
+		return err
+	}
+
+	switch grpcErr.GRPCStatus().Code() {
+	case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.Unauthenticated, codes.FailedPrecondition, codes.OutOfRange, codes.Unimplemented:
+		return permanent(err)
+	}
+	return err
+}
+
+// newRetryPolicy ...
+func newRetryPolicy() (*retryPolicy, error) {
+	maxAttempts, err := getenvInt("SINK_RETRY_MAX_ATTEMPTS", 5)
+	if err != nil {
+		return nil, err
+	}
+	if maxAttempts < 1 {
+		return nil, fmt.Errorf("Invalid SINK_RETRY_MAX_ATTEMPTS value, must be positive")
+	}
+
+	initialBackoff, err := getenvDuration("SINK_RETRY_INITIAL_BACKOFF", 100*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBackoff, err := getenvDuration("SINK_RETRY_MAX_BACKOFF", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	jitter := 0.2
+	if value := os.Getenv("SINK_RETRY_JITTER"); value != "" {
+		jitter, err = strconv.ParseFloat(value, 64)
+		if err != nil || jitter < 0 || jitter > 1 {
+			return nil, fmt.Errorf("Invalid SINK_RETRY_JITTER value, must be between 0 and 1")
+		}
+	}
+
+	breaker, err := newCircuitBreaker()
+	if err != nil {
+		return nil, err
+	}
+
+	return &retryPolicy{
+		maxAttempts:    maxAttempts,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		jitter:         jitter,
+		breaker:        breaker,
+	}, nil
+}
+
+// maxRetriesFrom let the SINK_<sink>_MAX_RETRIES variable of the sinks retrying before
+// SINK_RETRY_MAX_ATTEMPTS existed take precedence over it, when set
+func (p *retryPolicy) maxRetriesFrom(name string) error {
+	if _, ok := os.LookupEnv(name); !ok {
+		return nil
+	}
+
+	maxRetries, err := getenvInt(name, 0)
+	if err != nil {
+		return err
+	}
+	if maxRetries < 0 {
+		return fmt.Errorf("Invalid %s value, must not be negative", name)
+	}
+
+	p.maxAttempts = maxRetries + 1
+	return nil
+}
+
+// Do call publish until it succeeds, returns a permanent error, or all attempts failed, and
+// return the last error. The attempts go through the circuit breaker
+func (p *retryPolicy) Do(name string, publish func() error) error {
+	backoff := p.initialBackoff
+
+	for attempt := 1; ; attempt++ {
+		if err := p.breaker.allow(name); err != nil {
+			return err
+		}
+
+		err := publish()
+		p.breaker.record(name, err)
+		if err == nil || !isRetryable(err) || attempt >= p.maxAttempts {
+			return err
+		}
+
+		wait := p.withJitter(backoff)
+		log.Warnf("[sink/%s] Attempt %d/%d failed, retrying in %s: %s", name, attempt, p.maxAttempts, wait, err)
+		time.Sleep(wait)
+
+		backoff *= 2
+		if backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+}
+
+// delivered acknowledge n events delivered by the sink
+func (p *retryPolicy) delivered(n int) {
+	p.acks.Ack(n)
+}
+
+// undeliverable put an event the sink gave up on after the error err in the dead-letter queue,
+// which acknowledges it, and the event is lost without one
+func (p *retryPolicy) undeliverable(name string, data []byte, err error) {
+	if p.deadLetter == nil {
+		p.acks.Lose(1)
+		return
+	}
+
+	p.deadLetter.Put(name, data, err)
+	p.acks.Ack(1)
+}
+
+// withJitter spread the backoff by +/- jitter, so writers failing together don't retry together
+func (p *retryPolicy) withJitter(backoff time.Duration) time.Duration {
+	if p.jitter == 0 {
+		return backoff
+	}
+
+	delta := p.jitter * float64(backoff)
+	return time.Duration(float64(backoff) - delta + rand.Float64()*2*delta)
+}
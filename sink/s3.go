@@ -0,0 +1,61 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	log "github.com/sirupsen/logrus"
+)
+
+// S3Sink ...
+type S3Sink struct {
+	*objectBatcher
+
+	session *session.Session
+	s3      *s3.S3
+	bucket  string
+}
+
+// NewS3 ...
+func NewS3() (*S3Sink, error) {
+	bucket := os.Getenv("SINK_S3_BUCKET")
+	if bucket == "" {
+		return nil, fmt.Errorf("[sink/s3] Missing SINK_S3_BUCKET (example: my-nomad-archive)")
+	}
+	log.Infof("[sink/s3] SINK_S3_BUCKET=%s", bucket)
+
+	sess := session.Must(session.NewSession())
+
+	s := &S3Sink{
+		session: sess,
+		s3:      s3.New(sess),
+		bucket:  bucket,
+	}
+
+	batcher, err := newObjectBatcher("s3", "S3", s.upload)
+	if err != nil {
+		return nil, err
+	}
+	s.objectBatcher = batcher
+
+	return s, nil
+}
+
+func (s *S3Sink) upload(key string, body []byte) error {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(s.contentType()),
+	}
+	if encoding := s.contentEncoding(); encoding != "" {
+		input.ContentEncoding = aws.String(encoding)
+	}
+
+	_, err := s.s3.PutObject(input)
+	return awsRetryable(err)
+}
@@ -0,0 +1,82 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// schemaRegistry is a client of the Confluent Schema Registry API
+type schemaRegistry struct {
+	client   *http.Client
+	url      string
+	username string
+	password string
+}
+
+func newSchemaRegistry(registryURL, username, password string) *schemaRegistry {
+	return &schemaRegistry{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		url:      strings.TrimSuffix(registryURL, "/"),
+		username: username,
+		password: password,
+	}
+}
+
+// Register the schema under the subject, or only look up its id when register is false,
+// returning the schema id
+func (r *schemaRegistry) Register(subject, schema string, register bool) (int, error) {
+	path := "/subjects/" + url.PathEscape(subject)
+	if register {
+		path += "/versions"
+	}
+
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest("POST", r.url+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("Schema registry returned status %d for subject %s: %s", resp.StatusCode, subject, b)
+	}
+
+	var result struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return 0, err
+	}
+
+	return result.ID, nil
+}
+
+// schemaRegistryFrame prefix an encoded message with the magic byte and schema id of the
+// Confluent wire format
+func schemaRegistryFrame(id int, message []byte) []byte {
+	framed := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(framed[1:], uint32(id))
+
+	return append(framed, message...)
+}
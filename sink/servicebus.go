@@ -0,0 +1,199 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	log "github.com/sirupsen/logrus"
+)
+
+// ServiceBusSink ...
+type ServiceBusSink struct {
+	client    *azservicebus.Client
+	sender    *azservicebus.Sender
+	entity    string
+	sessionID *payloadTemplate
+	retry     *retryPolicy
+	stopCh    chan interface{}
+	putCh     chan []byte
+}
+
+// NewServiceBus ...
+func NewServiceBus() (*ServiceBusSink, error) {
+	entity := os.Getenv("SINK_SERVICEBUS_ENTITY")
+	if entity == "" {
+		return nil, fmt.Errorf("[sink/servicebus] Missing SINK_SERVICEBUS_ENTITY (name of the queue or topic, example: nomad-firehose)")
+	}
+	log.Infof("[sink/servicebus] SINK_SERVICEBUS_ENTITY=%s", entity)
+
+	var client *azservicebus.Client
+	var err error
+
+	if connStr := os.Getenv("SINK_SERVICEBUS_CONNECTION_STRING"); connStr != "" {
+		client, err = azservicebus.NewClientFromConnectionString(connStr, nil)
+	} else if namespace := os.Getenv("SINK_SERVICEBUS_NAMESPACE"); namespace != "" {
+		log.Infof("[sink/servicebus] SINK_SERVICEBUS_NAMESPACE=%s", namespace)
+
+		// credentials are resolved from the environment, workload or managed identity
+		credential, credErr := azidentity.NewDefaultAzureCredential(nil)
+		if credErr != nil {
+			return nil, fmt.Errorf("[sink/servicebus] Failed to load Azure credentials: %s", credErr)
+		}
+		client, err = azservicebus.NewClient(namespace, credential, nil)
+	} else {
+		return nil, fmt.Errorf("[sink/servicebus] Missing SINK_SERVICEBUS_CONNECTION_STRING or SINK_SERVICEBUS_NAMESPACE (example: my-namespace.servicebus.windows.net)")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[sink/servicebus] Failed to create Service Bus client: %s", err)
+	}
+
+	sender, err := client.NewSender(entity, nil)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/servicebus] Failed to create sender: %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/servicebus] %s", err)
+	}
+
+	s := &ServiceBusSink{
+		client: client,
+		sender: sender,
+		entity: entity,
+		retry:  retry,
+		stopCh: make(chan interface{}),
+		putCh:  make(chan []byte, 1000),
+	}
+
+	if sessionID := os.Getenv("SINK_SERVICEBUS_SESSION_ID"); sessionID != "" {
+		log.Infof("[sink/servicebus] SINK_SERVICEBUS_SESSION_ID=%s", sessionID)
+
+		s.sessionID, err = newPayloadTemplate("session-id", sessionID)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/servicebus] Invalid SINK_SERVICEBUS_SESSION_ID: %s", err)
+		}
+	}
+
+	return s, nil
+}
+
+// Start ...
+func (s *ServiceBusSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	// a single writer, so messages of a session are sent in order
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *ServiceBusSink) Stop() {
+	log.Infof("[sink/servicebus] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/servicebus] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s.sender.Close(ctx)
+	s.client.Close(ctx)
+}
+
+// setDeadLetterQueue ...
+func (s *ServiceBusSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *ServiceBusSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *ServiceBusSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *ServiceBusSink) write() {
+	log.Infof("[sink/servicebus] Starting writer to '%s'", s.entity)
+
+	contentType := "application/json"
+
+	for {
+		select {
+		case data := <-s.putCh:
+			message := &azservicebus.Message{
+				Body:        data,
+				ContentType: &contentType,
+			}
+
+			if s.sessionID != nil {
+				sessionID, err := s.sessionID.Render(data)
+				if err != nil {
+					log.Errorf("[sink/servicebus] Could not render session id: %s", err)
+					s.retry.undeliverable("servicebus", data, err)
+					continue
+				}
+				if sessionID != "" {
+					message.SessionID = &sessionID
+				}
+			}
+
+			err := s.retry.Do("servicebus", func() error {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+
+				return serviceBusRetryable(s.sender.SendMessage(ctx, message, nil))
+			})
+			if err != nil {
+				log.Errorf("[sink/servicebus] %s", err)
+				s.retry.undeliverable("servicebus", data, err)
+				continue
+			}
+
+			log.Debugf("[sink/servicebus] Sent message to '%s'", s.entity)
+			s.retry.delivered(1)
+		}
+	}
+}
+
+// serviceBusRetryable mark the errors sending again won't fix as permanent: too large messages,
+// missing entities and denied access
+func serviceBusRetryable(err error) error {
+	if errors.Is(err, azservicebus.ErrMessageTooLarge) {
+		return permanent(err)
+	}
+
+	var sbErr *azservicebus.Error
+	if errors.As(err, &sbErr) {
+		switch sbErr.Code {
+		case azservicebus.CodeNotFound, azservicebus.CodeUnauthorizedAccess:
+			return permanent(err)
+		}
+	}
+	return err
+}
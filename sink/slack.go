@@ -0,0 +1,259 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// maximum length of the event included in a message, Slack truncates longer texts
+const slackMaxEvent = 3000
+
+// SlackSink post selected events as messages to Slack incoming webhooks
+type SlackSink struct {
+	client       *http.Client
+	webhookURL   string
+	filter       *payloadTemplate
+	text         *payloadTemplate
+	channel      *payloadTemplate
+	includeEvent bool
+	limiter      *rate.Limiter
+	retry        *retryPolicy
+	stopCh       chan interface{}
+	putCh        chan []byte
+}
+
+// slackMessage is the body of an incoming webhook
+type slackMessage struct {
+	Text    string `json:"text"`
+	Channel string `json:"channel,omitempty"`
+}
+
+// NewSlack ...
+func NewSlack() (*SlackSink, error) {
+	webhookURL := os.Getenv("SINK_SLACK_WEBHOOK_URL")
+	if webhookURL == "" {
+		return nil, fmt.Errorf("[sink/slack] Missing SINK_SLACK_WEBHOOK_URL (example: https://hooks.slack.com/services/T000/B000/XXXX)")
+	}
+
+	includeEvent, err := getenvBool("SINK_SLACK_INCLUDE_EVENT", true)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/slack] %s", err)
+	}
+
+	// incoming webhooks accept about one message per second
+	rateLimit, err := getenvInt("SINK_SLACK_RATE_LIMIT", 60)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/slack] %s", err)
+	}
+	if rateLimit < 1 {
+		return nil, fmt.Errorf("[sink/slack] Invalid SINK_SLACK_RATE_LIMIT value, must be positive")
+	}
+	log.Infof("[sink/slack] SINK_SLACK_RATE_LIMIT=%d messages per minute", rateLimit)
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/slack] %s", err)
+	}
+
+	s := &SlackSink{
+		client:       &http.Client{Timeout: 30 * time.Second},
+		webhookURL:   webhookURL,
+		includeEvent: includeEvent,
+		limiter:      rate.NewLimiter(rate.Limit(float64(rateLimit)/60), 5),
+		retry:        retry,
+		stopCh:       make(chan interface{}),
+		putCh:        make(chan []byte, 1000),
+	}
+
+	templates := []struct {
+		env  string
+		def  string
+		dest **payloadTemplate
+	}{
+		{"SINK_SLACK_FILTER", "true", &s.filter},
+		{"SINK_SLACK_TEXT", "Nomad {{ firehose }}{{ with .Type }} *{{ . }}*{{ end }}{{ with .JobID }} for job `{{ . }}`{{ end }}", &s.text},
+		{"SINK_SLACK_CHANNEL", "", &s.channel},
+	}
+
+	for _, t := range templates {
+		value := os.Getenv(t.env)
+		if value == "" {
+			value = t.def
+		}
+
+		tmpl, err := newPayloadTemplate(t.env, value)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/slack] Invalid %s: %s", t.env, err)
+		}
+		*t.dest = tmpl
+	}
+
+	return s, nil
+}
+
+// Start ...
+func (s *SlackSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *SlackSink) Stop() {
+	log.Infof("[sink/slack] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/slack] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+}
+
+// setDeadLetterQueue ...
+func (s *SlackSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *SlackSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *SlackSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *SlackSink) write() {
+	log.Info("[sink/slack] Starting writer")
+
+	for {
+		select {
+		case data := <-s.putCh:
+			webhookURL, message, err := s.message(data)
+			if err != nil {
+				log.Errorf("[sink/slack] %s", err)
+				s.retry.undeliverable("slack", data, err)
+				continue
+			}
+
+			// not selected by the filter
+			if message == nil {
+				s.retry.delivered(1)
+				continue
+			}
+
+			err = s.retry.Do("slack", func() error {
+				s.limiter.Wait(context.Background())
+				return s.post(webhookURL, message)
+			})
+			if err != nil {
+				log.Errorf("[sink/slack] Failed to post message: %s", err)
+				s.retry.undeliverable("slack", data, err)
+				continue
+			}
+
+			log.Debugf("[sink/slack] Posted message '%s'", message.Text)
+			s.retry.delivered(1)
+		}
+	}
+}
+
+// message build the Slack message for an event and the webhook to post it to, or nil if it
+// isn't selected by the filter
+func (s *SlackSink) message(data []byte) (string, *slackMessage, error) {
+	selected, err := s.filter.Render(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not render filter: %s", err)
+	}
+	if selected != "true" {
+		return "", nil, nil
+	}
+
+	text, err := s.text.Render(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not render text: %s", err)
+	}
+
+	channel, err := s.channel.Render(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not render channel: %s", err)
+	}
+
+	// the channel is either a webhook of its own, or a channel override for legacy webhooks
+	webhookURL := s.webhookURL
+	if strings.HasPrefix(channel, "https://") {
+		webhookURL, channel = channel, ""
+	}
+
+	// the event as a code block
+	if s.includeEvent {
+		var event bytes.Buffer
+		if err := json.Indent(&event, data, "", "  "); err != nil {
+			event.Reset()
+			event.Write(data)
+		}
+		text += "\n```\n" + truncate(event.String(), slackMaxEvent) + "\n```"
+	}
+
+	return webhookURL, &slackMessage{Text: text, Channel: channel}, nil
+}
+
+// post a message, waiting for the Retry-After of Slack when rate limited, the errors posting it
+// again won't fix being permanent
+func (s *SlackSink) post(webhookURL string, message *slackMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return permanent(err)
+	}
+
+	resp, err := s.client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || wait < 1 {
+			wait = 1
+		}
+
+		log.Warnf("[sink/slack] Rate limited by Slack, waiting %ds", wait)
+		time.Sleep(time.Duration(wait) * time.Second)
+	}
+
+	if resp.StatusCode >= 300 {
+		return httpRetryable(resp.StatusCode, fmt.Errorf("status %d: %s", resp.StatusCode, b))
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultSNSAttributes are the message attributes available to subscription filter policies by default
+const defaultSNSAttributes = "firehose={{ firehose }},type={{ .Type }},job_id={{ .JobID }}"
+
+// SNSSink ...
+type SNSSink struct {
+	session     *session.Session
+	sns         *sns.SNS
+	topicArn    string
+	attributes  map[string]*payloadTemplate
+	workerCount int
+	metadata    bool
+	retry       *retryPolicy
+	stopCh      chan interface{}
+	putCh       chan []byte
+}
+
+// NewSNS ...
+func NewSNS() (*SNSSink, error) {
+	topicArn := os.Getenv("SINK_SNS_TOPIC_ARN")
+	if topicArn == "" {
+		return nil, fmt.Errorf("[sink/sns] Missing SINK_SNS_TOPIC_ARN (example: arn:aws:sns:us-east-1:123456789012:nomad-firehose)")
+	}
+
+	attributesStr, ok := os.LookupEnv("SINK_SNS_ATTRIBUTES")
+	if !ok {
+		attributesStr = defaultSNSAttributes
+	}
+
+	attributes, err := newPayloadTemplates(attributesStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/sns] Invalid SINK_SNS_ATTRIBUTES: %s", err)
+	}
+
+	workerCount, err := getenvInt("SINK_SNS_WORKERS", 3)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/sns] %s", err)
+	}
+
+	metadata, err := metadataEnabled()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/sns] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/sns] %s", err)
+	}
+
+	sess := session.Must(session.NewSession())
+	svc := sns.New(sess)
+
+	return &SNSSink{
+		session:     sess,
+		sns:         svc,
+		topicArn:    topicArn,
+		attributes:  attributes,
+		workerCount: workerCount,
+		metadata:    metadata,
+		retry:       retry,
+		stopCh:      make(chan interface{}),
+		putCh:       make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *SNSSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	for i := 0; i < s.workerCount; i++ {
+		go s.write(i)
+	}
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *SNSSink) Stop() {
+	log.Infof("[sink/sns] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/sns] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+}
+
+// setDeadLetterQueue ...
+func (s *SNSSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *SNSSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *SNSSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *SNSSink) write(id int) {
+	log.Infof("[sink/sns/%d] Starting writer", id)
+
+	topicArn := aws.String(s.topicArn)
+
+	for {
+		select {
+		case data := <-s.putCh:
+			attributes, err := renderPayloadTemplates(s.attributes, data)
+			if err != nil {
+				log.Errorf("[sink/sns/%d] Could not render attributes: %s", id, err)
+				s.retry.undeliverable("sns", data, err)
+				continue
+			}
+
+			messageAttributes := make(map[string]*sns.MessageAttributeValue, len(attributes))
+			if s.metadata {
+				for name, value := range newEventMetadata(data).Values() {
+					dataType := "String"
+					if name == "modify_index" {
+						dataType = "Number"
+					}
+					messageAttributes[name] = &sns.MessageAttributeValue{
+						DataType:    aws.String(dataType),
+						StringValue: aws.String(value),
+					}
+				}
+			}
+
+			// SINK_SNS_ATTRIBUTES take precedence over the metadata
+			for name, value := range attributes {
+				messageAttributes[name] = &sns.MessageAttributeValue{
+					DataType:    aws.String("String"),
+					StringValue: aws.String(value),
+				}
+			}
+
+			var output *sns.PublishOutput
+			err = s.retry.Do("sns", func() error {
+				var err error
+				output, err = s.sns.Publish(&sns.PublishInput{
+					TopicArn:          topicArn,
+					Message:           aws.String(string(data)),
+					MessageAttributes: messageAttributes,
+				})
+				return awsRetryable(err)
+			})
+
+			if err != nil {
+				log.Errorf("[sink/sns/%d] %s", id, err)
+				s.retry.undeliverable("sns", data, err)
+			} else {
+				log.Debugf("[sink/sns/%d] Published message %s", id, aws.StringValue(output.MessageId))
+				s.retry.delivered(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package sink
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SocketSink write newline delimited JSON to a TCP or Unix domain socket
+type SocketSink struct {
+	network string
+	addr    string
+	conn    net.Conn
+	retry   *retryPolicy
+	stopCh  chan interface{}
+	putCh   chan []byte
+}
+
+// NewSocket ...
+func NewSocket() (*SocketSink, error) {
+	addrStr := os.Getenv("SINK_SOCKET_ADDR")
+	if addrStr == "" {
+		return nil, fmt.Errorf("[sink/socket] Missing SINK_SOCKET_ADDR (example: tcp://127.0.0.1:9000 or unix:///var/run/vector.sock)")
+	}
+	log.Infof("[sink/socket] SINK_SOCKET_ADDR=%s", addrStr)
+
+	u, err := url.Parse(addrStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/socket] Invalid SINK_SOCKET_ADDR: %s", err)
+	}
+
+	var network, addr string
+	switch u.Scheme {
+	case "tcp":
+		network, addr = "tcp", u.Host
+	case "unix":
+		network, addr = "unix", u.Path
+	default:
+		return nil, fmt.Errorf("[sink/socket] Invalid SINK_SOCKET_ADDR scheme: %s, Valid values: tcp or unix", u.Scheme)
+	}
+
+	// events are queued while disconnected
+	buffer, err := getenvInt("SINK_SOCKET_BUFFER", 1000)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/socket] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/socket] %s", err)
+	}
+
+	return &SocketSink{
+		network: network,
+		addr:    addr,
+		retry:   retry,
+		stopCh:  make(chan interface{}),
+		putCh:   make(chan []byte, buffer),
+	}, nil
+}
+
+// Start ...
+func (s *SocketSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *SocketSink) Stop() {
+	log.Infof("[sink/socket] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/socket] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+}
+
+// setDeadLetterQueue ...
+func (s *SocketSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *SocketSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *SocketSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *SocketSink) write() {
+	log.Infof("[sink/socket] Starting writer to %s://%s", s.network, s.addr)
+
+	defer func() {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+
+		case data := <-s.putCh:
+			line := append(append(make([]byte, 0, len(data)+1), data...), '\n')
+
+			// reconnecting between the attempts, the queue buffers the following events
+			err := s.retry.Do("socket", func() error {
+				return s.send(line)
+			})
+			if err != nil {
+				log.Errorf("[sink/socket] %s (%d messages queued)", err, len(s.putCh))
+				s.retry.undeliverable("socket", data, err)
+				continue
+			}
+			s.retry.delivered(1)
+		}
+	}
+}
+
+// send a line, connecting first if needed
+func (s *SocketSink) send(line []byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, 10*time.Second)
+		if err != nil {
+			return err
+		}
+
+		log.Infof("[sink/socket] Connected to %s://%s", s.network, s.addr)
+		s.conn = conn
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := s.conn.Write(line); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+
+	return nil
+}
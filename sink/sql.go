@@ -0,0 +1,276 @@
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// valid (unquoted) table name
+var sqlTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sqlDialect are the differences between the databases supported by SQLSink
+type sqlDialect struct {
+	name string
+
+	// statements creating the table and its indexes, with %s for the table name
+	schema []string
+
+	// placeholder for the n-th (starting at 1) parameter of a statement
+	placeholder func(n int) string
+
+	// expression casting the payload placeholder to the payload column type
+	payload func(placeholder string) string
+
+	// insert batches as a prepared statement executed per row in a transaction, instead of a
+	// single multi-row INSERT, for drivers sending these as one block (example: ClickHouse)
+	prepared bool
+}
+
+// SQLSink inserts events into a SQL table, as JSON payload plus extracted columns, with batched
+// multi-row inserts
+type SQLSink struct {
+	dialect       sqlDialect
+	db            *sql.DB
+	table         string
+	firehose      string
+	batchSize     int
+	flushInterval time.Duration
+	retry         *retryPolicy
+	stopCh        chan interface{}
+	doneCh        chan interface{}
+	putCh         chan []byte
+}
+
+// sqlRow is a single event to insert
+type sqlRow struct {
+	fields    eventFields
+	createdAt time.Time
+	payload   []byte
+}
+
+// newSQL read the table and batching configuration from SINK_<env>_TABLE, SINK_<env>_BATCH_SIZE,
+// SINK_<env>_FLUSH_INTERVAL and SINK_<env>_CREATE_TABLE, and creates the table if needed
+func newSQL(dialect sqlDialect, env string, db *sql.DB) (*SQLSink, error) {
+	table := os.Getenv("SINK_" + env + "_TABLE")
+	if table == "" {
+		table = "nomad_firehose_events"
+	}
+	if !sqlTableName.MatchString(table) {
+		return nil, fmt.Errorf("[sink/%s] Invalid SINK_%s_TABLE: %s", dialect.name, env, table)
+	}
+	log.Infof("[sink/%s] SINK_%s_TABLE=%s", dialect.name, env, table)
+
+	batchSize, err := getenvInt("SINK_"+env+"_BATCH_SIZE", 100)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/%s] %s", dialect.name, err)
+	}
+	if batchSize < 1 {
+		return nil, fmt.Errorf("[sink/%s] Invalid SINK_%s_BATCH_SIZE value, must be positive", dialect.name, env)
+	}
+
+	flushInterval, err := getenvDuration("SINK_"+env+"_FLUSH_INTERVAL", time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/%s] %s", dialect.name, err)
+	}
+
+	createTable, err := getenvBool("SINK_"+env+"_CREATE_TABLE", true)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/%s] %s", dialect.name, err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/%s] %s", dialect.name, err)
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("[sink/%s] Failed to connect: %s", dialect.name, err)
+	}
+
+	if createTable {
+		for _, statement := range dialect.schema {
+			if _, err := db.Exec(strings.Replace(statement, "%s", table, -1)); err != nil {
+				return nil, fmt.Errorf("[sink/%s] Failed to create table %s: %s", dialect.name, table, err)
+			}
+		}
+	}
+
+	return &SQLSink{
+		dialect:       dialect,
+		db:            db,
+		table:         table,
+		firehose:      os.Getenv("SINK_FIREHOSE"),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		retry:         retry,
+		stopCh:        make(chan interface{}),
+		doneCh:        make(chan interface{}),
+		putCh:         make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *SQLSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *SQLSink) Stop() {
+	log.Infof("[sink/%s] ensure writer queue is empty (%d messages left)", s.dialect.name, len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/%s] Waiting for queue to drain - (%d messages left)", s.dialect.name, len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writer inserts the last partial batch when stopping
+	close(s.stopCh)
+	<-s.doneCh
+
+	s.db.Close()
+}
+
+// setDeadLetterQueue ...
+func (s *SQLSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *SQLSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *SQLSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *SQLSink) write() {
+	log.Infof("[sink/%s] Starting writer to table '%s'", s.dialect.name, s.table)
+	defer close(s.doneCh)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]sqlRow, 0, s.batchSize)
+
+	for {
+		select {
+		case <-s.stopCh:
+			s.insert(batch)
+			return
+
+		case <-ticker.C:
+			s.insert(batch)
+			batch = batch[:0]
+
+		case data := <-s.putCh:
+			batch = append(batch, sqlRow{
+				fields:    extractEventFields(data),
+				createdAt: time.Now().UTC(),
+				payload:   data,
+			})
+
+			if len(batch) >= s.batchSize {
+				s.insert(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// insert a batch of rows, retrying failed inserts
+func (s *SQLSink) insert(batch []sqlRow) {
+	if len(batch) == 0 {
+		return
+	}
+
+	err := s.retry.Do(s.dialect.name, func() error {
+		if s.dialect.prepared {
+			return s.insertPrepared(batch)
+		}
+		return s.insertValues(batch)
+	})
+	if err != nil {
+		log.Errorf("[sink/%s] Failed to insert %d events: %s", s.dialect.name, len(batch), err)
+		for _, row := range batch {
+			s.retry.undeliverable(s.dialect.name, row.payload, err)
+		}
+		return
+	}
+
+	log.Debugf("[sink/%s] Inserted %d events", s.dialect.name, len(batch))
+	s.retry.delivered(len(batch))
+}
+
+// insertValues insert a batch of rows with a single multi-row INSERT
+func (s *SQLSink) insertValues(batch []sqlRow) error {
+	const columns = 6
+
+	values := make([]string, 0, len(batch))
+	args := make([]interface{}, 0, len(batch)*columns)
+
+	for i, row := range batch {
+		p := make([]string, columns)
+		for j := range p {
+			p[j] = s.dialect.placeholder(i*columns + j + 1)
+		}
+		p[columns-1] = s.dialect.payload(p[columns-1])
+
+		values = append(values, "("+strings.Join(p, ", ")+")")
+		args = append(args, s.firehose, row.fields.ID, row.fields.Namespace, int64(row.fields.ModifyIndex), row.createdAt, string(row.payload))
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (firehose, event_id, namespace, modify_index, created_at, payload) VALUES %s", s.table, strings.Join(values, ", "))
+
+	_, err := s.db.Exec(query, args...)
+	return err
+}
+
+// insertPrepared insert a batch of rows with a prepared statement in a single transaction
+func (s *SQLSink) insertPrepared(batch []sqlRow) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (firehose, event_id, namespace, modify_index, created_at, payload)", s.table)
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range batch {
+		if _, err := stmt.Exec(s.firehose, row.fields.ID, row.fields.Namespace, row.fields.ModifyIndex, row.createdAt, string(row.payload)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
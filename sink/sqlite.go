@@ -0,0 +1,52 @@
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	// sqlite database/sql driver, pure go so no cgo is needed
+	_ "modernc.org/sqlite"
+)
+
+var sqliteDialect = sqlDialect{
+	name: "sqlite",
+	schema: []string{
+		`CREATE TABLE IF NOT EXISTS %s (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			firehose TEXT NOT NULL,
+			event_id TEXT NOT NULL,
+			namespace TEXT NOT NULL,
+			modify_index INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			payload TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS %s_event_idx ON %s (firehose, event_id, modify_index)`,
+		`CREATE INDEX IF NOT EXISTS %s_created_at_idx ON %s (created_at)`,
+	},
+	placeholder: func(n int) string {
+		return "?"
+	},
+	payload: func(placeholder string) string {
+		return placeholder
+	},
+}
+
+// NewSQLite ...
+func NewSQLite() (*SQLSink, error) {
+	path := os.Getenv("SINK_SQLITE_PATH")
+	if path == "" {
+		return nil, fmt.Errorf("[sink/sqlite] Missing SINK_SQLITE_PATH (example: /var/lib/nomad-firehose/events.db)")
+	}
+
+	// WAL lets other processes (sqlite3 shell, ...) read the events while we write them
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)")
+	if err != nil {
+		return nil, fmt.Errorf("[sink/sqlite] %s", err)
+	}
+
+	// sqlite only has a single writer
+	db.SetMaxOpenConns(1)
+
+	return newSQL(sqliteDialect, "SQLITE", db)
+}
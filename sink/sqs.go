@@ -0,0 +1,437 @@
+package sink
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultSQSAttributes are the message attributes set from the events by default, for Lambda
+// event filters and SNS subscription filter policies
+const defaultSQSAttributes = "firehose={{ firehose }},type={{ .Type }},job_id={{ .JobID }}"
+
+// SQSSink ...
+type SQSSink struct {
+	session         *session.Session
+	sqs             *sqs.SQS
+	queueURL        string
+	fifo            bool
+	groupID         *payloadTemplate
+	deduplicationID *payloadTemplate
+	attributes      map[string]*payloadTemplate
+	workerCount     int
+	retry           *retryPolicy
+	batcher         *eventBatcher
+	compressor      *payloadCompressor
+	encode          func(data []byte) ([]byte, error)
+	encoding        string
+	metadata        bool
+	writers         sync.WaitGroup
+	stopCh          chan interface{}
+	putCh           chan []byte
+}
+
+// NewSQS ...
+func NewSQS() (*SQSSink, error) {
+	queueURL := os.Getenv("SINK_SQS_QUEUE_URL")
+	if queueURL == "" {
+		return nil, fmt.Errorf("[sink/sqs] Missing SINK_SQS_QUEUE_URL (example: https://sqs.us-east-1.amazonaws.com/123456789012/nomad-firehose)")
+	}
+	log.Infof("[sink/sqs] SINK_SQS_QUEUE_URL=%s", queueURL)
+
+	attributesStr, ok := os.LookupEnv("SINK_SQS_ATTRIBUTES")
+	if !ok {
+		attributesStr = defaultSQSAttributes
+	}
+
+	attributes, err := newPayloadTemplates(attributesStr)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/sqs] Invalid SINK_SQS_ATTRIBUTES: %s", err)
+	}
+
+	workerCount, err := getenvInt("SINK_SQS_WORKERS", 1)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/sqs] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/sqs] %s", err)
+	}
+
+	// at most 10 messages and 256 KiB per SendMessageBatch request
+	batcher, err := newEventBatcher("SQS", 1, 10, 256*1024)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/sqs] %s", err)
+	}
+
+	compressor, err := newPayloadCompressor("SQS")
+	if err != nil {
+		return nil, fmt.Errorf("[sink/sqs] %s", err)
+	}
+
+	encode, err := newEventEncoder("SQS")
+	if err != nil {
+		return nil, fmt.Errorf("[sink/sqs] %s", err)
+	}
+
+	metadata, err := metadataEnabled()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/sqs] %s", err)
+	}
+
+	sess := session.Must(session.NewSession())
+
+	s := &SQSSink{
+		session:     sess,
+		sqs:         sqs.New(sess),
+		queueURL:    queueURL,
+		fifo:        strings.HasSuffix(queueURL, ".fifo"),
+		attributes:  attributes,
+		workerCount: workerCount,
+		retry:       retry,
+		batcher:     batcher,
+		compressor:  compressor,
+		encode:      encode,
+		encoding:    getenvEncoding("SQS"),
+		metadata:    metadata,
+		stopCh:      make(chan interface{}),
+		putCh:       make(chan []byte, 1000),
+	}
+
+	if s.fifo {
+		groupID := os.Getenv("SINK_SQS_MESSAGE_GROUP_ID")
+		if groupID == "" {
+			groupID = "{{ .JobID }}"
+		}
+		log.Infof("[sink/sqs] FIFO queue, SINK_SQS_MESSAGE_GROUP_ID=%s", groupID)
+
+		s.groupID, err = newPayloadTemplate("message-group-id", groupID)
+		if err != nil {
+			return nil, fmt.Errorf("[sink/sqs] Invalid SINK_SQS_MESSAGE_GROUP_ID: %s", err)
+		}
+
+		if deduplicationID := os.Getenv("SINK_SQS_MESSAGE_DEDUPLICATION_ID"); deduplicationID != "" {
+			s.deduplicationID, err = newPayloadTemplate("message-deduplication-id", deduplicationID)
+			if err != nil {
+				return nil, fmt.Errorf("[sink/sqs] Invalid SINK_SQS_MESSAGE_DEDUPLICATION_ID: %s", err)
+			}
+		}
+
+		// messages of a group must be sent in order
+		if workerCount > 1 {
+			log.Warnf("[sink/sqs] Using 1 writer instead of %d for FIFO queue", workerCount)
+			s.workerCount = 1
+		}
+	}
+
+	return s, nil
+}
+
+// Start ...
+func (s *SQSSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	for i := 0; i < s.workerCount; i++ {
+		s.writers.Add(1)
+		go s.write(i)
+	}
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *SQSSink) Stop() {
+	log.Infof("[sink/sqs] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/sqs] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	// the writers send their last partial batch
+	close(s.stopCh)
+	s.writers.Wait()
+}
+
+// setDeadLetterQueue ...
+func (s *SQSSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *SQSSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *SQSSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *SQSSink) write(id int) {
+	log.Infof("[sink/sqs/%d] Starting writer", id)
+	defer s.writers.Done()
+
+	queueURL := aws.String(s.queueURL)
+
+	if s.batcher.maxSize > 1 {
+		s.batcher.Run(s.putCh, s.stopCh, func(batch [][]byte) {
+			s.sendBatch(id, batch)
+		})
+		return
+	}
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case data := <-s.putCh:
+			body, attributes, err := s.messageBody(data)
+			if err != nil {
+				log.Errorf("[sink/sqs/%d] %s", id, err)
+				s.retry.undeliverable("sqs", data, err)
+				continue
+			}
+
+			input := &sqs.SendMessageInput{
+				QueueUrl:          queueURL,
+				MessageBody:       body,
+				MessageAttributes: attributes,
+			}
+
+			if s.fifo {
+				input.MessageGroupId, input.MessageDeduplicationId, err = s.fifoAttributes(data)
+				if err != nil {
+					log.Errorf("[sink/sqs/%d] %s", id, err)
+					s.retry.undeliverable("sqs", data, err)
+					continue
+				}
+			}
+
+			var output *sqs.SendMessageOutput
+			err = s.retry.Do("sqs", func() error {
+				var err error
+				output, err = s.sqs.SendMessage(input)
+				return awsRetryable(err)
+			})
+			if err != nil {
+				log.Errorf("[sink/sqs/%d] %s", id, err)
+				s.retry.undeliverable("sqs", data, err)
+			} else {
+				log.Debugf("[sink/sqs/%d] Sent message %s", id, aws.StringValue(output.MessageId))
+				s.retry.delivered(1)
+			}
+		}
+	}
+}
+
+// sendBatch send a batch of messages with SendMessageBatch, sending the messages that failed
+// individually again
+func (s *SQSSink) sendBatch(id int, batch [][]byte) {
+	queueURL := aws.String(s.queueURL)
+
+	// entry id to event, of the messages not sent yet
+	pending := make(map[string][]byte, len(batch))
+	entries := make([]*sqs.SendMessageBatchRequestEntry, 0, len(batch))
+
+	for i, data := range batch {
+		body, attributes, err := s.messageBody(data)
+		if err != nil {
+			log.Errorf("[sink/sqs/%d] %s", id, err)
+			s.retry.undeliverable("sqs", data, err)
+			continue
+		}
+
+		entry := &sqs.SendMessageBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			MessageBody:       body,
+			MessageAttributes: attributes,
+		}
+
+		if s.fifo {
+			entry.MessageGroupId, entry.MessageDeduplicationId, err = s.fifoAttributes(data)
+			if err != nil {
+				log.Errorf("[sink/sqs/%d] %s", id, err)
+				s.retry.undeliverable("sqs", data, err)
+				continue
+			}
+		}
+
+		pending[aws.StringValue(entry.Id)] = data
+		entries = append(entries, entry)
+	}
+
+	err := s.retry.Do("sqs", func() error {
+		if len(entries) == 0 {
+			return nil
+		}
+
+		output, err := s.sqs.SendMessageBatch(&sqs.SendMessageBatchInput{
+			QueueUrl: queueURL,
+			Entries:  entries,
+		})
+		if err != nil {
+			return awsRetryable(err)
+		}
+
+		for _, entry := range output.Successful {
+			delete(pending, aws.StringValue(entry.Id))
+		}
+		s.retry.delivered(len(output.Successful))
+
+		var failed error
+		for _, entry := range output.Failed {
+			entryErr := fmt.Errorf("%s: %s", aws.StringValue(entry.Code), aws.StringValue(entry.Message))
+
+			// the message itself is invalid, sending it again won't help
+			if aws.BoolValue(entry.SenderFault) {
+				log.Errorf("[sink/sqs/%d] %s", id, entryErr)
+				s.retry.undeliverable("sqs", pending[aws.StringValue(entry.Id)], entryErr)
+				delete(pending, aws.StringValue(entry.Id))
+				continue
+			}
+			failed = entryErr
+		}
+
+		remaining := entries[:0]
+		for _, entry := range entries {
+			if _, ok := pending[aws.StringValue(entry.Id)]; ok {
+				remaining = append(remaining, entry)
+			}
+		}
+		entries = remaining
+
+		return failed
+	})
+	if err != nil {
+		log.Errorf("[sink/sqs/%d] Failed to send %d messages: %s", id, len(pending), err)
+		for _, data := range pending {
+			s.retry.undeliverable("sqs", data, err)
+		}
+		return
+	}
+
+	log.Debugf("[sink/sqs/%d] Sent %d messages", id, len(batch))
+}
+
+// messageBody return the body of the message of an event and its attributes: the event
+// metadata, the SINK_SQS_ATTRIBUTES taking precedence over it, a Content-Type attribute when the event isn't sent as JSON, and a Content-Encoding
+// attribute when the body is compressed. Binary bodies are base64 encoded, as SQS messages are
+// text
+func (s *SQSSink) messageBody(data []byte) (*string, map[string]*sqs.MessageAttributeValue, error) {
+	rendered, err := renderPayloadTemplates(s.attributes, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not render attributes: %s", err)
+	}
+
+	attributes := make(map[string]*sqs.MessageAttributeValue)
+
+	if s.metadata {
+		for name, value := range newEventMetadata(data).Values() {
+			dataType := "String"
+			if name == "modify_index" {
+				dataType = "Number"
+			}
+			attributes[name] = &sqs.MessageAttributeValue{
+				DataType:    aws.String(dataType),
+				StringValue: aws.String(value),
+			}
+		}
+	}
+
+	for name, value := range rendered {
+		attributes[name] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+
+	binary := false
+	if s.encode != nil {
+		encoded, err := s.encode(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to encode message: %s", err)
+		}
+
+		attributes["Content-Type"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(eventContentType(s.encoding)),
+		}
+		data = encoded
+		binary = true
+	}
+
+	if s.compressor.Enabled() {
+		compressed, err := s.compressor.Compress(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to compress message: %s", err)
+		}
+
+		attributes["Content-Encoding"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(s.compressor.Encoding()),
+		}
+		data = compressed
+		binary = true
+	}
+
+	// at most 10 attributes per message
+	if len(attributes) > 10 {
+		return nil, nil, fmt.Errorf("Too many message attributes (%d), at most 10 are allowed", len(attributes))
+	}
+
+	if binary {
+		return aws.String(base64.StdEncoding.EncodeToString(data)), attributes, nil
+	}
+	return aws.String(string(data)), attributes, nil
+}
+
+// fifoAttributes return the message group and deduplication id of a FIFO queue message
+func (s *SQSSink) fifoAttributes(data []byte) (*string, *string, error) {
+	groupID, err := s.groupID.Render(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not render message group id: %s", err)
+	}
+
+	// events without the field still need a group
+	if groupID == "" {
+		groupID = os.Getenv("SINK_FIREHOSE")
+	}
+
+	// the same change (same payload, so same modify index) is only delivered once
+	sum := sha256.Sum256(data)
+	deduplicationID := hex.EncodeToString(sum[:])
+
+	if s.deduplicationID != nil {
+		deduplicationID, err = s.deduplicationID.Render(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not render message deduplication id: %s", err)
+		}
+	}
+
+	return aws.String(groupID), aws.String(deduplicationID), nil
+}
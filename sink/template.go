@@ -0,0 +1,128 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// payloadTemplate renders a string (subject, channel, key, ...) from the fields of an event,
+// for example "nomad.{{ .Type }}". Templates without any action are returned as-is without
+// decoding the event
+//
+// The "firehose" function returns the firehose type the sink was created for, "region" the nomad
+// region of the event and "now" the current UTC time (example: {{ now.Format "2006.01.02" }})
+type payloadTemplate struct {
+	text string
+	tmpl *template.Template
+
+	// the template uses the region function, resolved for each event
+	regional bool
+}
+
+// newPayloadTemplate ...
+func newPayloadTemplate(name, text string) (*payloadTemplate, error) {
+	t := &payloadTemplate{text: text, regional: strings.Contains(text, "region")}
+	if !strings.Contains(text, "{{") {
+		return t, nil
+	}
+
+	funcs := template.FuncMap{
+		"firehose": constant(os.Getenv("SINK_FIREHOSE")),
+		"region":   constant(""),
+		"now": func() time.Time {
+			return time.Now().UTC()
+		},
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template '%s': %s", text, err)
+	}
+	t.tmpl = tmpl
+
+	return t, nil
+}
+
+func constant(value string) func() string {
+	return func() string {
+		return value
+	}
+}
+
+// Render the template for the given JSON event, fields missing from the event render as empty
+func (t *payloadTemplate) Render(data []byte) (string, error) {
+	if t.tmpl == nil {
+		return t.text, nil
+	}
+
+	var event interface{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return "", err
+	}
+
+	tmpl := t.tmpl
+	if t.regional {
+		var err error
+		if tmpl, err = t.tmpl.Clone(); err != nil {
+			return "", err
+		}
+		tmpl.Funcs(template.FuncMap{"region": constant(extractEventFields(data).Region)})
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", err
+	}
+
+	return strings.Replace(buf.String(), "<no value>", "", -1), nil
+}
+
+// newPayloadTemplates parse a list of "name=template" pairs separated by comma, for example
+// "type={{ .Type }},job={{ .JobID }}", as used for message attributes and headers
+func newPayloadTemplates(spec string) (map[string]*payloadTemplate, error) {
+	result := make(map[string]*payloadTemplate)
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid pair '%s', expected 'name=template'", pair)
+		}
+
+		t, err := newPayloadTemplate(pair[:i], pair[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		result[pair[:i]] = t
+	}
+
+	return result, nil
+}
+
+// renderPayloadTemplates render all templates for the given JSON event, skipping empty values
+func renderPayloadTemplates(templates map[string]*payloadTemplate, data []byte) (map[string]string, error) {
+	result := make(map[string]string, len(templates))
+
+	for name, t := range templates {
+		value, err := t.Render(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if value == "" {
+			continue
+		}
+		result[name] = value
+	}
+
+	return result, nil
+}
@@ -0,0 +1,57 @@
+package sink
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// getenvTLSConfig read the TLS configuration of a sink from SINK_<env>_TLS, SINK_<env>_TLS_CA,
+// SINK_<env>_TLS_CERT, SINK_<env>_TLS_KEY, SINK_<env>_TLS_SERVER_NAME and SINK_<env>_TLS_SKIP_VERIFY,
+// nil if TLS isn't enabled
+func getenvTLSConfig(env string, enabledByDefault bool) (*tls.Config, error) {
+	prefix := "SINK_" + env + "_TLS"
+
+	enabled, err := getenvBool(prefix, enabledByDefault)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	skipVerify, err := getenvBool(prefix+"_SKIP_VERIFY", false)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		ServerName:         os.Getenv(prefix + "_SERVER_NAME"),
+		InsecureSkipVerify: skipVerify,
+	}
+
+	if ca := os.Getenv(prefix + "_CA"); ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificate found in %s_CA %s", prefix, ca)
+		}
+		config.RootCAs = pool
+	}
+
+	if cert := os.Getenv(prefix + "_CERT"); cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, os.Getenv(prefix+"_KEY"))
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	return config, nil
+}
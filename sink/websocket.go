@@ -0,0 +1,276 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// events buffered per client, slower clients are disconnected
+	websocketClientBuffer = 256
+
+	websocketWriteTimeout = 10 * time.Second
+	websocketPingInterval = 30 * time.Second
+)
+
+// WebSocketSink runs a WebSocket server broadcasting events to all connected clients
+type WebSocketSink struct {
+	server   *http.Server
+	upgrader websocket.Upgrader
+	firehose string
+	lock     sync.Mutex
+	clients  map[*websocketClient]bool
+	acks     *ackTracker
+	stopCh   chan interface{}
+	putCh    chan []byte
+}
+
+// websocketClient is a connection and the filters from its query string
+type websocketClient struct {
+	conn    *websocket.Conn
+	filters map[string][]string
+	sendCh  chan []byte
+}
+
+// NewWebSocket ...
+func NewWebSocket() (*WebSocketSink, error) {
+	addr := os.Getenv("SINK_WEBSOCKET_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	path := os.Getenv("SINK_WEBSOCKET_PATH")
+	if path == "" {
+		path = "/events"
+	}
+	log.Infof("[sink/websocket] Listening on %s%s", addr, path)
+
+	allowed := make(map[string]bool)
+	for _, origin := range strings.Split(os.Getenv("SINK_WEBSOCKET_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowed[origin] = true
+		}
+	}
+
+	s := &WebSocketSink{
+		firehose: os.Getenv("SINK_FIREHOSE"),
+		clients:  make(map[*websocketClient]bool),
+		stopCh:   make(chan interface{}),
+		putCh:    make(chan []byte, 1000),
+	}
+
+	s.upgrader = websocket.Upgrader{
+		// any origin unless restricted
+		CheckOrigin: func(r *http.Request) bool {
+			return len(allowed) == 0 || allowed[r.Header.Get("Origin")]
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, s.handle)
+	s.server = &http.Server{Addr: addr, Handler: mux}
+
+	return s, nil
+}
+
+// Start ...
+func (s *WebSocketSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	go func() {
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("[sink/websocket] %s", err)
+		}
+	}()
+
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *WebSocketSink) Stop() {
+	log.Infof("[sink/websocket] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/websocket] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+	s.server.Close()
+
+	s.lock.Lock()
+	for client := range s.clients {
+		s.remove(client)
+	}
+	s.lock.Unlock()
+}
+
+// setAckTracker ...
+func (s *WebSocketSink) setAckTracker(acks *ackTracker) {
+	s.acks = acks
+}
+
+// Put ..
+func (s *WebSocketSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+// handle a new client connection
+func (s *WebSocketSink) handle(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Errorf("[sink/websocket] %s", err)
+		return
+	}
+
+	client := &websocketClient{
+		conn:    conn,
+		filters: r.URL.Query(),
+		sendCh:  make(chan []byte, websocketClientBuffer),
+	}
+
+	s.lock.Lock()
+	s.clients[client] = true
+	log.Infof("[sink/websocket] Client %s connected (%d clients)", conn.RemoteAddr(), len(s.clients))
+	s.lock.Unlock()
+
+	go s.clientWrite(client)
+	go s.clientRead(client)
+}
+
+// clientRead discard messages from the client, and detect it going away
+func (s *WebSocketSink) clientRead(client *websocketClient) {
+	defer func() {
+		s.lock.Lock()
+		s.remove(client)
+		s.lock.Unlock()
+	}()
+
+	client.conn.SetReadDeadline(time.Now().Add(2 * websocketPingInterval))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(2 * websocketPingInterval))
+	})
+
+	for {
+		if _, _, err := client.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// clientWrite send the events and pings to the client
+func (s *WebSocketSink) clientWrite(client *websocketClient) {
+	ticker := time.NewTicker(websocketPingInterval)
+	defer ticker.Stop()
+	defer client.conn.Close()
+
+	for {
+		select {
+		case data, ok := <-client.sendCh:
+			client.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+			if !ok {
+				client.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				return
+			}
+
+			if err := client.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// remove a client, the lock must be held
+func (s *WebSocketSink) remove(client *websocketClient) {
+	if !s.clients[client] {
+		return
+	}
+
+	delete(s.clients, client)
+	close(client.sendCh)
+
+	log.Infof("[sink/websocket] Client %s disconnected (%d clients)", client.conn.RemoteAddr(), len(s.clients))
+}
+
+func (s *WebSocketSink) write() {
+	log.Info("[sink/websocket] Starting writer")
+
+	for {
+		select {
+		case data := <-s.putCh:
+			var event map[string]interface{}
+			json.Unmarshal(data, &event)
+
+			s.lock.Lock()
+			for client := range s.clients {
+				if !s.matches(client.filters, event) {
+					continue
+				}
+
+				select {
+				case client.sendCh <- data:
+				default:
+					log.Warnf("[sink/websocket] Client %s is too slow, disconnecting", client.conn.RemoteAddr())
+					s.remove(client)
+				}
+			}
+			s.lock.Unlock()
+
+			// there is nothing to deliver again to the clients connected later
+			s.acks.Ack(1)
+		}
+	}
+}
+
+// matches check the event against the filters of a client, every filter must match one of its values.
+// "firehose" filters on the firehose type, other filters on top level fields of the event
+func (s *WebSocketSink) matches(filters map[string][]string, event map[string]interface{}) bool {
+	for name, values := range filters {
+		actual := s.firehose
+		if name != "firehose" {
+			actual = fmt.Sprint(event[name])
+		}
+
+		found := false
+		for _, value := range values {
+			if value == actual {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
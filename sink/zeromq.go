@@ -0,0 +1,154 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+	log "github.com/sirupsen/logrus"
+)
+
+// ZeroMQSink publish events on a ZeroMQ PUB socket, as two frame messages of a topic and the
+// event, so subscribers can SUBSCRIBE to the topic prefixes they are interested in
+type ZeroMQSink struct {
+	socket zmq4.Socket
+	addr   string
+	topic  *payloadTemplate
+	retry  *retryPolicy
+	stopCh chan interface{}
+	putCh  chan []byte
+}
+
+// NewZeroMQ ...
+func NewZeroMQ() (*ZeroMQSink, error) {
+	addr := os.Getenv("SINK_ZEROMQ_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("[sink/zeromq] Missing SINK_ZEROMQ_ADDR (example: tcp://*:5556)")
+	}
+	log.Infof("[sink/zeromq] SINK_ZEROMQ_ADDR=%s", addr)
+
+	topicName := os.Getenv("SINK_ZEROMQ_TOPIC")
+	if topicName == "" {
+		topicName = "{{ firehose }}"
+	}
+	log.Infof("[sink/zeromq] SINK_ZEROMQ_TOPIC=%s", topicName)
+
+	topic, err := newPayloadTemplate("topic", topicName)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/zeromq] Invalid SINK_ZEROMQ_TOPIC: %s", err)
+	}
+
+	connect, err := getenvBool("SINK_ZEROMQ_CONNECT", false)
+	if err != nil {
+		return nil, fmt.Errorf("[sink/zeromq] %s", err)
+	}
+
+	retry, err := newRetryPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("[sink/zeromq] %s", err)
+	}
+
+	socket := zmq4.NewPub(context.Background())
+
+	// bind for subscribers to connect, or connect to a forwarder (XSUB) socket
+	if connect {
+		err = socket.Dial(addr)
+	} else {
+		err = socket.Listen(addr)
+	}
+	if err != nil {
+		socket.Close()
+		return nil, fmt.Errorf("[sink/zeromq] Failed to open socket: %s", err)
+	}
+
+	return &ZeroMQSink{
+		socket: socket,
+		addr:   addr,
+		topic:  topic,
+		retry:  retry,
+		stopCh: make(chan interface{}),
+		putCh:  make(chan []byte, 1000),
+	}, nil
+}
+
+// Start ...
+func (s *ZeroMQSink) Start() error {
+	// Stop chan for all tasks to depend on
+	s.stopCh = make(chan interface{})
+
+	// a zmq socket must only be used from a single goroutine
+	go s.write()
+
+	// wait forever for a stop signal to happen
+	for {
+		select {
+		case <-s.stopCh:
+			break
+		}
+		break
+	}
+
+	return nil
+}
+
+// Stop ...
+func (s *ZeroMQSink) Stop() {
+	log.Infof("[sink/zeromq] ensure writer queue is empty (%d messages left)", len(s.putCh))
+
+	for len(s.putCh) > 0 {
+		log.Infof("[sink/zeromq] Waiting for queue to drain - (%d messages left)", len(s.putCh))
+		time.Sleep(1 * time.Second)
+	}
+
+	close(s.stopCh)
+	s.socket.Close()
+}
+
+// setDeadLetterQueue ...
+func (s *ZeroMQSink) setDeadLetterQueue(queue *deadLetterQueue) {
+	s.retry.deadLetter = queue
+}
+
+// setAckTracker ...
+func (s *ZeroMQSink) setAckTracker(acks *ackTracker) {
+	s.retry.acks = acks
+}
+
+// Put ..
+func (s *ZeroMQSink) Put(data []byte) error {
+	s.putCh <- data
+
+	return nil
+}
+
+func (s *ZeroMQSink) write() {
+	log.Infof("[sink/zeromq] Starting writer on '%s'", s.addr)
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+
+		case data := <-s.putCh:
+			topic, err := s.topic.Render(data)
+			if err != nil {
+				log.Errorf("[sink/zeromq] Could not render topic: %s", err)
+				s.retry.undeliverable("zeromq", data, err)
+				continue
+			}
+
+			// only a failing socket fails, events without subscribers are dropped by zmq
+			err = s.retry.Do("zeromq", func() error {
+				return s.socket.Send(zmq4.NewMsgFrom([]byte(topic), data))
+			})
+			if err != nil {
+				log.Errorf("[sink/zeromq] %s", err)
+				s.retry.undeliverable("zeromq", data, err)
+				continue
+			}
+			s.retry.delivered(1)
+		}
+	}
+}